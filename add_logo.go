@@ -1,18 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"compress-pdfium/util"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"image"
 	"image/draw"
-	"image/png"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"math"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/klippa-app/go-pdfium"
 	"github.com/klippa-app/go-pdfium/references"
 	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/responses"
 	"github.com/klippa-app/go-pdfium/structs"
 )
 
@@ -23,22 +32,27 @@ type BitmapCreateResponse struct {
 	height      int
 }
 
+// toNRGBA 转为起点为 (0,0) 的非预乘 NRGBA，pdfium 的 BGRA 位图不是预乘格式
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) {
+		return nrgba
+	}
+	b := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+	return nrgba
+}
+
 func CreateBitmapFromImage(instance pdfium.Pdfium, img image.Image, alpha int) (BitmapCreateResponse, error) {
 	var res BitmapCreateResponse
 
-	// 确保图像为RGBA格式
-	var rgbaImg *image.RGBA
-	if rgba, ok := img.(*image.RGBA); ok {
-		rgbaImg = rgba
-	} else {
-		// 如果图像不是RGBA格式，则进行转换
-		rgbaImg = image.NewRGBA(img.Bounds())
-		draw.Draw(rgbaImg, rgbaImg.Bounds(), img, image.Point{}, draw.Src)
+	nrgbaImg := toNRGBA(img)
+	watermarkWidth := nrgbaImg.Rect.Dx()
+	watermarkHeight := nrgbaImg.Rect.Dy()
+	if watermarkWidth == 0 || watermarkHeight == 0 {
+		return res, errors.New("水印图片为空")
 	}
 
-	watermarkWidth := rgbaImg.Rect.Dx()
-	watermarkHeight := rgbaImg.Rect.Dy()
-
 	watermarkBitmap, err := instance.FPDFBitmap_Create(&requests.FPDFBitmap_Create{
 		Width:  watermarkWidth,
 		Height: watermarkHeight,
@@ -67,377 +81,1210 @@ func CreateBitmapFromImage(instance pdfium.Pdfium, img image.Image, alpha int) (
 	}
 
 	stride := int(watermarkStride.Stride)
-	// 将PNG图像数据复制到FPDF_BITMAP
+	// 将图像数据复制到FPDF_BITMAP
 	// - width, height 表示图像的宽和高
 	// - buffer 是一个字节切片，表示FPDF_BITMAP的内存区域
 	// - stride 表示每行的字节跨度（可能包括填充）
 	for y := 0; y < watermarkHeight; y++ {
+		srcStart := y * nrgbaImg.Stride
+		dstStart := y * stride
 		for x := 0; x < watermarkWidth; x++ {
 			// 计算源图像和目标缓冲区的索引
-			srcIndex := y*watermarkWidth*4 + x*4
-			dstIndex := y*stride + x*4
+			srcIndex := srcStart + x*4
+			dstIndex := dstStart + x*4
 
 			// 复制Alpha通道
-			watermarkBuffer.Buffer[dstIndex+3] = rgbaImg.Pix[srcIndex+3]
+			watermarkBuffer.Buffer[dstIndex+3] = nrgbaImg.Pix[srcIndex+3]
 
 			// 交换红色和蓝色通道，并复制绿色通道
-			watermarkBuffer.Buffer[dstIndex] = rgbaImg.Pix[srcIndex+2]   // Blue
-			watermarkBuffer.Buffer[dstIndex+1] = rgbaImg.Pix[srcIndex+1] // Green
-			watermarkBuffer.Buffer[dstIndex+2] = rgbaImg.Pix[srcIndex]   // Red
+			watermarkBuffer.Buffer[dstIndex] = nrgbaImg.Pix[srcIndex+2]   // Blue
+			watermarkBuffer.Buffer[dstIndex+1] = nrgbaImg.Pix[srcIndex+1] // Green
+			watermarkBuffer.Buffer[dstIndex+2] = nrgbaImg.Pix[srcIndex]   // Red
 		}
 	}
 
 	return res, nil
 }
 
-func CreateBitmapFromFile(instance pdfium.Pdfium, imgPath string, alpha int) (BitmapCreateResponse, error) {
-	var res BitmapCreateResponse
-	// 获取水印图片
-	f, err := os.Open(imgPath)
+// logoFile 水印图片文件，格式由 image.DecodeConfig 识别，支持 PNG、JPEG、GIF
+type logoFile struct {
+	data        []byte
+	format      string
+	width       int // 按 EXIF 方向摆正后的宽高
+	height      int
+	orientation int // JPEG 的 EXIF 方向，其他格式为 1
+}
+
+func readLogo(imgPath string) (*logoFile, error) {
+	data, err := os.ReadFile(imgPath)
 	if err != nil {
-		return res, errors.New("打开水印图片失败")
+		return nil, fmt.Errorf("打开水印图片失败: %v", err)
 	}
-	defer f.Close()
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("无法识别水印图片格式，支持 PNG、JPEG、GIF: %v", err)
+	}
+
+	logo := &logoFile{data: data, format: format, width: config.Width, height: config.Height, orientation: 1}
+	if format == "jpeg" {
+		logo.orientation = util.JPEGOrientation(data)
+		if logo.orientation >= 5 {
+			logo.width, logo.height = logo.height, logo.width
+		}
+	}
+	return logo, nil
+}
 
-	img, err := png.Decode(f)
+// decode 解码并按 EXIF 方向摆正，GIF 取第一帧
+func (l *logoFile) decode() (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(l.data))
 	if err != nil {
-		return res, err
+		return nil, fmt.Errorf("无法解码水印图片: %v", err)
+	}
+	return util.ApplyOrientation(img, l.orientation), nil
+}
+
+// decodeLogo 读取水印图片
+func decodeLogo(imgPath string) (image.Image, error) {
+	logo, err := readLogo(imgPath)
+	if err != nil {
+		return nil, err
+	}
+	return logo.decode()
+}
+
+// orientationMatrix 把单位正方形中按原始方向存放的 JPEG 摆正，EXIF 方向含义见 util.ApplyOrientation
+func orientationMatrix(orientation int) structs.FPDF_FS_MATRIX {
+	switch orientation {
+	case 2:
+		return structs.FPDF_FS_MATRIX{A: -1, D: 1, E: 1}
+	case 3:
+		return structs.FPDF_FS_MATRIX{A: -1, D: -1, E: 1, F: 1}
+	case 4:
+		return structs.FPDF_FS_MATRIX{A: 1, D: -1, F: 1}
+	case 5:
+		return structs.FPDF_FS_MATRIX{B: -1, C: -1, E: 1, F: 1}
+	case 6:
+		return structs.FPDF_FS_MATRIX{B: -1, C: 1, F: 1}
+	case 7:
+		return structs.FPDF_FS_MATRIX{B: 1, C: 1}
+	case 8:
+		return structs.FPDF_FS_MATRIX{B: 1, C: -1, E: 1}
+	}
+	return structs.FPDF_FS_MATRIX{A: 1, D: 1}
+}
+
+// multiply 返回先做 n 再做 m 的变换
+func multiply(m, n structs.FPDF_FS_MATRIX) structs.FPDF_FS_MATRIX {
+	return structs.FPDF_FS_MATRIX{
+		A: m.A*n.A + m.C*n.B,
+		B: m.B*n.A + m.D*n.B,
+		C: m.A*n.C + m.C*n.D,
+		D: m.B*n.C + m.D*n.D,
+		E: m.A*n.E + m.C*n.F + m.E,
+		F: m.B*n.E + m.D*n.F + m.F,
+	}
+}
+
+func CreateBitmapFromFile(instance pdfium.Pdfium, imgPath string, alpha int) (BitmapCreateResponse, error) {
+	img, err := decodeLogo(imgPath)
+	if err != nil {
+		return BitmapCreateResponse{}, err
 	}
 
 	return CreateBitmapFromImage(instance, img, alpha)
 }
 
+// CreateImageObject 读取水印图片并创建图片对象，调用方负责销毁 bitmapRef，
+// 图片对象插入页面后由页面管理。不需要旋转的 JPEG 直接嵌入原始数据，此时 bitmapRef 为空
 func CreateImageObject(instance pdfium.Pdfium, pdfDoc references.FPDF_DOCUMENT, imgPath string, alpha int) (BitmapCreateResponse, error) {
-	var res BitmapCreateResponse
-	// 获取水印图片
-	watermarkTemp, err := os.Open(imgPath)
+	logo, err := readLogo(imgPath)
 	if err != nil {
+		return BitmapCreateResponse{}, err
+	}
+	if logo.format == "jpeg" && logo.orientation == 1 {
+		res := BitmapCreateResponse{width: logo.width, height: logo.height}
+		res.imageObjRef, err = newJPEGObject(instance, pdfDoc, logo.data)
 		return res, err
 	}
-	defer watermarkTemp.Close()
 
-	watermark, err := png.Decode(watermarkTemp)
+	img, err := logo.decode()
+	if err != nil {
+		return BitmapCreateResponse{}, err
+	}
+	res, err := CreateBitmapFromImage(instance, img, alpha)
 	if err != nil {
 		return res, err
 	}
 
-	// 确保图像为RGBA格式
-	var rgbaImg *image.RGBA
-	if rgba, ok := watermark.(*image.RGBA); ok {
-		rgbaImg = rgba
-	} else {
-		// 如果图像不是RGBA格式，则进行转换
-		rgbaImg = image.NewRGBA(watermark.Bounds())
-		draw.Draw(rgbaImg, rgbaImg.Bounds(), watermark, image.Point{}, draw.Src)
-	}
+	res.imageObjRef, err = newImageObject(instance, pdfDoc, res.bitmapRef)
+	return res, err
+}
 
-	// 限制水印长宽不超过3000
-	watermarkWidth, watermarkHeight := 595, 842
-	if rgbaImg.Rect.Dx() > 0 && rgbaImg.Rect.Dy() > 0 {
-		watermarkWidth = rgbaImg.Rect.Dx()
-		watermarkHeight = rgbaImg.Rect.Dy()
+// newImageObject 用位图创建新的图片对象
+func newImageObject(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, bitmap references.FPDF_BITMAP) (references.FPDF_PAGEOBJECT, error) {
+	imageObj, err := instance.FPDFPageObj_NewImageObj(&requests.FPDFPageObj_NewImageObj{
+		Document: document,
+	})
+	if err != nil {
+		return "", err
 	}
 
-	watermarkBitmap, err := instance.FPDFBitmap_Create(&requests.FPDFBitmap_Create{
-		Width:  watermarkWidth,
-		Height: watermarkHeight,
-		Alpha:  alpha,
+	// 将图片加载到ImageObject中，ImageObject是Page中的图片对象
+	_, err = instance.FPDFImageObj_SetBitmap(&requests.FPDFImageObj_SetBitmap{
+		ImageObject: imageObj.PageObject,
+		Bitmap:      bitmap,
 	})
 	if err != nil {
-		return res, err
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: imageObj.PageObject,
+		})
+		return "", err
 	}
+	return imageObj.PageObject, nil
+}
 
-	res.width = watermarkWidth
-	res.height = watermarkHeight
-	res.bitmapRef = watermarkBitmap.Bitmap
-
-	watermarkBuffer, err := instance.FPDFBitmap_GetBuffer(&requests.FPDFBitmap_GetBuffer{
-		Bitmap: watermarkBitmap.Bitmap,
+// newJPEGObject 用 JPEG 原始数据创建图片对象，保存时以 DCTDecode 原样写入，不解码也不重新压缩
+func newJPEGObject(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, data []byte) (references.FPDF_PAGEOBJECT, error) {
+	imageObj, err := instance.FPDFPageObj_NewImageObj(&requests.FPDFPageObj_NewImageObj{
+		Document: document,
 	})
 	if err != nil {
-		return res, err
+		return "", err
 	}
 
-	watermarkStride, err := instance.FPDFBitmap_GetStride(&requests.FPDFBitmap_GetStride{
-		Bitmap: watermarkBitmap.Bitmap,
+	_, err = instance.FPDFImageObj_LoadJpegFileInline(&requests.FPDFImageObj_LoadJpegFileInline{
+		ImageObject: imageObj.PageObject,
+		FileData:    data,
 	})
 	if err != nil {
-		return res, err
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: imageObj.PageObject,
+		})
+		return "", fmt.Errorf("无法嵌入 JPEG 水印: %v", err)
 	}
+	return imageObj.PageObject, nil
+}
 
-	stride := int(watermarkStride.Stride)
-	// 将PNG图像数据复制到FPDF_BITMAP
-	// - width, height 表示图像的宽和高
-	// - buffer 是一个字节切片，表示FPDF_BITMAP的内存区域
-	// - stride 表示每行的字节跨度（可能包括填充）
-	for y := 0; y < watermarkHeight; y++ {
-		srcStart := y * watermarkWidth * 4
-		dstStart := y * stride
-		for x := 0; x < watermarkWidth; x++ {
-			// 计算源图像和目标缓冲区的索引
-			srcIndex := srcStart + x*4
-			dstIndex := dstStart + x*4
+// Anchor 水印在页面上的锚点
+type Anchor string
 
-			// 复制Alpha通道
-			watermarkBuffer.Buffer[dstIndex+3] = rgbaImg.Pix[srcIndex+3]
+const (
+	AnchorTopLeft     Anchor = "top-left"
+	AnchorTop         Anchor = "top"
+	AnchorTopRight    Anchor = "top-right"
+	AnchorLeft        Anchor = "left"
+	AnchorCenter      Anchor = "center"
+	AnchorRight       Anchor = "right"
+	AnchorBottomLeft  Anchor = "bottom-left"
+	AnchorBottom      Anchor = "bottom"
+	AnchorBottomRight Anchor = "bottom-right"
+)
 
-			// 交换红色和蓝色通道，并复制绿色通道
-			watermarkBuffer.Buffer[dstIndex] = rgbaImg.Pix[srcIndex+2]   // Blue
-			watermarkBuffer.Buffer[dstIndex+1] = rgbaImg.Pix[srcIndex+1] // Green
-			watermarkBuffer.Buffer[dstIndex+2] = rgbaImg.Pix[srcIndex]   // Red
+// anchorFactors 锚点在水平、垂直方向上的位置，0 为左/下边缘，0.5 居中，1 为右/上边缘
+var anchorFactors = map[Anchor][2]float64{
+	AnchorTopLeft:     {0, 1},
+	AnchorTop:         {0.5, 1},
+	AnchorTopRight:    {1, 1},
+	AnchorLeft:        {0, 0.5},
+	AnchorCenter:      {0.5, 0.5},
+	AnchorRight:       {1, 0.5},
+	AnchorBottomLeft:  {0, 0},
+	AnchorBottom:      {0.5, 0},
+	AnchorBottomRight: {1, 0},
+}
+
+// ParseAnchor 解析锚点名称，如 bottom-right
+func ParseAnchor(s string) (Anchor, error) {
+	a := Anchor(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := anchorFactors[a]; !ok {
+		return "", fmt.Errorf("未知的位置 %q，可选 top-left、top、top-right、left、center、right、bottom-left、bottom、bottom-right", s)
+	}
+	return a, nil
+}
+
+// parseAnchors 解析逗号分隔的多个位置，s 为空时返回 nil
+func parseAnchors(s string) ([]Anchor, error) {
+	var anchors []Anchor
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
 		}
+		a, err := ParseAnchor(part)
+		if err != nil {
+			return nil, err
+		}
+		anchors = append(anchors, a)
+	}
+	return anchors, nil
+}
+
+// Length 长度，单位为点（1/72 英寸），Percent 为 true 时为页面对应边长的百分比
+type Length struct {
+	Value   float64
+	Percent bool
+}
+
+// ParseLength 解析长度，如 "20"、"20pt"、"5%"
+func ParseLength(s string) (Length, error) {
+	s = strings.TrimSpace(s)
+	var l Length
+	switch {
+	case strings.HasSuffix(s, "%"):
+		l.Percent, s = true, strings.TrimSuffix(s, "%")
+	case strings.HasSuffix(s, "pt"):
+		s = strings.TrimSuffix(s, "pt")
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return Length{}, fmt.Errorf("无效的长度 %q，应为点数（如 20）或百分比（如 5%%）", s)
 	}
+	l.Value = v
+	return l, nil
+}
+
+// Points 换算为点，total 为页面对应边长
+func (l Length) Points(total float64) float64 {
+	if l.Percent {
+		return total * l.Value / 100
+	}
+	return l.Value
+}
 
-	watermarkImageObj, err := instance.FPDFPageObj_NewImageObj(&requests.FPDFPageObj_NewImageObj{
-		Document: pdfDoc,
+func (l Length) String() string {
+	v := strconv.FormatFloat(l.Value, 'f', -1, 64)
+	if l.Percent {
+		return v + "%"
+	}
+	return v
+}
+
+// Set 实现 flag.Value
+func (l *Length) Set(s string) error {
+	v, err := ParseLength(s)
+	if err != nil {
+		return err
+	}
+	*l = v
+	return nil
+}
+
+func (l Length) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *Length) UnmarshalText(text []byte) error {
+	return l.Set(string(text))
+}
+
+// UnmarshalJSON 同时接受数字（点）和字符串（如 "5%"）
+func (l *Length) UnmarshalJSON(data []byte) error {
+	var v float64
+	if err := json.Unmarshal(data, &v); err == nil {
+		*l = Length{Value: v}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("无效的长度 %s", data)
+	}
+	return l.Set(s)
+}
+
+// ZOrder 水印与页面原有内容的上下关系
+type ZOrder string
+
+const (
+	ZOrderFront ZOrder = "front" // 在原有内容之上
+	ZOrderBack  ZOrder = "back"  // 在原有内容之下，会被不透明的内容遮挡
+)
+
+// WatermarkOptions 水印的位置、大小和外观
+type WatermarkOptions struct {
+	Anchor   Anchor  `json:"anchor"`
+	MarginX  Length  `json:"margin_x"` // 到锚点所在左/右边缘的距离，百分比相对页面宽度，水平居中时忽略
+	MarginY  Length  `json:"margin_y"` // 到锚点所在上/下边缘的距离，百分比相对页面高度，垂直居中时忽略
+	Size     float64 `json:"size"`     // 水印宽度占页面短边的比例，横向和纵向页面上大小一致
+	Rotation float64 `json:"rotation"` // 绕水印中心逆时针旋转的角度
+	Opacity  float64 `json:"opacity"`  // 不透明度，0-1
+	// ZOrder 为 back 时只支持单个内容流的页面。其他软件生成的 PDF 中 /Contents 常是多个内容流组成的数组，
+	// 这类页面（以及对象过多的页面）会直接报错，不做修改，需改用 front
+	ZOrder ZOrder `json:"z_order"`
+
+	Tile     bool   `json:"tile"`      // 在整页上重复平铺，此时忽略 Anchor 和边距，网格随 Rotation 旋转
+	SpacingX Length `json:"spacing_x"` // 平铺时同一行相邻水印的间距，百分比相对页面宽度
+	SpacingY Length `json:"spacing_y"` // 平铺时相邻两行的间距，百分比相对页面高度
+	Stagger  bool   `json:"stagger"`   // 平铺时隔行错开半格，形成斜向网格
+
+	// Auto 以低分辨率渲染每一页，结合文字位置，在 Candidates 中选择被原有内容覆盖最少的位置，
+	// 一样空时优先取靠前的。Candidates 为空时依次为 Anchor、右下、左下、右上、左上
+	Auto       bool     `json:"auto"`
+	Candidates []Anchor `json:"candidates,omitempty"`
+
+	// ID 水印标识，写入水印对象的标记中。再次添加同一标识的水印时替换旧的，
+	// 为空时图片水印为 logo、文字水印为 text
+	ID string `json:"id,omitempty"`
+
+	// Contrast 采样水印下方已渲染的页面背景，对比度低于 MinContrast 时的处理：
+	// variant 换用明暗相反的变体（图片水印优先使用 VariantLogo，否则把亮度取反），
+	// plate 垫一块四周各大 PlatePadding 的底板，outline 加宽 OutlineWidth 点的描边。
+	// 底板、描边的颜色为 ContrastColor，未指定时取白色或黑色中与水印对比度高的
+	Contrast      ContrastMode `json:"contrast,omitempty"`
+	MinContrast   float64      `json:"min_contrast,omitempty"`
+	VariantLogo   string       `json:"-"` // 本地文件路径，不从请求参数读取
+	ContrastColor Color        `json:"contrast_color"`
+	PlatePadding  Length       `json:"plate_padding"` // 百分比相对水印宽度
+	OutlineWidth  float64      `json:"outline_width"`
+}
+
+// DefaultWatermarkOptions 默认放在右下角，与原先固定的位置和大小相近
+func DefaultWatermarkOptions() WatermarkOptions {
+	return WatermarkOptions{
+		Anchor:  AnchorBottomRight,
+		MarginX: Length{Value: 3.5, Percent: true},
+		MarginY: Length{Value: 1.4, Percent: true},
+		Size:    0.1,
+		Opacity: 1,
+		ZOrder:  ZOrderFront,
+
+		SpacingX: Length{Value: 10, Percent: true},
+		SpacingY: Length{Value: 10, Percent: true},
+
+		Contrast:     ContrastNone,
+		MinContrast:  defaultMinContrast,
+		PlatePadding: Length{Value: 4},
+		OutlineWidth: 1.5,
+	}
+}
+
+// Validate 检查参数范围，Anchor、ZOrder 为空时使用默认值
+func (o *WatermarkOptions) Validate() error {
+	if o.Anchor == "" {
+		o.Anchor = AnchorBottomRight
+	}
+	if _, ok := anchorFactors[o.Anchor]; !ok {
+		return fmt.Errorf("未知的位置: %s", o.Anchor)
+	}
+	if o.ZOrder == "" {
+		o.ZOrder = ZOrderFront
+	}
+	if o.ZOrder != ZOrderFront && o.ZOrder != ZOrderBack {
+		return fmt.Errorf("未知的层级 %q，可选 front、back", o.ZOrder)
+	}
+	if o.Size <= 0 || o.Size > 1 {
+		return fmt.Errorf("size 必须在 0-1 之间: %g", o.Size)
+	}
+	if o.Opacity <= 0 || o.Opacity > 1 {
+		return fmt.Errorf("opacity 必须在 0-1 之间: %g", o.Opacity)
+	}
+	if o.SpacingX.Value < 0 || o.SpacingY.Value < 0 {
+		return fmt.Errorf("平铺间距不能小于 0: %s, %s", o.SpacingX, o.SpacingY)
+	}
+	if o.Auto && o.Tile {
+		return errors.New("平铺时不能自动选择位置")
+	}
+	for _, a := range o.Candidates {
+		if _, ok := anchorFactors[a]; !ok {
+			return fmt.Errorf("未知的候选位置: %s", a)
+		}
+	}
+	return o.validateContrast()
+}
+
+// pageBox 页面上可见的区域，单位为点。水印的位置在显示坐标中计算：
+// 原点为显示时的左下角，Width、Height 为旋转后看到的宽高，再由 transform 换算到页面的用户坐标
+type pageBox struct {
+	Left, Bottom  float64 // 可见区域（CropBox 与 MediaBox 的交集）左下角的用户坐标
+	Width, Height float64
+	Rotation      int // 页面的 /Rotate，显示时顺时针旋转的角度：0、90、180、270
+}
+
+// transform 返回把显示坐标变换到用户坐标的矩阵
+func (p pageBox) transform() structs.FPDF_FS_MATRIX {
+	left, bottom := float32(p.Left), float32(p.Bottom)
+	width, height := float32(p.Width), float32(p.Height)
+	switch p.Rotation {
+	case 90:
+		// 用户坐标的左下角显示在左上角
+		return structs.FPDF_FS_MATRIX{B: 1, C: -1, E: left + height, F: bottom}
+	case 180:
+		return structs.FPDF_FS_MATRIX{A: -1, D: -1, E: left + width, F: bottom + height}
+	case 270:
+		return structs.FPDF_FS_MATRIX{B: -1, C: 1, E: left, F: bottom + width}
+	}
+	return structs.FPDF_FS_MATRIX{A: 1, D: 1, E: left, F: bottom}
+}
+
+// getPageBox 加载页面读取可见区域
+func getPageBox(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, index int) (pageBox, error) {
+	pdfPage, err := instance.FPDF_LoadPage(&requests.FPDF_LoadPage{
+		Document: document,
+		Index:    index,
 	})
 	if err != nil {
-		return res, err
+		return pageBox{}, err
 	}
+	defer instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
+		Page: pdfPage.Page,
+	})
+	return readPageBox(instance, requests.Page{ByReference: &pdfPage.Page})
+}
 
-	// 将图片加载到ImageObject中，ImageObject是Page中的图片对象
-	_, err = instance.FPDFImageObj_SetBitmap(&requests.FPDFImageObj_SetBitmap{
-		ImageObject: watermarkImageObj.PageObject,
-		Bitmap:      watermarkBitmap.Bitmap,
+// readPageBox 读取页面的旋转角度和可见区域
+func readPageBox(instance pdfium.Pdfium, page requests.Page) (pageBox, error) {
+	rotationRes, err := instance.FPDFPage_GetRotation(&requests.FPDFPage_GetRotation{
+		Page: page,
 	})
 	if err != nil {
-		return res, err
+		return pageBox{}, fmt.Errorf("无法获取页面旋转角度: %v", err)
+	}
+	// 宽高由 pdfium 计算，已考虑继承的页面框、CropBox 和旋转
+	widthRes, err := instance.FPDF_GetPageWidth(&requests.FPDF_GetPageWidth{
+		Page: page,
+	})
+	if err != nil {
+		return pageBox{}, fmt.Errorf("无法获取页面尺寸: %v", err)
 	}
-	res.imageObjRef = watermarkImageObj.PageObject
+	heightRes, err := instance.FPDF_GetPageHeight(&requests.FPDF_GetPageHeight{
+		Page: page,
+	})
+	if err != nil {
+		return pageBox{}, fmt.Errorf("无法获取页面尺寸: %v", err)
+	}
+	box := pageBox{Width: widthRes.Width, Height: heightRes.Height, Rotation: int(rotationRes.PageRotation) * 90}
 
-	return res, nil
+	// 可见区域取 CropBox 与 MediaBox 的交集：左下角取两者左下角的较大值，右上角取较小值。
+	// 这两个接口只读页面自身的字典，页面框从 /Pages 继承时读不到，此时按原点在 (0,0) 处理，
+	// 宽高也不超过 pdfium 计算的结果
+	var rects [][4]float32
+	if res, err := instance.FPDFPage_GetMediaBox(&requests.FPDFPage_GetMediaBox{Page: page}); err == nil {
+		rects = append(rects, [4]float32{min32(res.Left, res.Right), min32(res.Bottom, res.Top), max32(res.Left, res.Right), max32(res.Bottom, res.Top)})
+	}
+	if res, err := instance.FPDFPage_GetCropBox(&requests.FPDFPage_GetCropBox{Page: page}); err == nil {
+		rects = append(rects, [4]float32{min32(res.Left, res.Right), min32(res.Bottom, res.Top), max32(res.Left, res.Right), max32(res.Bottom, res.Top)})
+	}
+	if len(rects) == 0 {
+		return box, nil
+	}
+	clip := rects[0]
+	for _, r := range rects[1:] {
+		clip[0], clip[1] = max32(clip[0], r[0]), max32(clip[1], r[1])
+		clip[2], clip[3] = min32(clip[2], r[2]), min32(clip[3], r[3])
+	}
+	width, height := float64(clip[2]-clip[0]), float64(clip[3]-clip[1])
+	if width <= 0 || height <= 0 {
+		return pageBox{}, fmt.Errorf("页面的 CropBox 与 MediaBox 不相交")
+	}
+	if box.Rotation%180 != 0 {
+		width, height = height, width
+	}
+	box.Left, box.Bottom = float64(clip[0]), float64(clip[1])
+	box.Width, box.Height = math.Min(box.Width, width), math.Min(box.Height, height)
+	return box, nil
+}
+
+func min32(a, b float32) float32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max32(a, b float32) float32 {
+	if a > b {
+		return a
+	}
+	return b
 }
 
-func PDFAddLogoV1(ctx context.Context, instance pdfium.Pdfium, watermarkPath, inputPath, outputPath string, imageScale int) error {
+// watermarkBox 水印在页面上的位置：未旋转时的宽高，以及显示坐标中的中心点
+type watermarkBox struct {
+	Width, Height    float64
+	CenterX, CenterY float64
+	Rotation         float64 // 弧度
+	page             pageBox
+}
 
-	// 打开一个新的PDF文档
-	pdfDoc, err := instance.FPDF_LoadDocument(&requests.FPDF_LoadDocument{
-		Path:     &inputPath,
-		Password: nil,
+// place 按锚点、边距和旋转角度计算大小为 width x height 的水印的位置。
+// 旋转后的外接矩形贴着边距放置，旋转的水印也不会超出页面
+func (o WatermarkOptions) place(page pageBox, width, height float64) watermarkBox {
+	b := watermarkBox{Width: width, Height: height, Rotation: o.Rotation * math.Pi / 180, page: page}
+
+	sin, cos := math.Abs(math.Sin(b.Rotation)), math.Abs(math.Cos(b.Rotation))
+	boundW := b.Width*cos + b.Height*sin
+	boundH := b.Width*sin + b.Height*cos
+
+	f := anchorFactors[o.Anchor]
+	marginX, marginY := o.MarginX.Points(page.Width), o.MarginY.Points(page.Height)
+	if f[0] == 0.5 {
+		marginX = 0
+	}
+	if f[1] == 0.5 {
+		marginY = 0
+	}
+
+	// f=0 时外接矩形左边缘在 marginX 处，f=1 时右边缘在 Width-marginX 处，f=0.5 时居中
+	b.CenterX = marginX + boundW/2 + f[0]*(page.Width-2*marginX-boundW)
+	b.CenterY = marginY + boundH/2 + f[1]*(page.Height-2*marginY-boundH)
+	return b
+}
+
+// maxTiles 每页最多平铺的水印数量，避免水印太小或间距太小时生成过多对象
+const maxTiles = 2000
+
+// layout 返回水印在页面上的位置，平铺时返回覆盖整页的多个位置，width、height 为单个水印的大小
+func (o WatermarkOptions) layout(page pageBox, width, height float64) ([]watermarkBox, error) {
+	if !o.Tile {
+		return []watermarkBox{o.place(page, width, height)}, nil
+	}
+
+	rotation := o.Rotation * math.Pi / 180
+	sin, cos := math.Sin(rotation), math.Cos(rotation)
+	periodX := width + o.SpacingX.Points(page.Width)
+	periodY := height + o.SpacingY.Points(page.Height)
+
+	// 网格以页面中心为原点，沿旋转后的坐标轴排列，半径 r 的圆内的格子足以覆盖整页
+	centerX, centerY := page.Width/2, page.Height/2
+	r := math.Hypot(page.Width, page.Height)/2 + math.Hypot(width, height)/2
+	cols, rows := int(math.Ceil(r/periodX))+1, int(math.Ceil(r/periodY))
+	if (2*cols+1)*(2*rows+1) > 4*maxTiles {
+		return nil, fmt.Errorf("平铺的水印太多，请增大 size 或间距")
+	}
+
+	// 旋转后外接矩形的半宽、半高，用于判断是否与页面相交
+	halfW := (width*math.Abs(cos) + height*math.Abs(sin)) / 2
+	halfH := (width*math.Abs(sin) + height*math.Abs(cos)) / 2
+
+	var boxes []watermarkBox
+	for j := -rows; j <= rows; j++ {
+		offset := 0.0
+		if o.Stagger && j%2 != 0 {
+			offset = periodX / 2
+		}
+		for i := -cols; i <= cols; i++ {
+			u, v := float64(i)*periodX+offset, float64(j)*periodY
+			x, y := centerX+u*cos-v*sin, centerY+u*sin+v*cos
+			if math.Abs(x-centerX) >= page.Width/2+halfW || math.Abs(y-centerY) >= page.Height/2+halfH {
+				continue
+			}
+			boxes = append(boxes, watermarkBox{Width: width, Height: height, CenterX: x, CenterY: y, Rotation: rotation, page: page})
+		}
+	}
+	if len(boxes) > maxTiles {
+		return nil, fmt.Errorf("平铺的水印太多（%d 个），请增大 size 或间距", len(boxes))
+	}
+	return boxes, nil
+}
+
+// matrix 返回把 [0,contentW]x[0,contentH] 内的内容变换到水印位置的矩阵，
+// 图片对象的内容为单位正方形，即 contentW=contentH=1
+func (b watermarkBox) matrix(contentW, contentH float64) structs.FPDF_FS_MATRIX {
+	sx, sy := b.Width/contentW, b.Height/contentH
+	sin, cos := math.Sin(b.Rotation), math.Cos(b.Rotation)
+
+	// 先缩放，再把中心移到原点，旋转后移到水印中心，最后从显示坐标换算到用户坐标
+	return multiply(b.page.transform(), structs.FPDF_FS_MATRIX{
+		A: float32(cos * sx),
+		B: float32(sin * sx),
+		C: float32(-sin * sy),
+		D: float32(cos * sy),
+		E: float32(b.CenterX - cos*b.Width/2 + sin*b.Height/2),
+		F: float32(b.CenterY - sin*b.Width/2 - cos*b.Height/2),
 	})
+}
+
+// watermarkLayer 生成每一页的水印对象
+type watermarkLayer interface {
+	// objects 为第 index 页生成水印对象，返回的对象尚未插入页面
+	objects(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, page *watermarkPage, index int) ([]references.FPDF_PAGEOBJECT, error)
+	// close 释放共用的资源
+	close(instance pdfium.Pdfium)
+}
+
+// logoImage 一份水印图片，第一次使用时在文档中创建一个表单 XObject，
+// 每个位置创建一个引用它的表单对象，平铺多少处文件中都只有一份图片
+type logoImage struct {
+	bitmap BitmapCreateResponse
+
+	// jpeg 不为空时直接嵌入 JPEG 原始数据，不使用 bitmap
+	jpeg *logoFile
+
+	xobject references.FPDF_XOBJECT
+}
+
+// size 返回摆正后的像素宽高
+func (l *logoImage) size() (int, int) {
+	if l.jpeg != nil {
+		return l.jpeg.width, l.jpeg.height
+	}
+	return l.bitmap.width, l.bitmap.height
+}
+
+// newXObject 把图片铺满一个 1x1 的临时页面，再把这一页作为表单 XObject 导入 document
+func (l *logoImage) newXObject(instance pdfium.Pdfium, document references.FPDF_DOCUMENT) error {
+	scratch, err := instance.FPDF_CreateNewDocument(&requests.FPDF_CreateNewDocument{})
 	if err != nil {
 		return err
 	}
-
 	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
-		Document: pdfDoc.Document,
+		Document: scratch.Document,
 	})
 
-	pageCount, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
-		Document: pdfDoc.Document,
+	pageRes, err := instance.FPDFPage_New(&requests.FPDFPage_New{
+		Document: scratch.Document,
+		Width:    1,
+		Height:   1,
 	})
 	if err != nil {
 		return err
 	}
-	fmt.Printf("pageCount: %d\n", pageCount.PageCount)
 
-	watermarkBitmapRes, err := CreateBitmapFromFile(instance, watermarkPath, 1)
+	var obj references.FPDF_PAGEOBJECT
+	matrix := structs.FPDF_FS_MATRIX{A: 1, D: 1}
+	if l.jpeg != nil {
+		// JPEG 按原始方向存放，由矩阵按 EXIF 方向摆正
+		obj, err = newJPEGObject(instance, scratch.Document, l.jpeg.data)
+		matrix = orientationMatrix(l.jpeg.orientation)
+	} else {
+		obj, err = newImageObject(instance, scratch.Document, l.bitmap.bitmapRef)
+	}
+	if err == nil {
+		if _, err = instance.FPDFImageObj_SetMatrix(&requests.FPDFImageObj_SetMatrix{
+			ImageObject: obj,
+			Transform:   matrix,
+		}); err != nil {
+			destroyObjects(instance, []references.FPDF_PAGEOBJECT{obj})
+		} else {
+			err = insertObjects(instance, requests.Page{ByReference: &pageRes.Page}, []references.FPDF_PAGEOBJECT{obj})
+		}
+	}
+	instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
+		Page: pageRes.Page,
+	})
 	if err != nil {
 		return err
 	}
 
-	for pageIndex := 0; pageIndex < pageCount.PageCount; pageIndex++ {
-		// 获取页面
-		pdfPage, err := instance.FPDF_LoadPage(&requests.FPDF_LoadPage{
-			Document: pdfDoc.Document,
-			Index:    pageIndex,
-		})
-		if err != nil {
-			return err
-		}
+	// go-pdfium 把 Source 和 Destination 按相反的顺序传给 pdfium，这里反过来填
+	xobjectRes, err := instance.FPDF_NewXObjectFromPage(&requests.FPDF_NewXObjectFromPage{
+		Source:      document,
+		Destination: scratch.Document,
+	})
+	if err != nil {
+		return fmt.Errorf("无法创建水印模板: %v", err)
+	}
+	l.xobject = xobjectRes.XObject
+	return nil
+}
 
-		pageByIndex := requests.PageByIndex{
-			Document: pdfDoc.Document,
-			Index:    pageIndex,
-		}
-		filePdfPage := requests.Page{
-			ByIndex:     &pageByIndex,
-			ByReference: &pdfPage.Page,
+// newObject 创建放在 box 处的表单对象
+func (l *logoImage) newObject(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, box watermarkBox) (references.FPDF_PAGEOBJECT, error) {
+	if l.xobject == "" {
+		if err := l.newXObject(instance, document); err != nil {
+			return "", err
 		}
+	}
 
-		// 获取页宽
-		filePageSize, err := instance.FPDF_GetPageSizeByIndex(&requests.FPDF_GetPageSizeByIndex{
-			Document: pdfDoc.Document,
-			Index:    pageIndex,
+	formRes, err := instance.FPDF_NewFormObjectFromXObject(&requests.FPDF_NewFormObjectFromXObject{
+		XObject: l.xobject,
+	})
+	if err != nil {
+		return "", err
+	}
+	if _, err = instance.FPDFPageObj_SetMatrix(&requests.FPDFPageObj_SetMatrix{
+		PageObject: formRes.PageObject,
+		Transform:  box.matrix(1, 1),
+	}); err != nil {
+		destroyObjects(instance, []references.FPDF_PAGEOBJECT{formRes.PageObject})
+		return "", err
+	}
+	return formRes.PageObject, nil
+}
+
+func (l *logoImage) close(instance pdfium.Pdfium) {
+	if l == nil {
+		return
+	}
+	if l.xobject != "" {
+		instance.FPDF_CloseXObject(&requests.FPDF_CloseXObject{
+			XObject: l.xobject,
 		})
-		if err != nil {
-			return err
-		}
+	}
+	if l.bitmap.bitmapRef != "" {
+		instance.FPDFBitmap_Destroy(&requests.FPDFBitmap_Destroy{
+			Bitmap: l.bitmap.bitmapRef,
+		})
+	}
+}
 
-		scale := math.Min(filePageSize.Height, filePageSize.Width) / 595
+// imageLayer 图片水印
+type imageLayer struct {
+	logo *logoImage
+	opts WatermarkOptions
 
-		watermarkImageObj, err := instance.FPDFPageObj_NewImageObj(&requests.FPDFPageObj_NewImageObj{
-			Document: pdfDoc.Document,
-		})
-		if err != nil {
-			return err
+	// 设置了 Contrast 时对比度不足的位置换用 alt：variant 时为明暗相反的变体，
+	// outline 时为加了描边的图片，四周各比原图宽 outline 倍的水印宽度
+	alt                     *logoImage
+	luminance, altLuminance float64
+	outline                 float64
+}
+
+// newImageLayer 读取水印图片，不透明度直接乘到 alpha 通道上。
+// 不透明的 JPEG 原样嵌入，文件只增加 JPEG 本身的大小；
+// 半透明时需要改 alpha，和其他格式一样解码为位图
+func newImageLayer(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, logoPath string, opts WatermarkOptions) (*imageLayer, error) {
+	logo, err := readLogo(logoPath)
+	if err != nil {
+		return nil, err
+	}
+	passthrough := logo.format == "jpeg" && opts.Opacity == 1
+	l := &imageLayer{opts: opts}
+
+	// 调整对比度时需要像素计算亮度，JPEG 仍然可以原样嵌入
+	var img image.Image
+	if !passthrough || opts.Contrast != ContrastNone {
+		if img, err = logo.decode(); err != nil {
+			return nil, err
+		}
+	}
+	if passthrough {
+		l.logo = &logoImage{jpeg: logo}
+	} else if l.logo, err = newLogoImage(instance, img, opts); err != nil {
+		return nil, err
+	}
+	if opts.Contrast != ContrastNone {
+		if err = l.prepareContrast(instance, document, img); err != nil {
+			l.close(instance)
+			return nil, err
 		}
+	}
+	return l, nil
+}
 
-		// 将图片加载到ImageObject中，ImageObject是Page中的图片对象
-		_, err = instance.FPDFImageObj_SetBitmap(&requests.FPDFImageObj_SetBitmap{
-			ImageObject: watermarkImageObj.PageObject,
-			Bitmap:      watermarkBitmapRes.bitmapRef,
-		})
-		if err != nil {
-			return err
+// newLogoImage 按不透明度处理图片后创建位图
+func newLogoImage(instance pdfium.Pdfium, img image.Image, opts WatermarkOptions) (*logoImage, error) {
+	nrgba := toNRGBA(img)
+	if opts.Opacity < 1 {
+		faded := image.NewNRGBA(nrgba.Rect)
+		copy(faded.Pix, nrgba.Pix)
+		for i := 3; i < len(faded.Pix); i += 4 {
+			faded.Pix[i] = uint8(math.Round(float64(faded.Pix[i]) * opts.Opacity))
 		}
+		nrgba = faded
+	}
 
-		// 调整图片对象的尺寸和位置
-		_, err = instance.FPDFImageObj_SetMatrix(&requests.FPDFImageObj_SetMatrix{
-			ImageObject: watermarkImageObj.PageObject,
-			Transform: structs.FPDF_FS_MATRIX{
-				A: float32(scale) * float32(watermarkBitmapRes.width) / float32(6),
-				B: 0,
-				C: 0,
-				D: float32(scale) * float32(watermarkBitmapRes.height) / float32(6),
-				E: float32(filePageSize.Width) - float32(scale)*float32(watermarkBitmapRes.width)/float32(6) - float32(21)/float32(354)*float32(watermarkBitmapRes.width)/float32(imageScale),
-				F: float32(7) / float32(500) * float32(filePageSize.Height),
-			},
-		})
-		if err != nil {
-			return err
+	bitmap, err := CreateBitmapFromImage(instance, nrgba, 1)
+	if err != nil {
+		if bitmap.bitmapRef != "" {
+			instance.FPDFBitmap_Destroy(&requests.FPDFBitmap_Destroy{
+				Bitmap: bitmap.bitmapRef,
+			})
 		}
+		return nil, err
+	}
+	return &logoImage{bitmap: bitmap}, nil
+}
 
-		_, err = instance.FPDFPage_InsertObject(&requests.FPDFPage_InsertObject{
-			Page:       filePdfPage,
-			PageObject: watermarkImageObj.PageObject,
-		})
+// prepareContrast 计算水印的亮度，并准备对比度不足时换用的图片
+func (l *imageLayer) prepareContrast(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, img image.Image) error {
+	l.luminance = util.ImageLuminance(img)
+	imgW, imgH := img.Bounds().Dx(), img.Bounds().Dy()
+
+	var alt image.Image
+	switch l.opts.Contrast {
+	case ContrastVariant:
+		alt = util.InvertLightness(img)
+		if l.opts.VariantLogo != "" {
+			variant, err := decodeLogo(l.opts.VariantLogo)
+			if err != nil {
+				return err
+			}
+			// 变体按原图的位置和大小放置，宽高比不同会被拉伸
+			w, h := variant.Bounds().Dx(), variant.Bounds().Dy()
+			if math.Abs(float64(w*imgH-h*imgW)) > 0.01*float64(w*imgH) {
+				return fmt.Errorf("变体图片的宽高比与水印图片不同: %dx%d, %dx%d", w, h, imgW, imgH)
+			}
+			alt = variant
+		}
+		l.altLuminance = util.ImageLuminance(alt)
+	case ContrastOutline:
+		// 描边宽度按第一页上水印的显示宽度换算为像素
+		page, err := getPageBox(instance, document, 0)
 		if err != nil {
 			return err
 		}
+		width := l.opts.Size * math.Min(page.Width, page.Height)
+		radius := int(math.Ceil(l.opts.OutlineWidth * float64(imgW) / width))
+		alt = util.Outline(img, l.opts.contrastColor(l.luminance).nrgba(), radius)
+		l.outline = float64(radius) / float64(imgW)
+	default:
+		return nil
+	}
 
-		_, err = instance.FPDFPage_GenerateContent(&requests.FPDFPage_GenerateContent{
-			Page: filePdfPage,
-		})
+	var err error
+	l.alt, err = newLogoImage(instance, alt, l.opts)
+	return err
+}
+
+func (l *imageLayer) objects(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, page *watermarkPage, index int) ([]references.FPDF_PAGEOBJECT, error) {
+	imgW, imgH := l.logo.size()
+	width := l.opts.Size * math.Min(page.Width, page.Height)
+	boxes, err := page.layout(instance, l.opts, width, width*float64(imgH)/float64(imgW))
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []references.FPDF_PAGEOBJECT
+	for _, box := range boxes {
+		choice, err := page.contrast(instance, l.opts, box, l.luminance, l.altLuminance)
 		if err != nil {
-			return err
+			destroyObjects(instance, objects)
+			return nil, err
 		}
 
-		_, err = instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
-			Page: pdfPage.Page,
-		})
+		logo := l.logo
+		switch {
+		case choice.variant:
+			logo = l.alt
+		case choice.outline:
+			logo, box = l.alt, box.grow(l.outline*box.Width)
+		case choice.plate:
+			plate, err := newPlate(instance, box, l.opts.PlatePadding.Points(box.Width), l.opts.contrastColor(l.luminance), l.opts.Opacity)
+			if err != nil {
+				destroyObjects(instance, objects)
+				return nil, err
+			}
+			objects = append(objects, plate)
+		}
+
+		obj, err := logo.newObject(instance, document, box)
 		if err != nil {
-			return err
+			destroyObjects(instance, objects)
+			return nil, err
 		}
+		objects = append(objects, obj)
 	}
+	return objects, nil
+}
 
-	// 保存为pdf
-	_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
-		Document: pdfDoc.Document,
-		FilePath: &outputPath,
-	})
-	if err != nil {
-		return err
-	}
+func (l *imageLayer) close(instance pdfium.Pdfium) {
+	l.logo.close(instance)
+	l.alt.close(instance)
+}
 
-	return nil
+// WatermarkResult 添加水印的结果
+type WatermarkResult struct {
+	Placements []Placement `json:"placements,omitempty"` // 每一页水印的位置，平铺时为空
+	Replaced   int         `json:"replaced,omitempty"`   // 替换掉的同一标识的旧水印对象数量
+}
+
+// Watermark 在每一页添加图片水印，位置、大小和外观见 WatermarkOptions
+func Watermark(ctx context.Context, instance pdfium.Pdfium, logoPath string, in PDFInput, out PDFOutput, opts WatermarkOptions) (*WatermarkResult, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.ID == "" {
+		opts.ID = WatermarkIDLogo
+	}
+	return applyWatermark(ctx, instance, in, out, opts.ZOrder, opts.ID, func(document references.FPDF_DOCUMENT) (watermarkLayer, error) {
+		return newImageLayer(instance, document, logoPath, opts)
+	})
 }
 
-func PDFAddLogoV2(ctx context.Context, instance pdfium.Pdfium, watermarkPath, inputPath, outputPath string, imageScale int) error {
+// applyWatermark 加载文档，逐页删除标识为 id 的旧水印、插入 layer 生成的水印对象后保存，
+// 返回各水印层记录的位置和删除的旧水印对象数量
+func applyWatermark(ctx context.Context, instance pdfium.Pdfium, in PDFInput, out PDFOutput, zOrder ZOrder, id string, newLayer func(document references.FPDF_DOCUMENT) (watermarkLayer, error)) (*WatermarkResult, error) {
 
 	// 打开一个新的PDF文档
-	pdfDoc, err := instance.FPDF_LoadDocument(&requests.FPDF_LoadDocument{
-		Path:     &inputPath,
-		Password: nil,
-	})
+	document, err := LoadDocument(instance, in)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("无法加载 PDF 文档=%s: %w", in.name(), err)
 	}
 
 	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
-		Document: pdfDoc.Document,
+		Document: document,
 	})
 
 	pageCount, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
-		Document: pdfDoc.Document,
+		Document: document,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fmt.Printf("pageCount: %d\n", pageCount.PageCount)
 
-	watermarkImageObjRes, err := CreateImageObject(instance, pdfDoc.Document, watermarkPath, 1)
+	progress := ProgressEvent{Phase: PhaseWatermark, Pages: pageCount.PageCount}
+
+	layer, err := newLayer(document)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer layer.close(instance)
+
+	result := &WatermarkResult{}
+	var pdfPage *responses.FPDF_LoadPage
+	defer func() {
+		// 提前返回（出错或被取消）时释放仍打开的页面
+		if pdfPage != nil {
+			instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
+				Page: pdfPage.Page,
+			})
+		}
+	}()
 
 	for pageIndex := 0; pageIndex < pageCount.PageCount; pageIndex++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		progress.Page = pageIndex + 1
+		reportProgress(ctx, progress)
+
 		// 获取页面
-		pdfPage, err := instance.FPDF_LoadPage(&requests.FPDF_LoadPage{
-			Document: pdfDoc.Document,
+		pdfPage, err = instance.FPDF_LoadPage(&requests.FPDF_LoadPage{
+			Document: document,
 			Index:    pageIndex,
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
-
-		pageByIndex := requests.PageByIndex{
-			Document: pdfDoc.Document,
-			Index:    pageIndex,
-		}
-		filePdfPage := requests.Page{
-			ByIndex:     &pageByIndex,
+		page := requests.Page{
 			ByReference: &pdfPage.Page,
 		}
 
-		// 获取页宽
-		filePageSize, err := instance.FPDF_GetPageSizeByIndex(&requests.FPDF_GetPageSizeByIndex{
-			Document: pdfDoc.Document,
-			Index:    pageIndex,
-		})
+		box, err := readPageBox(instance, page)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("第 %d 页: %v", pageIndex+1, err)
 		}
 
-		scale := math.Min(filePageSize.Height, filePageSize.Width) / 595
-
-		// 调整图片对象的尺寸和位置
-		_, err = instance.FPDFImageObj_SetMatrix(&requests.FPDFImageObj_SetMatrix{
-			ImageObject: watermarkImageObjRes.imageObjRef,
-			Transform: structs.FPDF_FS_MATRIX{
-				A: float32(scale) * float32(watermarkImageObjRes.width) / float32(6),
-				B: 0,
-				C: 0,
-				D: float32(scale) * float32(watermarkImageObjRes.height) / float32(6),
-				E: float32(filePageSize.Width) - float32(scale)*float32(watermarkImageObjRes.width)/float32(6) - float32(21)/float32(354)*float32(watermarkImageObjRes.width)/float32(imageScale),
-				F: float32(7) / float32(500) * float32(filePageSize.Height),
-			},
-		})
+		// 先删除同一标识的旧水印，放到内容下方时只移动剩下的原有对象
+		removed, err := removeWatermarks(instance, page, func(s string) bool { return s == id })
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("第 %d 页: %v", pageIndex+1, err)
+		}
+		result.Replaced += removed
+		if zOrder == ZOrderBack {
+			if err = checkBehind(instance, document, page, pageIndex, removed > 0); err != nil {
+				return nil, err
+			}
 		}
 
-		_, err = instance.FPDFPage_InsertObject(&requests.FPDFPage_InsertObject{
-			Page:       filePdfPage,
-			PageObject: watermarkImageObjRes.imageObjRef,
-		})
+		watermarkPage := &watermarkPage{pageBox: box, handle: page}
+		objects, err := layer.objects(instance, document, watermarkPage, pageIndex)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("第 %d 页: %v", pageIndex+1, err)
+		}
+		for _, p := range watermarkPage.placements {
+			p.Page = pageIndex + 1
+			result.Placements = append(result.Placements, p)
+		}
+		if err = markObjects(instance, document, objects, id); err != nil {
+			destroyObjects(instance, objects)
+			return nil, fmt.Errorf("第 %d 页: %v", pageIndex+1, err)
 		}
 
-		_, err = instance.FPDFPage_GenerateContent(&requests.FPDFPage_GenerateContent{
-			Page: filePdfPage,
-		})
-		if err != nil {
-			return err
+		if zOrder == ZOrderBack {
+			if err = insertBehind(instance, page, objects); err != nil {
+				return nil, fmt.Errorf("第 %d 页: %v", pageIndex+1, err)
+			}
+		} else if err = insertObjects(instance, page, objects); err != nil {
+			return nil, fmt.Errorf("第 %d 页: %v", pageIndex+1, err)
 		}
 
 		_, err = instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
 			Page: pdfPage.Page,
 		})
+		pdfPage = nil
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	progress.Phase = PhaseSave
+	reportProgress(ctx, progress)
+
 	// 保存为pdf
-	_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
-		Document: pdfDoc.Document,
-		FilePath: &outputPath,
+	if err = SaveDocument(instance, document, out, 0); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// destroyObjects 销毁尚未插入页面的对象
+func destroyObjects(instance pdfium.Pdfium, objects []references.FPDF_PAGEOBJECT) {
+	for _, obj := range objects {
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: obj,
+		})
+	}
+}
+
+// insertObjects 把对象插入到页面原有内容之上
+func insertObjects(instance pdfium.Pdfium, page requests.Page, objects []references.FPDF_PAGEOBJECT) error {
+	for i, obj := range objects {
+		if _, err := instance.FPDFPage_InsertObject(&requests.FPDFPage_InsertObject{
+			Page:       page,
+			PageObject: obj,
+		}); err != nil {
+			// 未插入的对象不归页面管理，需要手动销毁
+			destroyObjects(instance, objects[i:])
+			return err
+		}
+	}
+
+	_, err := instance.FPDFPage_GenerateContent(&requests.FPDFPage_GenerateContent{
+		Page: page,
+	})
+	return err
+}
+
+// maxBehindObjects 放到内容下方时页面最多允许的原有对象数量，这些对象都要移出再放回、重新生成内容
+const maxBehindObjects = 5000
+
+// checkBehind 在移动原有对象之前检查能否把水印放到第 index 页的内容下方：
+// 页面只能有一个内容流，对象数量不超过 maxBehindObjects。
+// changed 表示已经删除了旧水印，先生成内容，旧水印单独所在的内容流随之删除
+func checkBehind(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, page requests.Page, index int, changed bool) error {
+	if changed {
+		if _, err := instance.FPDFPage_GenerateContent(&requests.FPDFPage_GenerateContent{
+			Page: page,
+		}); err != nil {
+			return fmt.Errorf("第 %d 页: %v", index+1, err)
+		}
+	}
+
+	countRes, err := instance.FPDFPage_CountObjects(&requests.FPDFPage_CountObjects{
+		Page: page,
+	})
+	if err != nil {
+		return fmt.Errorf("第 %d 页: %v", index+1, err)
+	}
+	if countRes.Count > maxBehindObjects {
+		return fmt.Errorf("第 %d 页有 %d 个对象，超过 %d 个时无法把水印放到内容下方，请改用 front", index+1, countRes.Count, maxBehindObjects)
+	}
+
+	streams, err := countContentStreams(instance, document, index)
+	if err != nil {
+		return fmt.Errorf("第 %d 页: %v", index+1, err)
+	}
+	if streams > 1 {
+		return fmt.Errorf("第 %d 页由 %d 个内容流组成，无法把水印放到内容下方，请改用 front", index+1, streams)
+	}
+	return nil
+}
+
+var (
+	pageKidsPattern  = regexp.MustCompile(`/Kids\s*\[\s*(\d+) 0 R`)
+	contentsPattern  = regexp.MustCompile(`/Contents\s*(\[[^\]]*\]|(\d+) 0 R)`)
+	referencePattern = regexp.MustCompile(`\d+ \d+ R`)
+)
+
+// countContentStreams 返回第 index 页的内容流数量。
+// pdfium 没有读取页面字典的接口，这里把该页复制到临时文档保存，
+// 从 pdfium 写出的未压缩对象中找到页面的 /Contents
+func countContentStreams(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, index int) (int, error) {
+	scratch, err := instance.FPDF_CreateNewDocument(&requests.FPDF_CreateNewDocument{})
+	if err != nil {
+		return 0, err
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
+		Document: scratch.Document,
+	})
+
+	if _, err = instance.FPDF_ImportPagesByIndex(&requests.FPDF_ImportPagesByIndex{
+		Source:      document,
+		Destination: scratch.Document,
+		PageIndices: []int{index},
+	}); err != nil {
+		return 0, fmt.Errorf("无法复制页面: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+		Document:   scratch.Document,
+		FileWriter: &buf,
+	}); err != nil {
+		return 0, fmt.Errorf("无法保存页面: %v", err)
+	}
+
+	// 临时文档只有这一页
+	kids := pageKidsPattern.FindSubmatch(buf.Bytes())
+	if kids == nil {
+		return 0, errors.New("无法解析页面字典")
+	}
+	contents := contentsPattern.FindSubmatch(indirectObject(buf.Bytes(), kids[1]))
+	if contents == nil {
+		return 0, nil
+	}
+	value := contents[1]
+	if contents[2] != nil {
+		// 间接引用的可能是内容流，也可能是内容流数组
+		value = indirectObject(buf.Bytes(), contents[2])
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(value), []byte("[")) {
+		return 1, nil
+	}
+	return len(referencePattern.FindAll(value, -1)), nil
+}
+
+// indirectObject 返回 data 中编号为 num 的对象内容，找不到时为空
+func indirectObject(data, num []byte) []byte {
+	header := append(append([]byte("\n"), num...), " 0 obj"...)
+	start := bytes.Index(data, header)
+	if start < 0 {
+		return nil
+	}
+	data = data[start+len(header):]
+	if end := bytes.Index(data, []byte("endobj")); end >= 0 {
+		data = data[:end]
+	}
+	return data
+}
+
+// insertBehind 把对象放到页面原有内容之下。
+//
+// pdfium 生成内容时，新对象总是写入追加在末尾的新内容流，只调整对象顺序无法放到下方。
+// 这里先移出原有对象、只生成水印，原有内容流被清空删除后水印所在的流成为第一个，
+// 再放回原有对象重新生成。原有对象分布在多个内容流时放回会出错，调用前由 checkBehind 检查
+func insertBehind(instance pdfium.Pdfium, page requests.Page, objects []references.FPDF_PAGEOBJECT) error {
+	countRes, err := instance.FPDFPage_CountObjects(&requests.FPDFPage_CountObjects{
+		Page: page,
 	})
 	if err != nil {
 		return err
 	}
 
+	original := make([]references.FPDF_PAGEOBJECT, 0, countRes.Count)
+	for i := 0; i < countRes.Count; i++ {
+		objRes, err := instance.FPDFPage_GetObject(&requests.FPDFPage_GetObject{
+			Page:  page,
+			Index: i,
+		})
+		if err != nil {
+			return err
+		}
+		original = append(original, objRes.PageObject)
+	}
+	for i, obj := range original {
+		if _, err := instance.FPDFPage_RemoveObject(&requests.FPDFPage_RemoveObject{
+			Page:       page,
+			PageObject: obj,
+		}); err != nil {
+			// 放回已移出的对象，避免泄漏
+			insertObjects(instance, page, original[:i])
+			return err
+		}
+	}
+
+	if err = insertObjects(instance, page, objects); err != nil {
+		insertObjects(instance, page, original)
+		return err
+	}
+	if err = insertObjects(instance, page, original); err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/stretchr/testify/assert"
+)
+
+// renderFirstPage 以 72 DPI 渲染第 1 页，像素坐标与 PDF 坐标一致（y 轴相反）
+func renderFirstPage(t *testing.T, instance pdfium.Pdfium, data []byte) image.Image {
+	doc, err := LoadDocument(instance, PDFInput{Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{Document: doc})
+	img, err := RenderPage(instance, doc, 0, 72)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestParseLength(t *testing.T) {
+	l, err := ParseLength("20")
+	assert.Nil(t, err)
+	assert.Equal(t, Length{Value: 20}, l)
+	assert.Equal(t, 20.0, l.Points(500))
+
+	l, err = ParseLength("12.5pt")
+	assert.Nil(t, err)
+	assert.Equal(t, Length{Value: 12.5}, l)
+
+	l, err = ParseLength("5%")
+	assert.Nil(t, err)
+	assert.Equal(t, Length{Value: 5, Percent: true}, l)
+	assert.Equal(t, 25.0, l.Points(500))
+	assert.Equal(t, "5%", l.String())
+
+	_, err = ParseLength("abc")
+	assert.NotNil(t, err)
+
+	// JSON 中可以是数字或字符串
+	var opts WatermarkOptions
+	assert.Nil(t, json.Unmarshal([]byte(`{"margin_x": 36, "margin_y": "2%"}`), &opts))
+	assert.Equal(t, Length{Value: 36}, opts.MarginX)
+	assert.Equal(t, Length{Value: 2, Percent: true}, opts.MarginY)
+	data, err := json.Marshal(opts)
+	assert.Nil(t, err)
+	var decoded WatermarkOptions
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, opts, decoded)
+}
+
+func TestWatermarkPlace(t *testing.T) {
+	page := pageBox{Width: 600, Height: 800}
+
+	a, err := ParseAnchor("Top-Left")
+	assert.Nil(t, err)
+	assert.Equal(t, AnchorTopLeft, a)
+	_, err = ParseAnchor("middle")
+	assert.NotNil(t, err)
+
+	opts := WatermarkOptions{Anchor: AnchorTopLeft, MarginX: Length{Value: 10}, MarginY: Length{Value: 5, Percent: true}}
+	box := opts.place(page, 300, 150)
+	assert.InDelta(t, 300, box.Width, 1e-9)
+	assert.InDelta(t, 150, box.Height, 1e-9)
+	assert.InDelta(t, 10+150, box.CenterX, 1e-9)
+	assert.InDelta(t, 800-40-75, box.CenterY, 1e-9)
+
+	// 居中时忽略边距
+	opts.Anchor = AnchorCenter
+	box = opts.place(page, 300, 150)
+	assert.InDelta(t, 300, box.CenterX, 1e-9)
+	assert.InDelta(t, 400, box.CenterY, 1e-9)
+
+	// 旋转 90 度后按旋转后的外接矩形贴边
+	opts.Anchor, opts.Rotation = AnchorBottomRight, 90
+	box = opts.place(page, 300, 150)
+	assert.InDelta(t, 600-10-75, box.CenterX, 1e-9)
+	assert.InDelta(t, 40+150, box.CenterY, 1e-9)
+
+	// 单位正方形的四个角变换后落在外接矩形的边上
+	m := box.matrix(1, 1)
+	x := float64(m.A + m.C + m.E)
+	y := float64(m.B + m.D + m.F)
+	assert.InDelta(t, 600-10-150, x, 1e-3)
+	assert.InDelta(t, 40+300, y, 1e-3)
+	assert.InDelta(t, 600-10, float64(m.E), 1e-3)
+	assert.InDelta(t, 40, float64(m.F), 1e-3)
+
+	assert.NotNil(t, (&WatermarkOptions{Anchor: AnchorTop, Size: 0, Opacity: 1}).Validate())
+	assert.NotNil(t, (&WatermarkOptions{Anchor: AnchorTop, Size: 0.1, Opacity: 1.5}).Validate())
+	assert.NotNil(t, (&WatermarkOptions{Anchor: AnchorTop, Size: 0.1, Opacity: 1, ZOrder: "middle"}).Validate())
+}
+
+func TestWatermarkLayout(t *testing.T) {
+	page := pageBox{Width: 600, Height: 800}
+	opts := WatermarkOptions{Tile: true, SpacingX: Length{Value: 20}, SpacingY: Length{Value: 30}}
+
+	// 以页面中心为原点，横向间隔 120、纵向间隔 80，只保留与页面相交的位置
+	boxes, err := opts.layout(page, 100, 50)
+	assert.Nil(t, err)
+	assert.Len(t, boxes, 5*11)
+	centers := make(map[[2]float64]bool)
+	for _, b := range boxes {
+		centers[[2]float64{math.Round(b.CenterX), math.Round(b.CenterY)}] = true
+	}
+	assert.True(t, centers[[2]float64{300, 400}])
+	assert.True(t, centers[[2]float64{60, 800}])
+	assert.False(t, centers[[2]float64{660, 400}])
+
+	// 隔行错开半格
+	opts.Stagger = true
+	boxes, err = opts.layout(page, 100, 50)
+	assert.Nil(t, err)
+	centers = make(map[[2]float64]bool)
+	for _, b := range boxes {
+		centers[[2]float64{math.Round(b.CenterX), math.Round(b.CenterY)}] = true
+	}
+	assert.True(t, centers[[2]float64{300, 400}])
+	assert.True(t, centers[[2]float64{360, 480}])
+	assert.False(t, centers[[2]float64{300, 480}])
+
+	// 旋转后网格随之旋转
+	opts.Stagger, opts.Rotation = false, 90
+	boxes, err = opts.layout(page, 100, 50)
+	assert.Nil(t, err)
+	for _, b := range boxes {
+		assert.InDelta(t, 0, math.Mod(math.Abs(b.CenterX-300)+0.5, 80)-0.5, 1e-6)
+	}
+
+	// 水印太小、间距太小时数量过多
+	opts.SpacingX, opts.SpacingY = Length{}, Length{}
+	_, err = opts.layout(page, 5, 5)
+	assert.NotNil(t, err)
+
+	// 不平铺时只有一个位置
+	opts.Tile = false
+	boxes, err = opts.layout(page, 100, 50)
+	assert.Nil(t, err)
+	assert.Len(t, boxes, 1)
+}
+
+func TestWatermark(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	pdf := newTestPDF(t, processor, 2)
+	processor.Run(context.Background(), nil, nil)
+	instance, err := processor.pool.GetInstance(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	// 不透明的纯红色水印
+	logo := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	for i := range logo.Pix {
+		logo.Pix[i] = []uint8{255, 0, 0, 255}[i%4]
+	}
+	var logoData bytes.Buffer
+	assert.Nil(t, png.Encode(&logoData, logo))
+	logoPath := filepath.Join(t.TempDir(), "logo.png")
+	assert.Nil(t, os.WriteFile(logoPath, logoData.Bytes(), 0644))
+
+	// render 添加水印后渲染第 1 页，返回 PDF 坐标 (x, y) 处的颜色
+	render := func(opts WatermarkOptions) func(x, y float64) color.RGBA {
+		var out bytes.Buffer
+		_, err := Watermark(context.Background(), instance, logoPath, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := renderFirstPage(t, instance, out.Bytes())
+		return func(x, y float64) color.RGBA {
+			r, g, b, _ := img.At(int(x), img.Bounds().Dy()-1-int(y)).RGBA()
+			return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 255}
+		}
+	}
+	red := color.RGBA{R: 255, A: 255}
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	near := func(want, got color.RGBA) bool {
+		d := math.Abs(float64(want.R)-float64(got.R)) + math.Abs(float64(want.G)-float64(got.G)) + math.Abs(float64(want.B)-float64(got.B))
+		return d < 24
+	}
+
+	// 默认在右下角：595x842 的页面上宽 59.5，距右边缘 20.8、下边缘 11.8
+	at := render(DefaultWatermarkOptions())
+	assert.True(t, near(red, at(545, 26)), "%v", at(545, 26))
+	assert.True(t, near(white, at(545, 60)), "%v", at(545, 60))
+	assert.True(t, near(white, at(30, 26)), "%v", at(30, 26))
+
+	opts := DefaultWatermarkOptions()
+	opts.Anchor, opts.MarginX, opts.MarginY = AnchorTopLeft, Length{Value: 10}, Length{Value: 10}
+	opts.Opacity = 0.5
+	at = render(opts)
+	assert.True(t, near(color.RGBA{R: 255, G: 128, B: 128, A: 255}, at(40, 817)), "%v", at(40, 817))
+
+	// 页面中央是测试图片，放在内容下方时被遮住
+	opts = DefaultWatermarkOptions()
+	opts.Anchor = AnchorCenter
+	at = render(opts)
+	assert.True(t, near(red, at(297, 421)), "%v", at(297, 421))
+	opts.ZOrder = ZOrderBack
+	at = render(opts)
+	assert.False(t, near(red, at(297, 421)), "%v", at(297, 421))
+
+	// 另一标识的水印在页面上追加了一个内容流，放到内容下方前就拒绝，不改动原有对象
+	var out bytes.Buffer
+	front := DefaultWatermarkOptions()
+	front.ID = "draft"
+	_, err = Watermark(context.Background(), instance, logoPath, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, front)
+	assert.Nil(t, err)
+	streams := out.Bytes()
+	out = bytes.Buffer{}
+	_, err = Watermark(context.Background(), instance, logoPath, PDFInput{Data: streams}, PDFOutput{Writer: &out}, opts)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "2 个内容流")
+	}
+	assert.Zero(t, out.Len())
+	// 替换同一标识的水印时旧水印所在的内容流被删除，可以放到下方
+	front.ZOrder = ZOrderBack
+	_, err = Watermark(context.Background(), instance, logoPath, PDFInput{Data: streams}, PDFOutput{Writer: &out}, front)
+	assert.Nil(t, err)
+	assert.Equal(t, objectCounts(t, instance, streams), objectCounts(t, instance, out.Bytes()))
+
+	out.Reset()
+	_, err = Watermark(context.Background(), instance, logoPath, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, WatermarkOptions{Size: 2, Opacity: 1})
+	assert.NotNil(t, err)
+	assert.Zero(t, out.Len())
+
+	// GIF 解码为位图
+	var gifData bytes.Buffer
+	assert.Nil(t, gif.Encode(&gifData, logo, nil))
+	logoPath = filepath.Join(t.TempDir(), "logo.gif")
+	assert.Nil(t, os.WriteFile(logoPath, gifData.Bytes(), 0644))
+	at = render(DefaultWatermarkOptions())
+	assert.True(t, near(red, at(545, 26)), "%v", at(545, 26))
+
+	// JPEG 原样嵌入：左红右蓝，EXIF 方向 6 表示需要顺时针旋转 90 度，摆正后上红下蓝、竖放
+	for i := range logo.Pix {
+		if i/4%64 >= 32 {
+			logo.Pix[i] = []uint8{0, 0, 255, 255}[i%4]
+		}
+	}
+	var jpegData bytes.Buffer
+	assert.Nil(t, jpeg.Encode(&jpegData, logo, &jpeg.Options{Quality: 100}))
+	exif := []byte("\xff\xe1\x00\x22Exif\x00\x00MM\x00*\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	jpegBytes := append(append(append([]byte{}, jpegData.Bytes()[:2]...), exif...), jpegData.Bytes()[2:]...)
+	logoPath = filepath.Join(t.TempDir(), "logo.jpg")
+	assert.Nil(t, os.WriteFile(logoPath, jpegBytes, 0644))
+
+	out.Reset()
+	_, err = Watermark(context.Background(), instance, logoPath, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, DefaultWatermarkOptions())
+	assert.Nil(t, err)
+	assert.True(t, bytes.Contains(out.Bytes(), jpegBytes))
+	// 两页共用一份 JPEG
+	assert.Less(t, out.Len()-len(pdf), len(jpegBytes)+4<<10)
+	at = render(DefaultWatermarkOptions())
+	blue := color.RGBA{B: 255, A: 255}
+	// 宽 59.5、高 119，距下边缘 11.8
+	assert.True(t, near(red, at(545, 110)), "%v", at(545, 110))
+	assert.True(t, near(blue, at(545, 30)), "%v", at(545, 30))
+	assert.True(t, near(white, at(545, 140)), "%v", at(545, 140))
+
+	// 半透明时解码为位图，同样按 EXIF 方向摆正
+	opts = DefaultWatermarkOptions()
+	opts.Opacity = 0.5
+	at = render(opts)
+	assert.True(t, near(color.RGBA{R: 255, G: 128, B: 128, A: 255}, at(545, 110)), "%v", at(545, 110))
+	assert.True(t, near(color.RGBA{R: 128, G: 128, B: 255, A: 255}, at(545, 30)), "%v", at(545, 30))
+
+	// 平铺时所有位置共用一份图片，每页 3 处和 425 处的文件大小几乎相同
+	noise := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	rand.New(rand.NewSource(1)).Read(noise.Pix)
+	var noiseData bytes.Buffer
+	assert.Nil(t, png.Encode(&noiseData, noise))
+	logoPath = filepath.Join(t.TempDir(), "noise.png")
+	assert.Nil(t, os.WriteFile(logoPath, noiseData.Bytes(), 0644))
+	tiled := func(size float64) []byte {
+		opts := DefaultWatermarkOptions()
+		opts.Tile, opts.Size, opts.Rotation, opts.SpacingX, opts.SpacingY = true, size, 0, Length{}, Length{}
+		var out bytes.Buffer
+		if _, err := Watermark(context.Background(), instance, logoPath, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, opts); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+	few, many := tiled(1), tiled(0.06)
+	assert.Equal(t, []int{4, 4}, objectCounts(t, instance, few))
+	assert.Equal(t, []int{426, 426}, objectCounts(t, instance, many))
+	assert.Less(t, len(few)-len(pdf), noiseData.Len()+4<<10)
+	assert.Less(t, len(many)-len(few), noiseData.Len()/10)
+}
+
+func TestWatermarkRotatedPages(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	pdf := newTestPDF(t, processor, 5)
+	processor.Run(context.Background(), nil, nil)
+	instance, err := processor.pool.GetInstance(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	// 第 2-4 页分别旋转 90、270、180 度，后两页的 CropBox 不从原点开始；
+	// 第 5 页的 CropBox 超出 MediaBox 的右上角，可见区域是两者的交集
+	doc, err := LoadDocument(instance, PDFInput{Data: pdf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range []struct {
+		rotation enums.FPDF_PAGE_ROTATION
+		crop     []float32
+	}{{enums.FPDF_PAGE_ROTATION_90_CW, nil}, {enums.FPDF_PAGE_ROTATION_270_CW, []float32{100, 200, 500, 700}}, {enums.FPDF_PAGE_ROTATION_180_CW, []float32{50, 60, 400, 500}}, {enums.FPDF_PAGE_ROTATION_NONE, []float32{100, 50, 800, 1000}}} {
+		pageRes, err := instance.FPDF_LoadPage(&requests.FPDF_LoadPage{Document: doc, Index: i + 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := requests.Page{ByReference: &pageRes.Page}
+		_, err = instance.FPDFPage_SetRotation(&requests.FPDFPage_SetRotation{Page: page, Rotate: p.rotation})
+		assert.Nil(t, err)
+		if p.crop != nil {
+			_, err = instance.FPDFPage_SetCropBox(&requests.FPDFPage_SetCropBox{Page: page, Left: p.crop[0], Bottom: p.crop[1], Right: p.crop[2], Top: p.crop[3]})
+			assert.Nil(t, err)
+		}
+		instance.FPDF_ClosePage(&requests.FPDF_ClosePage{Page: pageRes.Page})
+	}
+	box, err := getPageBox(instance, doc, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, pageBox{Left: 100, Bottom: 50, Width: 495, Height: 792}, box)
+	var rotated bytes.Buffer
+	assert.Nil(t, SaveDocument(instance, doc, PDFOutput{Writer: &rotated}, 0))
+	instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{Document: doc})
+
+	// 左红右蓝的水印放在左上角，页脚放在底部居中
+	logo := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	for i := range logo.Pix {
+		logo.Pix[i] = [][]uint8{{255, 0, 0, 255}, {0, 0, 255, 255}}[i/4%64/32][i%4]
+	}
+	var logoData bytes.Buffer
+	assert.Nil(t, png.Encode(&logoData, logo))
+	logoPath := filepath.Join(t.TempDir(), "logo.png")
+	assert.Nil(t, os.WriteFile(logoPath, logoData.Bytes(), 0644))
+
+	opts := DefaultWatermarkOptions()
+	opts.Anchor, opts.MarginX, opts.MarginY, opts.Size = AnchorTopLeft, Length{Value: 10}, Length{Value: 10}, 0.2
+	var logoPDF bytes.Buffer
+	_, err = Watermark(context.Background(), instance, logoPath, PDFInput{Data: rotated.Bytes()}, PDFOutput{Writer: &logoPDF}, opts)
+	assert.Nil(t, err)
+	footer := DefaultStampSpec("Page {page}")
+	footer.Color = Color{255, 0, 0, 255}
+	var out bytes.Buffer
+	_, err = AddStamps(context.Background(), instance, PDFInput{Data: logoPDF.Bytes()}, PDFOutput{Writer: &out}, StampOptions{Stamps: []StampSpec{footer}})
+	assert.Nil(t, err)
+
+	doc, err = LoadDocument(instance, PDFInput{Data: out.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{Document: doc})
+	for i, size := range [][2]int{{595, 842}, {842, 595}, {500, 400}, {350, 440}, {495, 792}} {
+		img, err := RenderPage(instance, doc, i, 72)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, size, [2]int{img.Bounds().Dx(), img.Bounds().Dy()}, "第 %d 页", i+1)
+
+		// 水印宽为短边的 20%，在可见区域左上角，方向与显示方向一致
+		width := math.Min(float64(size[0]), float64(size[1])) * 0.2
+		r, g, b, _ := img.At(int(10+width/4), int(10+width/4)).RGBA()
+		assert.True(t, r>>8 > 200 && g>>8 < 50 && b>>8 < 50, "第 %d 页左上角: %d %d %d", i+1, r>>8, g>>8, b>>8)
+		r, g, b, _ = img.At(int(10+width*3/4), int(10+width/4)).RGBA()
+		assert.True(t, r>>8 < 50 && g>>8 < 50 && b>>8 > 200, "第 %d 页左上角: %d %d %d", i+1, r>>8, g>>8, b>>8)
+
+		// 页脚是横排的文字，在底部居中，距下边缘 24pt
+		// 页脚可能压在测试图片上，只统计纯红色的像素
+		var bounds image.Rectangle
+		for y := size[1] / 2; y < size[1]; y++ {
+			for x := 0; x < size[0]; x++ {
+				if r, g, b, _ := img.At(x, y).RGBA(); r>>8 > 200 && g>>8 < 80 && b>>8 < 80 {
+					bounds = bounds.Union(image.Rect(x, y, x+1, y+1))
+				}
+			}
+		}
+		assert.Greater(t, bounds.Dx(), 2*bounds.Dy(), "第 %d 页页脚: %v", i+1, bounds)
+		assert.InDelta(t, size[0]/2, (bounds.Min.X+bounds.Max.X)/2, 2, "第 %d 页页脚: %v", i+1, bounds)
+		assert.InDelta(t, size[1]-24, bounds.Max.Y, 2, "第 %d 页页脚: %v", i+1, bounds)
+	}
+}
+
+// rawPDF 按顺序拼出对象 1..n 并生成 xref，模拟其他软件生成的 PDF，对象 1 为 Catalog
+func rawPDF(objects ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func TestWatermarkForeignContents(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	instance, err := processor.pool.GetInstance(time.Second * 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	logoPath := filepath.Join(t.TempDir(), "logo.png")
+	assert.Nil(t, os.WriteFile(logoPath, newTestLogo(t), 0644))
+
+	stream := func(content string) string {
+		return fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content)
+	}
+	// newPDF 生成一页蓝底的 PDF，contents 为页面的 /Contents
+	newPDF := func(contents string) []byte {
+		return rawPDF(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 200 200] /Contents "+contents+" >>",
+			stream("q 0 0 1 rg 0 0 200 200 re f Q"),
+			stream("q 0 0 0 rg 0 0 20 20 re f Q"),
+			"[4 0 R 5 0 R]",
+		)
+	}
+	opts := DefaultWatermarkOptions()
+	opts.Anchor, opts.Size, opts.Opacity, opts.ZOrder = AnchorCenter, 0.5, 1, ZOrderBack
+
+	// 其他软件生成的页面由多个内容流组成（直接写数组或间接引用数组）时，拒绝放到内容下方
+	for _, contents := range []string{"[4 0 R 5 0 R]", "6 0 R"} {
+		var out bytes.Buffer
+		_, err = Watermark(context.Background(), instance, logoPath, PDFInput{Data: newPDF(contents)}, PDFOutput{Writer: &out}, opts)
+		if assert.NotNil(t, err, contents) {
+			assert.Contains(t, err.Error(), "2 个内容流")
+		}
+		assert.Zero(t, out.Len())
+	}
+
+	// 只有一个内容流时可以放到下方，被蓝底遮住；放在上方时可见
+	center := func(opts WatermarkOptions) []uint32 {
+		var out bytes.Buffer
+		_, err := Watermark(context.Background(), instance, logoPath, PDFInput{Data: newPDF("4 0 R")}, PDFOutput{Writer: &out}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, g, b, _ := renderFirstPage(t, instance, out.Bytes()).At(100, 100).RGBA()
+		return []uint32{r >> 8, g >> 8, b >> 8}
+	}
+	blue := []uint32{0, 0, 255}
+	assert.Equal(t, blue, center(opts))
+	opts.ZOrder = ZOrderFront
+	assert.NotEqual(t, blue, center(opts))
+}
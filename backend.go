@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/multi_threaded"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/responses"
+	"github.com/klippa-app/go-pdfium/single_threaded"
+	"github.com/klippa-app/go-pdfium/webassembly"
+)
+
+// Backend pdfium 的实现方式
+type Backend string
+
+const (
+	BackendSingleThreaded Backend = "single_threaded" // cgo 直接调用 libpdfium，同一进程内所有调用串行
+	BackendMultiThreaded  Backend = "multi_threaded"  // 每个实例是一个 cgo 子进程，实例之间可并行
+	BackendWebAssembly    Backend = "webassembly"     // 通过 wazero 运行内嵌的 pdfium.wasm，不依赖系统库
+)
+
+// BackendConfig pdfium 初始化配置
+type BackendConfig struct {
+	Backend Backend       // 为空时使用 single_threaded
+	Workers int           // multi_threaded、webassembly 池中的最大实例数，<=0 时取 CPU 核数
+	Timeout time.Duration // 等待空闲实例的超时，默认 30s
+
+	// Command multi_threaded 子进程命令，默认以 pdfium-worker 参数启动当前程序
+	Command *multi_threaded.Command
+}
+
+// InitPool 按配置初始化 pdfium 池
+func InitPool(config BackendConfig) (pdfium.Pool, error) {
+	workers := config.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	switch config.Backend {
+	case BackendSingleThreaded, "":
+		return single_threaded.Init(single_threaded.Config{}), nil
+
+	case BackendMultiThreaded:
+		var command multi_threaded.Command
+		if config.Command != nil {
+			command = *config.Command
+		} else {
+			binPath, err := os.Executable()
+			if err != nil {
+				return nil, fmt.Errorf("无法获取可执行文件路径: %v", err)
+			}
+			command = multi_threaded.Command{
+				BinPath: binPath,
+				Args:    []string{workerArg},
+			}
+		}
+
+		return multi_threaded.Init(multi_threaded.Config{
+			MinIdle:  1,
+			MaxIdle:  workers,
+			MaxTotal: workers,
+			Command:  command,
+		}), nil
+
+	case BackendWebAssembly:
+		pool, err := webassembly.Init(webassembly.Config{
+			MinIdle:  1,
+			MaxIdle:  workers,
+			MaxTotal: workers,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("无法初始化 webassembly pdfium: %v", err)
+		}
+		return webassemblyPool{pool}, nil
+	}
+
+	return nil, fmt.Errorf("不支持的 pdfium 实现: %s", config.Backend)
+}
+
+// PdfiumEngine 持有一个 pdfium 池和从中取出的实例，可直接当作 pdfium.Pdfium 使用
+type PdfiumEngine struct {
+	pdfium.Pdfium
+	pool pdfium.Pool
+}
+
+// NewPdfium 按配置初始化 pdfium 并返回可用的实例，用完后调用 Close 释放实例和池
+func NewPdfium(config BackendConfig) (*PdfiumEngine, error) {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = time.Second * 30
+	}
+
+	pool, err := InitPool(config)
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err := pool.GetInstance(timeout)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return &PdfiumEngine{
+		Pdfium: instance,
+		pool:   pool,
+	}, nil
+}
+
+// Pool 返回底层的池，可以从中获取更多实例
+func (e *PdfiumEngine) Pool() pdfium.Pool {
+	return e.pool
+}
+
+// Close 关闭实例和池
+func (e *PdfiumEngine) Close() error {
+	e.Pdfium.Close()
+	return e.pool.Close()
+}
+
+// webassemblyPool 包装 webassembly 池，取出的实例统一替换为 webassemblyInstance
+type webassemblyPool struct {
+	pdfium.Pool
+}
+
+func (p webassemblyPool) GetInstance(timeout time.Duration) (pdfium.Pdfium, error) {
+	instance, err := p.Pool.GetInstance(timeout)
+	if err != nil {
+		return nil, err
+	}
+	return webassemblyInstance{instance}, nil
+}
+
+// webassemblyInstance go-pdfium 的 webassembly 实现把单个页面句柄当作页面数组指针传给
+// FPDFImageObj_LoadJpegFile(Inline)/SetBitmap，只要传入 Page 就会触发 invalid table access。
+// 这里去掉 Page，页面缓存在重新生成内容和保存时不受影响
+type webassemblyInstance struct {
+	pdfium.Pdfium
+}
+
+func (i webassemblyInstance) FPDFImageObj_LoadJpegFile(request *requests.FPDFImageObj_LoadJpegFile) (*responses.FPDFImageObj_LoadJpegFile, error) {
+	req := *request
+	req.Page, req.Count = nil, 0
+	return i.Pdfium.FPDFImageObj_LoadJpegFile(&req)
+}
+
+func (i webassemblyInstance) FPDFImageObj_LoadJpegFileInline(request *requests.FPDFImageObj_LoadJpegFileInline) (*responses.FPDFImageObj_LoadJpegFileInline, error) {
+	req := *request
+	req.Page, req.Count = nil, 0
+	return i.Pdfium.FPDFImageObj_LoadJpegFileInline(&req)
+}
+
+func (i webassemblyInstance) FPDFImageObj_SetBitmap(request *requests.FPDFImageObj_SetBitmap) (*responses.FPDFImageObj_SetBitmap, error) {
+	req := *request
+	req.Page, req.Count = nil, 0
+	return i.Pdfium.FPDFImageObj_SetBitmap(&req)
+}
+
+// Kill go-pdfium 的 webassembly 实现在 Kill 中先把 pool 置空再使用，panic 被吞掉后实例不会归还，
+// 池很快被占满。webassembly 的 Close 默认会销毁模块而不是复用，效果与 Kill 相同
+func (i webassemblyInstance) Kill() error {
+	return i.Pdfium.Close()
+}
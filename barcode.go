@@ -0,0 +1,126 @@
+package main
+
+import (
+	"compress-pdfium/util"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+// BarcodeType 条码类型
+type BarcodeType string
+
+const (
+	BarcodeQR      BarcodeType = "qr"
+	BarcodeCode128 BarcodeType = "code128"
+)
+
+// barcodeScale 每个模块的像素数，条码图片按整数倍放大，避免阅读器缩放时边缘模糊
+const barcodeScale = 4
+
+// BarcodeSpec 一个条码：Data 为模板，占位符与印章相同，位置和大小见 WatermarkOptions，
+// Size 为条码宽度（含空白区）占页面短边的比例
+type BarcodeSpec struct {
+	Type  BarcodeType `json:"type"`
+	Data  string      `json:"data"`
+	Level string      `json:"level,omitempty"` // QR 纠错等级 L、M、Q、H
+	WatermarkOptions
+}
+
+// DefaultBarcodeSpec 放在页面左下角，距页边 36pt、24pt，QR 码宽为页面短边的 10%，Code128 为 30%
+func DefaultBarcodeSpec(typ BarcodeType, data string) BarcodeSpec {
+	spec := BarcodeSpec{Type: typ, Data: data, Level: "M", WatermarkOptions: DefaultWatermarkOptions()}
+	spec.Anchor, spec.MarginX, spec.MarginY = AnchorBottomLeft, Length{Value: 36}, Length{Value: 24}
+	if typ == BarcodeCode128 {
+		spec.Size = 0.3
+	}
+	return spec
+}
+
+// Validate 检查条码参数
+func (s *BarcodeSpec) Validate() error {
+	s.Type = BarcodeType(strings.ToLower(string(s.Type)))
+	switch s.Type {
+	case BarcodeQR:
+		if s.Level == "" {
+			s.Level = "M"
+		}
+		if _, err := util.ParseQRLevel(s.Level); err != nil {
+			return err
+		}
+	case BarcodeCode128:
+	default:
+		return fmt.Errorf("未知的条码类型 %q，可选 qr、code128", s.Type)
+	}
+	// 条码不平铺、不透明，总在内容上方，保证能扫出来；条码图片自带白色底，不调整对比度
+	s.Tile, s.ZOrder, s.Opacity, s.Contrast = false, ZOrderFront, 1, ContrastNone
+	return s.WatermarkOptions.Validate()
+}
+
+// image 生成条码图片
+func (s BarcodeSpec) image(content string) (image.Image, error) {
+	if s.Type == BarcodeCode128 {
+		return util.Code128Image(content, barcodeScale, 0)
+	}
+	level, err := util.ParseQRLevel(s.Level)
+	if err != nil {
+		return nil, err
+	}
+	return util.QRCodeImage(content, level, barcodeScale)
+}
+
+// barcodeLayer 条码，内容随页面变化，每页单独生成位图
+type barcodeLayer struct {
+	spec    BarcodeSpec
+	content func(index int) string
+}
+
+func (l *barcodeLayer) objects(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, page *watermarkPage, index int) ([]references.FPDF_PAGEOBJECT, error) {
+	content := l.content(index)
+	if content == "" {
+		return nil, nil
+	}
+	img, err := l.spec.image(content)
+	if err != nil {
+		return nil, fmt.Errorf("无法生成条码 %q: %v", content, err)
+	}
+
+	// 不带 alpha 通道，保存时不会生成 SMask
+	bitmap, err := CreateBitmapFromImage(instance, img, 0)
+	if bitmap.bitmapRef != "" {
+		// SetBitmap 会把像素编码进图片对象，之后位图就可以释放
+		defer instance.FPDFBitmap_Destroy(&requests.FPDFBitmap_Destroy{
+			Bitmap: bitmap.bitmapRef,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	width := l.spec.Size * math.Min(page.Width, page.Height)
+	box, err := page.place(instance, l.spec.WatermarkOptions, width, width*float64(bitmap.height)/float64(bitmap.width))
+	if err != nil {
+		return nil, err
+	}
+	obj, err := newImageObject(instance, document, bitmap.bitmapRef)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = instance.FPDFImageObj_SetMatrix(&requests.FPDFImageObj_SetMatrix{
+		ImageObject: obj,
+		Transform:   box.matrix(1, 1),
+	}); err != nil {
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: obj,
+		})
+		return nil, err
+	}
+	return []references.FPDF_PAGEOBJECT{obj}, nil
+}
+
+func (l *barcodeLayer) close(instance pdfium.Pdfium) {}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/multi_threaded"
+)
+
+// workerArg 以该参数启动本程序时作为 multi_threaded 的 pdfium 子进程运行
+const workerArg = "pdfium-worker"
+
+// isWorkerProcess 当前进程是否是 pdfium 子进程
+func isWorkerProcess() bool {
+	return len(os.Args) > 1 && os.Args[1] == workerArg
+}
+
+// BatchConfig 批处理配置
+type BatchConfig struct {
+	Backend         Backend                 // 为空时使用 multi_threaded
+	Workers         int                     // 同时处理的文档数，也是池中的实例数，<=0 时取 CPU 核数
+	InstanceTimeout time.Duration           // 等待空闲实例的超时，默认 30s
+	Command         *multi_threaded.Command // pdfium 子进程命令，默认以 pdfium-worker 参数启动当前程序
+}
+
+// BatchJob 批处理中的一个文档
+type BatchJob struct {
+	Name   string
+	Input  PDFInput
+	Output PDFOutput
+}
+
+// BatchResult 单个文档的处理结果
+type BatchResult struct {
+	Job      BatchJob
+	Err      error
+	Duration time.Duration
+}
+
+// BatchFunc 处理单个文档，instance 只在本次调用内有效
+type BatchFunc func(ctx context.Context, instance pdfium.Pdfium, job BatchJob) error
+
+// CompressJob 返回压缩文档的 BatchFunc
+func CompressJob(opts CompressOptions) BatchFunc {
+	return func(ctx context.Context, instance pdfium.Pdfium, job BatchJob) error {
+		return CompressImages(ctx, instance, job.Input, job.Output, opts)
+	}
+}
+
+// BatchProcessor 基于 pdfium 池的批处理器，每个文档独占一个实例（multi_threaded 下即一个子进程）
+type BatchProcessor struct {
+	pool    pdfium.Pool
+	workers int
+	timeout time.Duration
+}
+
+func NewBatchProcessor(config BatchConfig) (*BatchProcessor, error) {
+	workers := config.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	timeout := config.InstanceTimeout
+	if timeout <= 0 {
+		timeout = time.Second * 30
+	}
+
+	backend := config.Backend
+	if backend == "" {
+		backend = BackendMultiThreaded
+	}
+
+	pool, err := InitPool(BackendConfig{
+		Backend: backend,
+		Workers: workers,
+		Command: config.Command,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &BatchProcessor{
+		pool:    pool,
+		workers: workers,
+		timeout: timeout,
+	}, nil
+}
+
+// Run 并发处理 jobs 中的文档，结果按完成顺序写入返回的 channel，全部完成后关闭
+// ctx 取消后不再领取新文档，正在处理的文档会在页面或图片之间中止
+func (b *BatchProcessor) Run(ctx context.Context, jobs <-chan BatchJob, fn BatchFunc) <-chan BatchResult {
+	results := make(chan BatchResult)
+
+	var wg sync.WaitGroup
+	for w := 0; w < b.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job, ok := <-jobs:
+					if !ok {
+						return
+					}
+					results <- b.process(ctx, job, fn)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// process 为单个文档借出实例，处理完成后归还
+func (b *BatchProcessor) process(ctx context.Context, job BatchJob, fn BatchFunc) (res BatchResult) {
+	res.Job = job
+
+	beginTime := time.Now()
+	defer func() {
+		res.Duration = time.Since(beginTime)
+	}()
+
+	instance, err := b.getInstance(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			res.Err = err
+		} else {
+			res.Err = fmt.Errorf("无法获取 pdfium 实例: %v", err)
+		}
+		return
+	}
+
+	if res.Err = fn(ctx, instance, job); res.Err != nil && workerFailed(res.Err) {
+		// 子进程崩溃或连接断开时实例已不可用，直接杀掉，由进程池重新创建
+		instance.Kill()
+		return
+	}
+
+	// 无效输入、结构检查失败、ctx 取消等错误时实例本身正常，照常归还
+	if err = instance.Close(); err != nil {
+		instance.Kill()
+		if res.Err == nil {
+			res.Err = fmt.Errorf("无法归还 pdfium 实例: %v", err)
+		}
+	}
+	return
+}
+
+// getInstance 从池中借出实例，ctx 取消时不再等待
+func (b *BatchProcessor) getInstance(ctx context.Context) (pdfium.Pdfium, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		instance pdfium.Pdfium
+		err      error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		instance, err := b.pool.GetInstance(b.timeout)
+		ch <- result{instance, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.instance, r.err
+	case <-ctx.Done():
+		// GetInstance 本身无法中断，等它返回后把实例还回池中
+		go func() {
+			if r := <-ch; r.err == nil {
+				r.instance.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// workerFailures 子进程崩溃、RPC 连接断开或 pdfium 内部 panic 时错误中出现的文字
+// 错误在各层多以 %v 包装，只能按文字判断
+var workerFailures = []string{
+	rpc.ErrShutdown.Error(),
+	io.ErrUnexpectedEOF.Error(),
+	"panic occurred in",
+	"instance is closed",
+	"plugin exited",
+}
+
+// workerFailed 错误是否说明实例已不可用
+func workerFailed(err error) bool {
+	if errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range workerFailures {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close 关闭进程池及所有子进程
+func (b *BatchProcessor) Close() error {
+	return b.pool.Close()
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"compress-pdfium/util"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DirBatchOptions 目录批处理参数
+type DirBatchOptions struct {
+	InputDir  string // 递归查找其中的 .pdf 文件
+	OutputDir string // 按相对路径输出到该目录
+	Force     bool   // 为 true 时忽略已是最新的输出，全部重新处理
+
+	// ManifestPath 非空时把每个文件的状态、输入哈希和处理参数记录到该清单，
+	// 重新运行时跳过输入和参数都未变化的文件，中断后可以从上次的位置继续
+	ManifestPath string
+	Settings     string // 处理参数指纹，变化时重新处理所有文件
+	RetryFailed  bool   // 为 true 时重新处理输入和参数都未变化的失败文件
+
+	Preserve bool // 为 true 时输出文件沿用输入文件的权限和修改时间
+}
+
+// DirBatchFile 单个文件的处理结果
+type DirBatchFile struct {
+	RelPath    string
+	InputSize  int64
+	OutputSize int64
+	Skipped    bool // 输出已是最新，未处理
+	Err        error
+	Duration   time.Duration
+}
+
+// DirBatchSummary 目录批处理汇总
+type DirBatchSummary struct {
+	Files       []DirBatchFile
+	Processed   int
+	Skipped     int
+	Failed      int
+	Excluded    int   // 输出目录在输入目录之内时，不当作输入的已有输出
+	InputBytes  int64 // 成功处理的文件的原大小之和
+	OutputBytes int64 // 成功处理的文件的输出大小之和
+	Duration    time.Duration
+}
+
+// RunDirBatch 处理 InputDir 下的所有 PDF，输出保持相同的目录结构
+func RunDirBatch(ctx context.Context, processor *BatchProcessor, opts DirBatchOptions, fn BatchFunc) (*DirBatchSummary, error) {
+	beginTime := time.Now()
+	summary := &DirBatchSummary{}
+
+	inputDir, outputDir, err := resolveDirs(opts.InputDir, opts.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest *util.Manifest
+	if opts.ManifestPath != "" {
+		if manifest, err = util.OpenManifest(opts.ManifestPath); err != nil {
+			return nil, fmt.Errorf("无法打开清单: %v", err)
+		}
+		defer manifest.Close()
+	}
+
+	var batchJobs []BatchJob
+	var entries = make(map[string]util.ManifestEntry)
+	for _, inputPath := range util.GetFilePath(inputDir, ".pdf") {
+		outputPath, err := util.MirrorPath(inputDir, outputDir, inputPath)
+		if err != nil {
+			return nil, err
+		}
+		relPath, _ := filepath.Rel(inputDir, inputPath)
+
+		// 输出目录在输入目录之内时，不处理已有的输出
+		if insideDir(inputPath, outputDir) {
+			summary.Excluded++
+			continue
+		}
+
+		var skip bool
+		if manifest != nil {
+			// 清单中没有记录的文件不知道输出是用什么参数生成的，即使输出比输入新也重新处理
+			entry, err := manifestEntry(manifest, relPath, inputPath, opts.Settings)
+			if err != nil {
+				return nil, err
+			}
+			skip = !opts.Force && shouldSkip(entry, outputPath, opts.RetryFailed)
+			entries[relPath] = entry
+		} else {
+			skip = !opts.Force && util.IsUpToDate(inputPath, outputPath)
+		}
+
+		if skip {
+			summary.Files = append(summary.Files, DirBatchFile{RelPath: relPath, Skipped: true})
+			summary.Skipped++
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+			return nil, err
+		}
+
+		if manifest != nil {
+			entry := entries[relPath]
+			entry.State, entry.Error, entry.OutputSize = util.StatePending, "", 0
+			if err = manifest.Update(entry); err != nil {
+				return nil, err
+			}
+			entries[relPath] = entry
+		}
+
+		job := BatchJob{
+			Name:   relPath,
+			Input:  PDFInput{Path: inputPath, Name: filepath.Base(inputPath)},
+			Output: PDFOutput{Path: outputPath},
+		}
+		if opts.Preserve {
+			job.Output.PreserveFrom = inputPath
+		}
+		batchJobs = append(batchJobs, job)
+	}
+
+	jobs := make(chan BatchJob)
+	go func() {
+		defer close(jobs)
+		for _, job := range batchJobs {
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var manifestErr error
+	for res := range processor.Run(ctx, jobs, fn) {
+		file := DirBatchFile{
+			RelPath:  res.Job.Name,
+			Err:      res.Err,
+			Duration: res.Duration,
+		}
+		if info, err := os.Stat(res.Job.Input.Path); err == nil {
+			file.InputSize = info.Size()
+		}
+
+		if res.Err != nil {
+			summary.Failed++
+		} else {
+			if info, err := os.Stat(res.Job.Output.Path); err == nil {
+				file.OutputSize = info.Size()
+			}
+			summary.Processed++
+			summary.InputBytes += file.InputSize
+			summary.OutputBytes += file.OutputSize
+		}
+		summary.Files = append(summary.Files, file)
+
+		if manifest != nil {
+			entry := entries[res.Job.Name]
+			entry.State, entry.OutputSize = util.StateDone, file.OutputSize
+			if res.Err != nil {
+				entry.State, entry.Error = util.StateFailed, res.Err.Error()
+			}
+			// 不能提前返回，否则处理协程会阻塞在结果发送上
+			if err = manifest.Update(entry); err != nil && manifestErr == nil {
+				manifestErr = fmt.Errorf("无法更新清单: %v", err)
+			}
+		}
+	}
+
+	summary.Duration = time.Since(beginTime)
+
+	if manifestErr != nil {
+		return summary, manifestErr
+	}
+	return summary, ctx.Err()
+}
+
+// errSameDir 输出目录与输入目录相同，输出会覆盖输入
+var errSameDir = errors.New("输出目录不能与输入目录相同")
+
+// resolveDirs 返回输入、输出目录的绝对路径，两者相同时返回 errSameDir
+func resolveDirs(inputDir, outputDir string) (string, string, error) {
+	inputDir, err := filepath.Abs(inputDir)
+	if err != nil {
+		return "", "", err
+	}
+	outputDir, err = filepath.Abs(outputDir)
+	if err != nil {
+		return "", "", err
+	}
+	if inputDir == outputDir {
+		return "", "", errSameDir
+	}
+	return inputDir, outputDir, nil
+}
+
+// insideDir 判断 path 是否在目录 dir 之内，两者都是绝对路径
+func insideDir(path, dir string) bool {
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// manifestEntry 生成文件的当前记录，输入大小和修改时间未变时沿用清单中的哈希，避免重复读取大文件
+func manifestEntry(manifest *util.Manifest, relPath, inputPath, settings string) (util.ManifestEntry, error) {
+	info, err := os.Stat(inputPath)
+	if err != nil {
+		return util.ManifestEntry{}, err
+	}
+
+	entry, ok := manifest.Get(relPath)
+	if !ok || entry.InputSize != info.Size() || !entry.InputModTime.Equal(info.ModTime()) || entry.InputHash == "" {
+		hash, err := util.HashFile(inputPath)
+		if err != nil {
+			return util.ManifestEntry{}, fmt.Errorf("无法计算文件哈希: %v", err)
+		}
+		if ok && entry.InputHash != hash {
+			// 输入已变化，之前的状态作废
+			entry.State = ""
+		}
+		entry.InputHash, entry.InputSize, entry.InputModTime = hash, info.Size(), info.ModTime()
+	}
+
+	if entry.Settings != settings {
+		// 处理参数已变化，之前的状态作废
+		entry.State = ""
+	}
+	entry.Path, entry.Settings = relPath, settings
+
+	return entry, nil
+}
+
+// shouldSkip 根据清单状态判断是否跳过，输入或参数变化后 entry.State 为空，需要重新处理
+func shouldSkip(entry util.ManifestEntry, outputPath string, retryFailed bool) bool {
+	switch entry.State {
+	case util.StateDone:
+		// 输出被删除时需要重新生成
+		_, err := os.Stat(outputPath)
+		return err == nil
+	case util.StateFailed:
+		return !retryFailed
+	}
+
+	// 上次中断时未完成（pending），或没有有效记录。skipped 记录的参数不是生成输出时的参数，同样重新处理
+	return false
+}
+
+// Print 输出每个文件的压缩率、失败原因和总耗时
+func (s *DirBatchSummary) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "文件\t原大小\t压缩后\t节省\t耗时\t")
+	for _, f := range s.Files {
+		switch {
+		case f.Skipped:
+			fmt.Fprintf(tw, "%s\t-\t-\t跳过\t-\t\n", f.RelPath)
+		case f.Err != nil:
+			fmt.Fprintf(tw, "%s\t%.fKB\t-\t失败\t%s\t\n", f.RelPath, float64(f.InputSize)/1024, f.Duration.Round(time.Millisecond))
+		default:
+			fmt.Fprintf(tw, "%s\t%.fKB\t%.fKB\t%.2f%%\t%s\t\n", f.RelPath, float64(f.InputSize)/1024, float64(f.OutputSize)/1024,
+				savedPercent(f.InputSize, f.OutputSize), f.Duration.Round(time.Millisecond))
+		}
+	}
+	tw.Flush()
+
+	if s.Failed > 0 {
+		fmt.Fprintln(w, "\n失败文件:")
+		for _, f := range s.Files {
+			if f.Err != nil {
+				fmt.Fprintf(w, "  %s: %v\n", f.RelPath, f.Err)
+			}
+		}
+	}
+
+	if s.Excluded > 0 {
+		fmt.Fprintf(w, "\n输出目录在输入目录之内，其中的 %d 个文件不作为输入\n", s.Excluded)
+	}
+	fmt.Fprintf(w, "\n处理 %d 个，跳过 %d 个，失败 %d 个；%.fKB -> %.fKB，节省 %.2f%%，总耗时 %s\n",
+		s.Processed, s.Skipped, s.Failed, float64(s.InputBytes)/1024, float64(s.OutputBytes)/1024,
+		savedPercent(s.InputBytes, s.OutputBytes), s.Duration.Round(time.Millisecond))
+}
+
+// savedPercent 压缩节省的百分比
+func savedPercent(inputSize, outputSize int64) float64 {
+	if inputSize == 0 {
+		return 0
+	}
+	return (1 - float64(outputSize)/float64(inputSize)) * 100
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunDirBatch(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	inputDir, outputDir := t.TempDir(), t.TempDir()
+	for _, name := range []string{"a.pdf", filepath.Join("sub", "b.pdf")} {
+		assert.Nil(t, os.MkdirAll(filepath.Dir(filepath.Join(inputDir, name)), 0755))
+		assert.Nil(t, os.WriteFile(filepath.Join(inputDir, name), []byte(name), 0644))
+	}
+	manifestPath := filepath.Join(outputDir, ".compress-manifest.jsonl")
+
+	// run 把处理参数写进输出，便于检查输出是用哪次的参数生成的
+	run := func(manifest, settings string) *DirBatchSummary {
+		summary, err := RunDirBatch(context.Background(), processor, DirBatchOptions{
+			InputDir:     inputDir,
+			OutputDir:    outputDir,
+			ManifestPath: manifest,
+			Settings:     settings,
+		}, func(ctx context.Context, instance pdfium.Pdfium, job BatchJob) error {
+			return os.WriteFile(job.Output.Path, []byte(settings), 0644)
+		})
+		assert.Nil(t, err)
+		return summary
+	}
+	output := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(outputDir, name))
+		return string(data)
+	}
+
+	// 不用清单时按修改时间跳过已是最新的输出
+	summary := run("", "quality=80")
+	assert.Equal(t, 2, summary.Processed)
+	summary = run("", "quality=40")
+	assert.Equal(t, 2, summary.Skipped)
+	assert.Equal(t, "quality=80", output("a.pdf"))
+
+	// 清单中没有记录时不知道输出用的参数，改了参数后全部重新处理
+	summary = run(manifestPath, "quality=40")
+	assert.Equal(t, 2, summary.Processed)
+	assert.Zero(t, summary.Skipped)
+	assert.Equal(t, "quality=40", output(filepath.Join("sub", "b.pdf")))
+
+	// 参数不变时跳过，参数变化时重新处理
+	summary = run(manifestPath, "quality=40")
+	assert.Equal(t, 2, summary.Skipped)
+	summary = run(manifestPath, "quality=60")
+	assert.Equal(t, 2, summary.Processed)
+	assert.Equal(t, "quality=60", output("a.pdf"))
+
+	// 输出目录在输入目录之内时，上次的输出不作为输入，计入汇总
+	outputDir = filepath.Join(inputDir, "out")
+	summary = run("", "quality=80")
+	assert.Equal(t, 2, summary.Processed)
+	assert.Zero(t, summary.Excluded)
+	summary = run("", "quality=80")
+	assert.Equal(t, 2, summary.Skipped)
+	assert.Equal(t, 2, summary.Excluded)
+	var printed strings.Builder
+	summary.Print(&printed)
+	assert.Contains(t, printed.String(), "其中的 2 个文件不作为输入")
+
+	// 输出目录与输入目录相同时直接报错
+	_, err = RunDirBatch(context.Background(), processor, DirBatchOptions{InputDir: inputDir, OutputDir: inputDir + string(filepath.Separator)}, nil)
+	assert.ErrorIs(t, err, errSameDir)
+}
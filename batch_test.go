@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchProcessorCancelWait(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	// 占住唯一的实例，process 只能等待
+	held, err := processor.pool.GetInstance(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	res := processor.process(ctx, BatchJob{Name: "a.pdf"}, nil)
+	assert.ErrorIs(t, res.Err, context.DeadlineExceeded)
+	assert.Less(t, res.Duration, 5*time.Second)
+
+	// 归还后池仍然可用
+	assert.Nil(t, held.Close())
+	instance, err := processor.pool.GetInstance(time.Second)
+	if assert.Nil(t, err) {
+		instance.Close()
+	}
+}
+
+func TestWorkerFailed(t *testing.T) {
+	assert.True(t, workerFailed(fmt.Errorf("无法加载页面: %v", rpc.ErrShutdown)))
+	assert.True(t, workerFailed(io.ErrUnexpectedEOF))
+	assert.True(t, workerFailed(fmt.Errorf("无法渲染页面: %v", "panic occurred in FPDF_RenderPageBitmap: out of bounds")))
+	assert.False(t, workerFailed(fmt.Errorf("%w: 文件头损坏", ErrInvalidInput)))
+	assert.False(t, workerFailed(context.Canceled))
+	assert.False(t, workerFailed(&StructureError{}))
+}
@@ -0,0 +1,1054 @@
+package main
+
+import (
+	"compress-pdfium/util"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/klippa-app/go-pdfium"
+)
+
+// 退出码
+const (
+	exitOK          = 0
+	exitFailure     = 1   // 处理失败
+	exitUsage       = 2   // 参数错误
+	exitBadInput    = 3   // 输入无法读取、不是有效的 PDF 或密码错误
+	exitPartial     = 4   // 批量处理中部分文件失败
+	exitRejected    = 5   // 压缩结果未通过结构校验或渲染比对（-reject）
+	exitTimeout     = 124 // 超过 -timeout
+	exitInterrupted = 130 // 被 Ctrl-C 或 SIGTERM 中断
+)
+
+const cliUsage = `用法: compress-pdfium <命令> [参数] <输入>
+
+命令:
+  compress        压缩 PDF 中的图片，输入为目录时批量压缩
+  extract-images  导出 PDF 中的图片到目录或 zip
+  add-logo        在每一页添加图片水印
+  add-text        在每一页添加文字水印
+  stamp           在每一页添加页码、Bates 编号、日期等页眉页脚和 QR 码、条码
+  watermarks      列出或删除本程序添加的水印
+  info            查看页数、页面尺寸和图片统计
+  serve           启动 HTTP 服务，提供压缩、提取图片、添加水印接口
+
+输入为 - 时从 stdin 读取，-o - 时写到 stdout。
+运行 compress-pdfium <命令> -h 查看命令的参数。
+
+退出码:
+  0    成功
+  1    处理失败
+  2    参数错误
+  3    输入无法读取、不是有效的 PDF 或密码错误
+  4    批量处理中部分文件失败
+  5    压缩结果结构不完整或与原文档差异过大，未写出（compress -validate、-reject）
+  124  超时
+  130  被中断
+`
+
+// usageError 参数错误
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, a ...interface{}) error {
+	return usageError{msg: fmt.Sprintf(format, a...)}
+}
+
+// partialError 批量处理中有文件失败，失败原因已输出到汇总中
+type partialError struct {
+	failed int
+}
+
+func (e partialError) Error() string {
+	return fmt.Sprintf("%d 个文件处理失败", e.failed)
+}
+
+// cli 命令行的输入输出，stdout 只用于输出结果，日志和进度写到 stderr
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+var commands = map[string]func(c *cli, ctx context.Context, args []string) error{
+	"compress":       (*cli).compress,
+	"extract-images": (*cli).extractImages,
+	"add-logo":       (*cli).addLogo,
+	"add-text":       (*cli).addText,
+	"stamp":          (*cli).stamp,
+	"watermarks":     (*cli).watermarks,
+	"info":           (*cli).info,
+	"serve":          (*cli).serve,
+}
+
+// runCLI 执行命令并返回退出码
+func runCLI(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, cliUsage)
+		return exitUsage
+	}
+
+	name := args[0]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, cliUsage)
+		return exitOK
+	case "batch":
+		// 兼容旧用法 batch <输入目录> <输出目录>
+		if len(args) == 3 {
+			name, args = "compress", []string{"compress", "-o", args[2], args[1]}
+		}
+	}
+
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "未知命令: %s\n\n%s", name, cliUsage)
+		return exitUsage
+	}
+
+	// Ctrl-C 时取消处理，已打开的 pdfium 句柄会被释放
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	beginTime := time.Now()
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	err := run(c, ctx, args[1:])
+
+	switch {
+	case err == nil:
+		fmt.Fprintf(stderr, "%s 完成，耗时: %dms\n", name, time.Since(beginTime).Milliseconds())
+	case errors.Is(err, flag.ErrHelp):
+	default:
+		fmt.Fprintf(stderr, "%s 失败: %v\n", name, err)
+	}
+
+	return exitCode(err)
+}
+
+// exitCode 根据错误类型返回退出码
+func exitCode(err error) int {
+	var usage usageError
+	var partial partialError
+	var fidelity *FidelityError
+	var structure *StructureError
+
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.As(err, &partial):
+		return exitPartial
+	case errors.As(err, &fidelity), errors.As(err, &structure):
+		return exitRejected
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	case errors.Is(err, ErrInvalidInput):
+		return exitBadInput
+	}
+	return exitFailure
+}
+
+// engineFlags 各命令共用的参数
+type engineFlags struct {
+	backend  string
+	timeout  time.Duration
+	password string
+	progress bool
+}
+
+func (f *engineFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.backend, "backend", os.Getenv("PDFIUM_BACKEND"), "pdfium 实现: single_threaded、multi_threaded、webassembly，默认取环境变量 PDFIUM_BACKEND")
+	fs.DurationVar(&f.timeout, "timeout", 0, "整体超时，如 10m，0 表示不限制")
+	fs.StringVar(&f.password, "password", "", "文档密码")
+	fs.BoolVar(&f.progress, "progress", false, "在 stderr 上显示进度条")
+}
+
+// context 按 -timeout、-progress 包装 ctx
+func (f *engineFlags) context(ctx context.Context, c *cli) (context.Context, context.CancelFunc) {
+	cancel := context.CancelFunc(func() {})
+	if f.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+	}
+	if f.progress {
+		ctx = WithProgress(ctx, ProgressBar(c.stderr))
+	}
+	return ctx, cancel
+}
+
+// open 按 -backend 初始化 pdfium
+func (f *engineFlags) open() (*PdfiumEngine, error) {
+	engine, err := NewPdfium(BackendConfig{
+		Backend: Backend(f.backend),
+		Timeout: time.Second * 30,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法初始化 pdfium: %v", err)
+	}
+	return engine, nil
+}
+
+// flagSet 创建子命令的参数集，解析失败时输出用法
+func (c *cli) flagSet(name, positional string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "用法: compress-pdfium %s [参数] %s\n\n参数:\n", name, positional)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs 解析参数并返回唯一的输入，参数可以写在输入之后
+func parseArgs(fs *flag.FlagSet, args []string) (string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return "", err
+			}
+			return "", usageError{msg: err.Error()}
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(positional) != 1 {
+		fs.Usage()
+		return "", usagef("需要且只能指定一个输入，实际为 %d 个", len(positional))
+	}
+	return positional[0], nil
+}
+
+// input 打开输入，- 表示从 stdin 读取整个文件
+func (c *cli) input(path, password string) (PDFInput, error) {
+	in := PDFInput{Path: path}
+	if path == "-" {
+		data, err := io.ReadAll(c.stdin)
+		if err != nil {
+			return in, fmt.Errorf("%w: 无法读取 stdin: %v", ErrInvalidInput, err)
+		}
+		in = PDFInput{Data: data, Name: "stdin"}
+	} else if _, err := os.Stat(path); err != nil {
+		return in, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+
+	if password != "" {
+		in.Password = &password
+	}
+	return in, nil
+}
+
+// output 打开输出，- 表示写到 stdout
+func (c *cli) output(path string, in PDFInput) (PDFOutput, error) {
+	if path == "-" {
+		return PDFOutput{Writer: c.stdout}, nil
+	}
+
+	// pdfium 按需读取输入文件，保存到同一个文件会损坏文档
+	if in.Path != "" && sameFile(in.Path, path) {
+		return PDFOutput{}, usagef("输出文件不能与输入文件相同: %s", path)
+	}
+	return PDFOutput{Path: path}, nil
+}
+
+// defaultOutput 输出参数为空时的默认值：stdin 输入写到 stdout，否则在输入文件旁加上后缀
+func defaultOutput(output, input, suffix string) string {
+	switch {
+	case output != "":
+		return output
+	case input == "-":
+		return "-"
+	}
+	return strings.TrimSuffix(input, filepath.Ext(input)) + suffix + ".pdf"
+}
+
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+func (c *cli) compress(ctx context.Context, args []string) error {
+	var ef engineFlags
+	var output, manifestPath, debugDir string
+	var quality, workers, jobs int
+	var dpi, aboveDPI float64
+	var force, retryFailed, noManifest bool
+	var validate, verify, inPlace, preserve bool
+	var verifyOpts VerifyOptions
+
+	fs := c.flagSet("compress", "<输入.pdf|输入目录|->")
+	ef.register(fs)
+	fs.StringVar(&output, "o", "", "输出文件，- 表示 stdout；输入为目录时为输出目录（必填）。默认在输入文件旁生成 <名称>-compress.pdf")
+	fs.IntVar(&quality, "quality", 90, "JPEG 压缩质量 1-100")
+	fs.Float64Var(&dpi, "dpi", DPIRecommend, "降低分辨率后的目标 DPI，0 表示不降低分辨率")
+	fs.Float64Var(&aboveDPI, "above-dpi", 0, "水平 DPI 高于该值的图片才降低分辨率，默认与 -dpi 相同")
+	fs.IntVar(&workers, "workers", 0, "每个文档中图片编码的并发数，默认取 CPU 核数")
+	fs.StringVar(&debugDir, "debug-dir", "", "把重新编码后的图片另存一份到该目录，便于排查")
+	fs.IntVar(&jobs, "jobs", 0, "目录模式下同时处理的文档数，默认取 CPU 核数")
+	fs.StringVar(&manifestPath, "manifest", "", "目录模式下的清单文件，默认为 <输出目录>/.compress-manifest.jsonl")
+	fs.BoolVar(&noManifest, "no-manifest", false, "目录模式下不使用清单，只按文件修改时间跳过")
+	fs.BoolVar(&force, "force", false, "目录模式下忽略已是最新的输出，全部重新处理")
+	fs.BoolVar(&retryFailed, "retry-failed", false, "目录模式下重新处理上次失败的文件")
+	fs.BoolVar(&inPlace, "in-place", false, "原地压缩：结果通过结构校验后才替换输入文件，并保留原文件的权限和修改时间")
+	fs.BoolVar(&preserve, "preserve", false, "输出文件沿用输入文件的权限和修改时间")
+	fs.BoolVar(&validate, "validate", true, "保存后重新加载，检查页面、文字、链接、表单字段和书签是否完整，不完整时不写出结果，退出码为 5")
+	fs.BoolVar(&verify, "verify", false, "压缩后逐页渲染，与原文档比对 PSNR 和 SSIM")
+	fs.Float64Var(&verifyOpts.DPI, "verify-dpi", 72, "比对时的渲染分辨率")
+	fs.Float64Var(&verifyOpts.MinPSNR, "min-psnr", 30, "PSNR 低于该值（dB）的页面不合格")
+	fs.Float64Var(&verifyOpts.MinSSIM, "min-ssim", 0.9, "SSIM 低于该值的页面不合格")
+	fs.StringVar(&verifyOpts.DiffDir, "diff-dir", "", "把不合格页面的差异图写入该目录")
+	fs.BoolVar(&verifyOpts.Reject, "reject", false, "有页面不合格时不写出结果，退出码为 5（隐含 -verify）")
+
+	inputPath, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	opts, err := NewCompressOptions(quality, dpi, aboveDPI)
+	if err != nil {
+		return usagef("-%v", err)
+	}
+	opts.DebugDir, opts.Workers, opts.Validate = debugDir, workers, validate
+	verify = verify || verifyOpts.Reject || verifyOpts.DiffDir != ""
+
+	job := CompressJob(opts)
+	if verify {
+		job = func(ctx context.Context, instance pdfium.Pdfium, job BatchJob) error {
+			_, err := CompressAndVerify(ctx, instance, job.Input, job.Output, opts, verifyOpts)
+			return err
+		}
+	}
+
+	if info, err := os.Stat(inputPath); err == nil && info.IsDir() {
+		if output == "" || output == "-" {
+			return usagef("输入为目录时必须用 -o 指定输出目录")
+		}
+		if _, _, err := resolveDirs(inputPath, output); err != nil {
+			return usagef("%v", err)
+		}
+		if !noManifest && manifestPath == "" {
+			// 清单记录在输出目录中，中断后重新运行会从上次的位置继续
+			manifestPath = filepath.Join(output, ".compress-manifest.jsonl")
+		}
+		if noManifest {
+			manifestPath = ""
+		}
+
+		if ef.password != "" {
+			return usagef("输入为目录时不支持 -password")
+		}
+		if inPlace {
+			return usagef("输入为目录时不支持 -in-place")
+		}
+
+		// 多个文档并行处理，进度条会互相覆盖，只输出最后的汇总
+		ef.progress = false
+		ctx, cancel := ef.context(ctx, c)
+		defer cancel()
+
+		// 为空时使用 multi_threaded，每个文档一个子进程
+		processor, err := NewBatchProcessor(BatchConfig{Backend: Backend(ef.backend), Workers: jobs})
+		if err != nil {
+			return fmt.Errorf("无法初始化批处理: %v", err)
+		}
+		defer processor.Close()
+
+		summary, err := RunDirBatch(ctx, processor, DirBatchOptions{
+			InputDir:     inputPath,
+			OutputDir:    output,
+			Force:        force,
+			ManifestPath: manifestPath,
+			Settings:     opts.Fingerprint(),
+			RetryFailed:  retryFailed,
+			Preserve:     preserve,
+		}, job)
+		if summary != nil {
+			summary.Print(c.stdout)
+		}
+		if err != nil {
+			return err
+		}
+		if summary.Failed > 0 {
+			return partialError{failed: summary.Failed}
+		}
+		return nil
+	}
+
+	in, err := c.input(inputPath, ef.password)
+	if err != nil {
+		return err
+	}
+
+	var out PDFOutput
+	if inPlace {
+		if output != "" || inputPath == "-" {
+			return usagef("-in-place 不能与 -o 或 stdin 输入同时使用")
+		}
+		if !validate {
+			return usagef("-in-place 必须校验保存结果，不能与 -validate=false 同时使用")
+		}
+		if in, out, err = inPlaceIO(inputPath, in.Password); err != nil {
+			return err
+		}
+	} else {
+		if out, err = c.output(defaultOutput(output, inputPath, "-compress"), in); err != nil {
+			return err
+		}
+		if preserve && out.Path != "" && in.Path != "" {
+			out.PreserveFrom = in.Path
+		}
+	}
+
+	engine, err := ef.open()
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	ctx, cancel := ef.context(ctx, c)
+	defer cancel()
+
+	if verify {
+		report, err := CompressAndVerify(ctx, engine, in, out, opts, verifyOpts)
+		if report != nil {
+			report.Print(c.stderr)
+		}
+		if err != nil {
+			return err
+		}
+	} else if err = CompressImages(ctx, engine, in, out, opts); err != nil {
+		return err
+	}
+
+	if inputPath != "-" && out.Path != "" {
+		fmt.Fprintf(c.stderr, "已写入 %s\n", out.Path)
+	}
+	return nil
+}
+
+func (c *cli) extractImages(ctx context.Context, args []string) error {
+	var ef engineFlags
+	var output string
+
+	fs := c.flagSet("extract-images", "<输入.pdf|->")
+	ef.register(fs)
+	fs.StringVar(&output, "o", "./images-files", "输出目录；以 .zip 结尾时打包为 zip，- 表示把 zip 写到 stdout")
+
+	inputPath, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	in, err := c.input(inputPath, ef.password)
+	if err != nil {
+		return err
+	}
+
+	engine, err := ef.open()
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	ctx, cancel := ef.context(ctx, c)
+	defer cancel()
+
+	switch {
+	case output == "-":
+		return ExtractImagesToZip(ctx, engine, in, c.stdout)
+
+	case strings.EqualFold(filepath.Ext(output), ".zip"):
+		// 先写临时文件，不留下不完整的 zip
+		return util.AtomicWriteFile(output, util.AtomicWriteOptions{}, func(w io.Writer) error {
+			return ExtractImagesToZip(ctx, engine, in, w)
+		})
+	}
+
+	return ExtractImagesTo(ctx, engine, in, DirImageSink(output))
+}
+
+// watermarkFlags 图片水印和文字水印共用的位置、大小和外观参数
+type watermarkFlags struct {
+	opts       WatermarkOptions
+	anchor     string
+	zOrder     string
+	candidates string
+	contrast   string
+}
+
+func (f *watermarkFlags) register(fs *flag.FlagSet, defaults WatermarkOptions) {
+	f.opts = defaults
+	fs.StringVar(&f.anchor, "anchor", string(defaults.Anchor), "位置：top-left、top、top-right、left、center、right、bottom-left、bottom、bottom-right")
+	fs.Var(&f.opts.MarginX, "margin-x", "到左/右边缘的距离，点数（如 36）或页面宽度的百分比（如 5%）")
+	fs.Var(&f.opts.MarginY, "margin-y", "到上/下边缘的距离，点数或页面高度的百分比")
+	fs.Float64Var(&f.opts.Size, "size", defaults.Size, "水印宽度占页面短边的比例，0-1")
+	fs.Float64Var(&f.opts.Rotation, "rotate", defaults.Rotation, "绕水印中心逆时针旋转的角度")
+	fs.Float64Var(&f.opts.Opacity, "opacity", defaults.Opacity, "不透明度，0-1")
+	fs.StringVar(&f.zOrder, "z", string(defaults.ZOrder), "front 放在内容上方，back 放在内容下方（页面由多个内容流组成时会报错）")
+	fs.BoolVar(&f.opts.Tile, "tile", defaults.Tile, "在整页上重复平铺，忽略 -anchor 和边距，网格随 -rotate 旋转")
+	fs.Var(&f.opts.SpacingX, "spacing-x", "平铺时同一行相邻水印的间距，点数或页面宽度的百分比")
+	fs.Var(&f.opts.SpacingY, "spacing-y", "平铺时相邻两行的间距，点数或页面高度的百分比")
+	fs.BoolVar(&f.opts.Stagger, "stagger", defaults.Stagger, "平铺时隔行错开半格，形成斜向网格")
+	fs.BoolVar(&f.opts.Auto, "auto", defaults.Auto, "每一页在候选位置中选择最空的地方，避免盖住页码、签名等内容，位置写到 stderr")
+	fs.StringVar(&f.candidates, "candidates", "", "-auto 的候选位置，逗号分隔，靠前的优先，默认依次为 -anchor、右下、左下、右上、左上")
+	fs.StringVar(&f.opts.ID, "id", defaults.ID, "水印标识，替换同一标识的旧水印，不同标识的水印可以叠加（默认图片水印 logo、文字水印 text）")
+	fs.StringVar(&f.contrast, "contrast", string(defaults.Contrast), "水印与下方背景对比度不足时：none 不处理，variant 换用明暗相反的变体，plate 垫底板，outline 加描边")
+	fs.Float64Var(&f.opts.MinContrast, "min-contrast", defaults.MinContrast, "-contrast 要求的最低对比度，1-21，按 WCAG 计算")
+	fs.Var(&f.opts.ContrastColor, "contrast-color", "底板、描边的颜色，默认取白色或黑色中与水印对比度高的")
+	fs.Var(&f.opts.PlatePadding, "plate-padding", "底板四周超出水印的距离，点数或水印宽度的百分比")
+	fs.Float64Var(&f.opts.OutlineWidth, "outline-width", defaults.OutlineWidth, "描边宽度（点）")
+}
+
+// parse 解析 -anchor、-z、-contrast 并检查参数范围
+func (f *watermarkFlags) parse() (WatermarkOptions, error) {
+	var err error
+	if f.opts.Anchor, err = ParseAnchor(f.anchor); err != nil {
+		return f.opts, usagef("-anchor: %v", err)
+	}
+	f.opts.ZOrder = ZOrder(f.zOrder)
+	f.opts.Contrast = ContrastMode(f.contrast)
+	if f.opts.Candidates, err = parseAnchors(f.candidates); err != nil {
+		return f.opts, usagef("-candidates: %v", err)
+	}
+	if err = f.opts.Validate(); err != nil {
+		return f.opts, usagef("%v", err)
+	}
+	return f.opts, nil
+}
+
+func (c *cli) addLogo(ctx context.Context, args []string) error {
+	var ef engineFlags
+	var wf watermarkFlags
+	var output, logoPath string
+
+	fs := c.flagSet("add-logo", "-logo <图片> <输入.pdf|->")
+	ef.register(fs)
+	wf.register(fs, DefaultWatermarkOptions())
+	fs.StringVar(&output, "o", "", "输出文件，- 表示 stdout。默认在输入文件旁生成 <名称>-logo.pdf")
+	fs.StringVar(&logoPath, "logo", "", "水印图片，PNG、JPEG 或 GIF（必填）。不透明的 JPEG 原样嵌入，按 EXIF 方向摆正")
+	fs.StringVar(&wf.opts.VariantLogo, "variant-logo", "", "-contrast variant 时换用的图片，宽高比须与 -logo 相同，默认把 -logo 的亮度取反")
+
+	inputPath, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if logoPath == "" {
+		return usagef("必须用 -logo 指定水印图片")
+	}
+	opts, err := wf.parse()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(logoPath); err != nil {
+		return usagef("无法读取水印图片: %v", err)
+	}
+	if opts.VariantLogo != "" {
+		if _, err := os.Stat(opts.VariantLogo); err != nil {
+			return usagef("无法读取变体图片: %v", err)
+		}
+	}
+
+	in, err := c.input(inputPath, ef.password)
+	if err != nil {
+		return err
+	}
+	out, err := c.output(defaultOutput(output, inputPath, "-logo"), in)
+	if err != nil {
+		return err
+	}
+
+	engine, err := ef.open()
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	ctx, cancel := ef.context(ctx, c)
+	defer cancel()
+
+	result, err := Watermark(ctx, engine, logoPath, in, out, opts)
+	if err != nil {
+		return err
+	}
+	c.printResult(opts, result)
+	return nil
+}
+
+// printResult 把替换的旧水印数量写到 stderr，自动选择位置或调整对比度时还有每一页水印的位置
+func (c *cli) printResult(opts WatermarkOptions, result *WatermarkResult) {
+	if result.Replaced > 0 {
+		fmt.Fprintf(c.stderr, "替换 %d 个旧水印对象\n", result.Replaced)
+	}
+	if !opts.Auto && opts.Contrast == ContrastNone {
+		return
+	}
+	for _, p := range result.Placements {
+		fmt.Fprintln(c.stderr, p)
+	}
+}
+
+func (c *cli) addText(ctx context.Context, args []string) error {
+	var ef engineFlags
+	var wf watermarkFlags
+	var output string
+	text := DefaultTextOptions()
+
+	// 文字水印默认斜放在页面中央
+	defaults := DefaultWatermarkOptions()
+	defaults.Anchor, defaults.Size, defaults.Rotation, defaults.Opacity = AnchorCenter, 0.6, 45, 0.3
+
+	fs := c.flagSet("add-text", "-text <文字> <输入.pdf|->")
+	ef.register(fs)
+	wf.register(fs, defaults)
+	fs.StringVar(&output, "o", "", "输出文件，- 表示 stdout。默认在输入文件旁生成 <名称>-text.pdf")
+	fs.StringVar(&text.Text, "text", "", "水印文字，\\n 换行（必填）")
+	fs.StringVar(&text.Font, "font", text.Font, "标准 14 字体名称（如 Helvetica-Bold、Times-Roman）或 TTF 字体文件，中文需要 TTF 字体")
+	fs.Float64Var(&text.FontSize, "font-size", 0, "字号（点），设置后忽略 -size")
+	fs.Var(&text.Color, "color", "填充颜色，如 #ff0000、#ff000080、gray，none 表示只描边（默认 gray）")
+	fs.Var(&text.StrokeColor, "stroke-color", "描边颜色，默认不描边")
+	fs.Float64Var(&text.StrokeWidth, "stroke-width", text.StrokeWidth, "描边宽度（点）")
+	fs.Float64Var(&text.LineSpacing, "line-spacing", text.LineSpacing, "行距，字号的倍数")
+
+	inputPath, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if text.Text == "" {
+		return usagef("必须用 -text 指定水印文字")
+	}
+	text.Text = strings.ReplaceAll(text.Text, `\n`, "\n")
+	if err = text.Validate(); err != nil {
+		return usagef("%v", err)
+	}
+	opts, err := wf.parse()
+	if err != nil {
+		return err
+	}
+	if !IsStandardFont(text.Font) {
+		if _, err := os.Stat(text.Font); err != nil {
+			return usagef("-font 不是标准字体，也无法读取字体文件: %v", err)
+		}
+	}
+
+	in, err := c.input(inputPath, ef.password)
+	if err != nil {
+		return err
+	}
+	out, err := c.output(defaultOutput(output, inputPath, "-text"), in)
+	if err != nil {
+		return err
+	}
+
+	engine, err := ef.open()
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	ctx, cancel := ef.context(ctx, c)
+	defer cancel()
+
+	result, err := WatermarkText(ctx, engine, in, out, text, opts)
+	if err != nil {
+		return err
+	}
+	c.printResult(opts, result)
+	return nil
+}
+
+// stampFlags 可重复的 -stamp、-qr、-code128 参数
+type stampFlags []string
+
+func (f *stampFlags) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stampFlags) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// parseStampFlag 拆分 位置=模板
+func parseStampFlag(name, s string) (Anchor, string, error) {
+	anchor, template, ok := strings.Cut(s, "=")
+	if !ok {
+		return "", "", usagef("-%s 的格式为 位置=模板: %s", name, s)
+	}
+	a, err := ParseAnchor(anchor)
+	if err != nil {
+		return "", "", usagef("-%s: %v", name, err)
+	}
+	return a, template, nil
+}
+
+func (c *cli) stamp(ctx context.Context, args []string) error {
+	var ef engineFlags
+	var stamps, qrs, code128s stampFlags
+	var output, date, qrLevel string
+	var barcodeSize float64
+	opts := StampOptions{}
+	style := DefaultStampSpec("")
+
+	fs := c.flagSet("stamp", "-stamp|-qr|-code128 <位置=模板> <输入.pdf|输入目录|->")
+	ef.register(fs)
+	fs.StringVar(&output, "o", "", "输出文件，- 表示 stdout；输入为目录时为输出目录（必填）。默认在输入文件旁生成 <名称>-stamp.pdf")
+	fs.Var(&stamps, "stamp", "印章，格式为 位置=模板，如 'bottom=第 {page} 页 共 {pages} 页'、'bottom-right=ACME-{bates:6}'，可重复。"+
+		"占位符: {page} {pages} {bates:位数} {date:2006-01-02} {filename}")
+	fs.Var(&qrs, "qr", "QR 码，格式与 -stamp 相同，如 'bottom-left=DOC-42/{page}'，可重复")
+	fs.Var(&code128s, "code128", "Code128 条码，格式与 -stamp 相同，只支持 ASCII 字符，可重复")
+	fs.Float64Var(&barcodeSize, "barcode-size", 0, "条码宽度占页面短边的比例，默认 QR 码 0.1、Code128 0.3")
+	fs.StringVar(&qrLevel, "qr-level", "M", "QR 码纠错等级 L、M、Q、H")
+	fs.IntVar(&opts.BatesStart, "bates-start", 1, "第一页的 Bates 编号，输入为目录时在文件之间连续编号")
+	fs.StringVar(&date, "date", "", "{date} 使用的日期，格式 2006-01-02，默认为今天")
+	fs.StringVar(&style.Font, "font", style.Font, "标准 14 字体名称或 TTF 字体文件，中文需要 TTF 字体")
+	fs.Float64Var(&style.FontSize, "font-size", style.FontSize, "字号（点）")
+	fs.Var(&style.Color, "color", "文字颜色，如 #000000、red")
+	fs.Var(&style.MarginX, "margin-x", "到左/右边缘的距离，点数或页面宽度的百分比")
+	fs.Var(&style.MarginY, "margin-y", "到上/下边缘的距离，点数或页面高度的百分比")
+	fs.Float64Var(&style.Opacity, "opacity", style.Opacity, "不透明度，0-1")
+	fs.StringVar(&opts.ID, "id", WatermarkIDStamp, "水印标识，替换同一标识的旧印章")
+
+	inputPath, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(stamps)+len(qrs)+len(code128s) == 0 {
+		return usagef("必须用 -stamp、-qr 或 -code128 指定至少一个印章")
+	}
+	for _, s := range stamps {
+		spec := style
+		if spec.Anchor, spec.Text, err = parseStampFlag("stamp", s); err != nil {
+			return err
+		}
+		opts.Stamps = append(opts.Stamps, spec)
+	}
+	for _, f := range []struct {
+		name  string
+		typ   BarcodeType
+		flags stampFlags
+	}{{"qr", BarcodeQR, qrs}, {"code128", BarcodeCode128, code128s}} {
+		for _, s := range f.flags {
+			anchor, template, err := parseStampFlag(f.name, s)
+			if err != nil {
+				return err
+			}
+			spec := DefaultBarcodeSpec(f.typ, template)
+			spec.Anchor, spec.MarginX, spec.MarginY, spec.Level = anchor, style.MarginX, style.MarginY, qrLevel
+			if barcodeSize > 0 {
+				spec.Size = barcodeSize
+			}
+			opts.Barcodes = append(opts.Barcodes, spec)
+		}
+	}
+	if date != "" {
+		if opts.Date, err = time.ParseInLocation("2006-01-02", date, time.Local); err != nil {
+			return usagef("-date 的格式应为 2006-01-02: %s", date)
+		}
+	}
+	if _, err = opts.Validate(); err != nil {
+		return usagef("%v", err)
+	}
+	if !IsStandardFont(style.Font) {
+		if _, err := os.Stat(style.Font); err != nil {
+			return usagef("-font 不是标准字体，也无法读取字体文件: %v", err)
+		}
+	}
+
+	engine, err := ef.open()
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	ctx, cancel := ef.context(ctx, c)
+	defer cancel()
+
+	if info, err := os.Stat(inputPath); err == nil && info.IsDir() {
+		if output == "" || output == "-" {
+			return usagef("输入为目录时必须用 -o 指定输出目录")
+		}
+		if _, _, err := resolveDirs(inputPath, output); err != nil {
+			return usagef("%v", err)
+		}
+		if ef.password != "" {
+			return usagef("输入为目录时不支持 -password")
+		}
+		// 按文件名顺序逐个处理，Bates 编号才能连续
+		ranges, next, err := StampDir(ctx, engine, inputPath, output, opts)
+		for _, r := range ranges {
+			fmt.Fprintln(c.stderr, r)
+		}
+		fmt.Fprintf(c.stderr, "下一个 Bates 编号: %d\n", next)
+		return err
+	}
+
+	in, err := c.input(inputPath, ef.password)
+	if err != nil {
+		return err
+	}
+	out, err := c.output(defaultOutput(output, inputPath, "-stamp"), in)
+	if err != nil {
+		return err
+	}
+
+	pages, err := AddStamps(ctx, engine, in, out, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "下一个 Bates 编号: %d\n", opts.BatesStart+pages)
+	return nil
+}
+
+func (c *cli) watermarks(ctx context.Context, args []string) error {
+	var ef engineFlags
+	var output, remove string
+	var asJSON bool
+
+	fs := c.flagSet("watermarks", "<输入.pdf|->")
+	ef.register(fs)
+	fs.StringVar(&remove, "remove", "", "删除指定标识的水印，多个用逗号分隔，all 表示全部。不指定时只列出水印")
+	fs.StringVar(&output, "o", "", "删除水印后的输出文件，- 表示 stdout。默认在输入文件旁生成 <名称>-clean.pdf")
+	fs.BoolVar(&asJSON, "json", false, "以 JSON 输出水印列表")
+
+	inputPath, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	in, err := c.input(inputPath, ef.password)
+	if err != nil {
+		return err
+	}
+
+	engine, err := ef.open()
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	ctx, cancel := ef.context(ctx, c)
+	defer cancel()
+
+	if remove == "" {
+		found, err := FindWatermarks(ctx, engine, in)
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			enc := json.NewEncoder(c.stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(found)
+		}
+		if len(found) == 0 {
+			fmt.Fprintln(c.stdout, "没有本程序添加的水印")
+		}
+		for _, w := range found {
+			fmt.Fprintf(c.stdout, "%s\t%d 页\t%d 个对象\t页码 %s\n", w.ID, len(w.Pages), w.Objects, formatPages(w.Pages))
+		}
+		return nil
+	}
+
+	var ids []string
+	if remove != "all" {
+		for _, id := range strings.Split(remove, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			return usagef("-remove 没有指定水印标识")
+		}
+	}
+	out, err := c.output(defaultOutput(output, inputPath, "-clean"), in)
+	if err != nil {
+		return err
+	}
+	removed, err := RemoveWatermarks(ctx, engine, in, out, ids)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "删除 %d 个水印对象\n", removed)
+	return nil
+}
+
+// formatPages 把页码列表合并为区间，如 1-3,5
+func formatPages(pages []int) string {
+	var parts []string
+	for i := 0; i < len(pages); {
+		j := i
+		for j+1 < len(pages) && pages[j+1] == pages[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(pages[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", pages[i], pages[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+func (c *cli) info(ctx context.Context, args []string) error {
+	var ef engineFlags
+	var asJSON bool
+
+	fs := c.flagSet("info", "<输入.pdf|->")
+	ef.register(fs)
+	fs.BoolVar(&asJSON, "json", false, "以 JSON 输出")
+
+	inputPath, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	in, err := c.input(inputPath, ef.password)
+	if err != nil {
+		return err
+	}
+
+	engine, err := ef.open()
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+
+	ctx, cancel := ef.context(ctx, c)
+	defer cancel()
+
+	info, err := GetPDFInfo(ctx, engine, in)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	info.Print(c.stdout)
+	return nil
+}
+
+func (c *cli) serve(ctx context.Context, args []string) error {
+	var addr, backend, logoPath, fontDir, spoolDir string
+	var jobs, jobConcurrency int
+	var maxSizeMB int64
+	var timeout, jobTimeout, jobRetention time.Duration
+
+	fs := c.flagSet("serve", "")
+	fs.StringVar(&addr, "addr", ":8080", "监听地址")
+	fs.StringVar(&backend, "backend", os.Getenv("PDFIUM_BACKEND"), "pdfium 实现: single_threaded、multi_threaded、webassembly，默认 multi_threaded")
+	fs.IntVar(&jobs, "jobs", 0, "同时处理的请求数，也是池中的实例数，默认取 CPU 核数")
+	fs.Int64Var(&maxSizeMB, "max-size", 100, "请求体的最大大小，单位 MB")
+	fs.DurationVar(&timeout, "timeout", time.Minute*2, "单个请求的处理超时")
+	fs.StringVar(&logoPath, "logo", "", "默认水印图片，/watermark 请求中没有上传 logo 时使用")
+	fs.StringVar(&fontDir, "font-dir", "", "文字水印可用的 TTF 字体目录，请求中按文件名引用")
+	fs.StringVar(&spoolDir, "spool", "", "异步任务的 spool 目录，为空时不提供 /jobs/ 接口")
+	fs.IntVar(&jobConcurrency, "job-concurrency", 1, "同时处理的异步任务数")
+	fs.DurationVar(&jobTimeout, "job-timeout", time.Minute*30, "单个异步任务的处理超时")
+	fs.DurationVar(&jobRetention, "job-retention", time.Hour*24, "完成或失败的异步任务保留多久")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{msg: err.Error()}
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return usagef("serve 不接受输入参数: %s", strings.Join(fs.Args(), " "))
+	}
+	if maxSizeMB <= 0 {
+		return usagef("-max-size 必须大于 0: %d", maxSizeMB)
+	}
+
+	processor, err := NewBatchProcessor(BatchConfig{Backend: Backend(backend), Workers: jobs})
+	if err != nil {
+		return fmt.Errorf("无法初始化 pdfium 池: %v", err)
+	}
+	defer processor.Close()
+
+	s := NewServer(processor, ServerConfig{
+		MaxUploadSize:  maxSizeMB << 20,
+		RequestTimeout: timeout,
+		LogoPath:       logoPath,
+		FontDir:        fontDir,
+	})
+	handler := s.Handler()
+
+	if spoolDir != "" {
+		queue, err := NewJobQueue(s, JobQueueConfig{
+			SpoolDir:    spoolDir,
+			Concurrency: jobConcurrency,
+			Timeout:     jobTimeout,
+			Retention:   jobRetention,
+		})
+		if err != nil {
+			return err
+		}
+		// 在 processor 之前关闭，处理中的任务被中断后保持排队状态
+		defer queue.Close()
+
+		mux := http.NewServeMux()
+		mux.Handle("/", handler)
+		mux.Handle("/jobs/", queue.Handler())
+		handler = mux
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+	fmt.Fprintf(c.stderr, "监听 %s\n", addr)
+
+	select {
+	case err = <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	// 收到 Ctrl-C 或 SIGTERM 后不再接收新请求，等待处理中的请求完成
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	return nil
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
-	"compress-pdf/util"
+	"bytes"
+	"compress-pdfium/util"
+	"context"
 	"fmt"
-	"image"
-	"image/png"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 
 	"github.com/klippa-app/go-pdfium"
@@ -31,318 +34,443 @@ const (
 	DPIRecommend = 120 // 水平 DPI 推荐值
 )
 
-func CompressImagesInPlace(instance pdfium.Pdfium, inputPath string, quality int, setDPI, highThanDPI float32) error {
+// CompressOptions 压缩参数
+type CompressOptions struct {
+	Quality     int     // JPEG 压缩质量
+	SetDPI      float32 // 降低分辨率后的目标 DPI
+	HighThanDPI float32 // 水平 DPI 高于该值的图片才降低分辨率
 
-	if strings.Contains(inputPath, "compress") {
-		return nil
+	// DebugDir 非空时把重新编码后的图片另存一份到该目录，便于排查，默认不落盘
+	DebugDir string
+
+	// Workers 图片解码、降低分辨率、编码的并发数，<=0 时取 CPU 核数
+	Workers int
+
+	// Validate 为 true 时先保存到内存，重新加载后与原文档比较页面、文字、链接、表单字段和书签，
+	// 不一致时不写出结果，返回 *StructureError
+	Validate bool
+}
+
+// Fingerprint 返回影响输出结果的参数指纹，用于判断批处理时是否需要重新压缩
+func (o CompressOptions) Fingerprint() string {
+	return fmt.Sprintf("compress:quality=%d,dpi=%.2f,above=%.2f", o.Quality, o.SetDPI, o.HighThanDPI)
+}
+
+// NewCompressOptions 按命令行和 HTTP 接口的参数生成压缩选项
+// dpi<=0 时不降低分辨率，aboveDPI<=0 时与 dpi 相同
+func NewCompressOptions(quality int, dpi, aboveDPI float64) (CompressOptions, error) {
+	if quality < 1 || quality > 100 {
+		return CompressOptions{}, fmt.Errorf("quality 必须在 1-100 之间: %d", quality)
+	}
+	if aboveDPI <= 0 {
+		aboveDPI = dpi
+	}
+	if dpi <= 0 {
+		aboveDPI = math.MaxFloat32
 	}
+	return CompressOptions{
+		Quality:     quality,
+		SetDPI:      float32(dpi),
+		HighThanDPI: float32(aboveDPI),
+	}, nil
+}
 
-	// 图像信息
-	var stat = make(map[string]int)
+// CompressImagesInPlace 原地压缩 inputPath：压缩结果通过结构校验后才原子地替换原文件，
+// 并沿用原文件的权限和修改时间，任何一步失败原文件都保持不变
+func CompressImagesInPlace(ctx context.Context, instance pdfium.Pdfium, inputPath string, opts CompressOptions) error {
+	in, out, err := inPlaceIO(inputPath, nil)
+	if err != nil {
+		return err
+	}
 
-	// 因为是原地更新，测试阶段，先备份原文件
-	copyFilePath := strings.Replace(inputPath, ".pdf", fmt.Sprintf("-compress-%d-%.0fdpi.pdf", quality, setDPI), 1)
-	if err := util.CopyFile(inputPath, copyFilePath); err != nil {
-		return fmt.Errorf("无法备份原文件: %v", err)
+	opts.Validate = true
+	if err = CompressImages(ctx, instance, in, out, opts); err != nil {
+		return err
 	}
 
-	pdfDoc, err := instance.FPDF_LoadDocument(&requests.FPDF_LoadDocument{
-		Path:     &copyFilePath,
-		Password: nil,
-	})
+	if info, err := os.Stat(inputPath); err == nil {
+		log.Printf("%s 原大小：%.fKB, 压缩后：%.fKB", inputPath, float64(len(in.Data))/1024, float64(info.Size())/1024)
+	}
+	return nil
+}
+
+// inPlaceIO 返回原地压缩 path 时的输入输出：先读入内存，替换文件时 pdfium 不再持有原文件，
+// 输出沿用原文件的权限和修改时间。调用方需开启结构校验
+func inPlaceIO(path string, password *string) (PDFInput, PDFOutput, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("无法加载 PDF 文档=%s: %v", inputPath, err)
+		return PDFInput{}, PDFOutput{}, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	return PDFInput{Data: data, Name: filepath.Base(path), Password: password}, PDFOutput{Path: path, PreserveFrom: path}, nil
+}
+
+// CompressImages 压缩 in 中的图片并写入 out，输入输出均可为文件、内存或流
+//
+// pdfium 调用（提取位图、回写图片、生成页面内容）始终在当前协程中串行执行，
+// 图片的解码、降低分辨率、编码是纯 Go 的 CPU 密集操作，交给协程池并行处理，
+// 结果按页面和对象的原始顺序回写
+func CompressImages(ctx context.Context, instance pdfium.Pdfium, in PDFInput, out PDFOutput, opts CompressOptions) error {
+
+	// 图像信息
+	var stat = make(map[string]int)
+
+	document, err := LoadDocument(instance, in)
+	if err != nil {
+		return fmt.Errorf("无法加载 PDF 文档=%s: %w", in.name(), err)
 	}
 	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
-		Document: pdfDoc.Document,
+		Document: document,
 	})
 
 	// 源文档页面数量
 	pageCountRes, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
-		Document: pdfDoc.Document,
+		Document: document,
 	})
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("pdf page count: %d\n", pageCountRes.PageCount)
+	// 修改前记录原文档结构，保存后用于校验
+	var structure *DocumentStructure
+	if opts.Validate {
+		if structure, err = ReadStructure(ctx, instance, document); err != nil {
+			return fmt.Errorf("无法读取文档结构: %w", err)
+		}
+	}
+
+	progress := ProgressEvent{Pages: pageCountRes.PageCount}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	// 出错返回时先取消编码，wait 不必等排队的任务编码完
+	ctx, cancel := context.WithCancel(ctx)
+	encoder := newImageEncoder(ctx, workers, opts)
+	defer encoder.wait()
+	defer cancel()
+
+	// 已提交编码、尚未回写的页面，页面保持打开直到回写完成
+	var pending []*pageTasks
+	defer func() {
+		// 提前返回（出错或被取消）时释放仍打开的页面
+		for _, p := range pending {
+			instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
+				Page: p.page,
+			})
+		}
+	}()
+
+	// flush 按顺序回写已编码完成的页面，在途页面超过 maxPending 时阻塞等待最早的页面
+	flush := func(maxPending int) error {
+		for len(pending) > 0 && (len(pending) > maxPending || pending[0].ready()) {
+			p := pending[0]
+			if err := applyPageTasks(ctx, instance, p, in, opts, progress.BytesProcessed); err != nil {
+				return err
+			}
+
+			pending = pending[1:]
+			if _, err := instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
+				Page: p.page,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 
 	// 遍历所有页面
 	for i := 0; i < pageCountRes.PageCount; i++ {
-		fmt.Printf("\n\n--------------------加载页面:%d\n", i)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		progress.Page, progress.Image = i+1, 0
 		pdfPage, err := instance.FPDF_LoadPage(&requests.FPDF_LoadPage{
-			Document: pdfDoc.Document,
+			Document: document,
 			Index:    i,
 		})
 		if err != nil {
 			return fmt.Errorf("无法加载页面: %v", err)
 		}
 
-		// 遍历一个页面中的对象
-		objectCountRes, err := instance.FPDFPage_CountObjects(&requests.FPDFPage_CountObjects{
-			Page: requests.Page{
-				ByIndex: &requests.PageByIndex{
-					Document: pdfDoc.Document,
-					Index:    i,
-				},
-			},
-		})
-		if err != nil {
-			return fmt.Errorf("无法获取页面对象数量: %v", err)
-		}
-
-		for j := 0; j < objectCountRes.Count; j++ {
-			objRes, err := instance.FPDFPage_GetObject(&requests.FPDFPage_GetObject{
-				Page: requests.Page{
-					ByIndex: &requests.PageByIndex{
-						Document: pdfDoc.Document,
-						Index:    i,
-					},
-				},
-				Index: j,
-			})
-			if err != nil {
-				return fmt.Errorf("无法获取页面对象: %v", err)
-			}
-
-			objTypeRes, err := instance.FPDFPageObj_GetType(&requests.FPDFPageObj_GetType{
-				PageObject: objRes.PageObject,
-			})
-			if err != nil {
-				return fmt.Errorf("无法获取页面对象类型: %v", err)
-			}
+		page := &pageTasks{index: i, page: pdfPage.Page}
+		pending = append(pending, page)
 
-			// 当前只压缩图像
-			if objTypeRes.Type != enums.FPDF_PAGEOBJ_IMAGE {
-				continue
-			}
+		/*=====================================================step1、提取图片=========================================================*/
+		page.tasks, err = extractImageTasks(ctx, instance, document, page, &progress, stat)
+		if err != nil {
+			return err
+		}
+		for _, task := range page.tasks {
+			encoder.submit(task)
+		}
 
-			fmt.Printf("\n\n\n")
-
-			// 获取图片元信息
-			imageMetadataRes, err := instance.FPDFImageObj_GetImageMetadata(&requests.FPDFImageObj_GetImageMetadata{
-				ImageObject: objRes.PageObject,
-				Page: requests.Page{
-					ByIndex: &requests.PageByIndex{
-						Document: pdfDoc.Document,
-						Index:    i,
-					},
-				},
-			})
-			if err != nil {
-				return fmt.Errorf("无法获取图片元数据: %v", err)
-			}
+		// 限制在途页面数，避免大文档一次性把所有位图读进内存
+		if err = flush(2 * workers); err != nil {
+			return err
+		}
+	}
 
-			// 获取图片编码
-			filters, err := GetImageObjectFilter(instance, objRes.PageObject)
-			if err != nil {
-				return err
-			}
+	if err = flush(0); err != nil {
+		return err
+	}
 
-			// tips:有些pdf中的图片，居然没有filter
-			// if len(filters) == 0 {
-			// 	fmt.Printf("跳过图片: filter:%s %d-%d\n", strings.Join(filters, ","), i, j)
-			// 	continue
-			// }
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 
-			// 获取图片压缩数据
-			dataRawRes, err := instance.FPDFImageObj_GetImageDataRaw(&requests.FPDFImageObj_GetImageDataRaw{
-				ImageObject: objRes.PageObject,
-			})
-			if err != nil {
-				return fmt.Errorf("无法获取图片数据: %v", err)
-			}
+	progress.Phase, progress.Image = PhaseSave, 0
+	reportProgress(ctx, progress)
 
-			fmt.Printf("图片元数据: raw len: %d imageMetadataRes:%+v filter:[%s] \n",
-				len(dataRawRes.Data), imageMetadataRes.ImageMetadata, strings.Join(filters, ","))
+	if structure == nil {
+		if err = SaveDocument(instance, document, out, requests.SaveFlagNoIncremental); err != nil {
+			return fmt.Errorf("无法保存 PDF: %v", err)
+		}
+	} else {
+		var saved bytes.Buffer
+		if err = SaveDocument(instance, document, PDFOutput{Writer: &saved}, requests.SaveFlagNoIncremental); err != nil {
+			return fmt.Errorf("无法保存 PDF: %v", err)
+		}
+		if err = ValidateOutput(ctx, instance, structure, PDFInput{Data: saved.Bytes(), Name: in.name()}); err != nil {
+			return err
+		}
+		if err = WriteOutput(out, saved.Bytes()); err != nil {
+			return fmt.Errorf("无法保存 PDF: %v", err)
+		}
+	}
 
-			stat["total-image"]++
-			stat[strings.Join(filters, ",")]++
-			stat[fmt.Sprintf("color-space-%d", imageMetadataRes.ImageMetadata.Colorspace)]++
+	log.Printf("%s 图片信息: %v", in.name(), stat)
 
-			// 图片过小，跳过
-			if len(dataRawRes.Data) < 1000 {
-				fmt.Printf("图片过小=%d，跳过图片: %d-%d\n", len(dataRawRes.Data), i, j)
-				continue
-			}
+	return nil
+}
 
-			// if len(dataRawRes.Data) < 1000 || // 图片太小，没必要压缩
-			// 	imageMetadataRes.ImageMetadata.BitsPerPixel <= 8 || // bitmap会转为RGB，即BitsPerPixel会变成24
-			// 	imageMetadataRes.ImageMetadata.Colorspace == enums.FPDF_COLORSPACE_DEVICECMYK ||
-			// 	imageMetadataRes.ImageMetadata.HorizontalDPI/float32(bitmapInfo.Width) > 2 { // 若GetRenderedBitmap得到图片的分辨率已经下降了两倍，不进行处理
-			// 	shouldSkipDecode = true
-			// }s
-
-			var isSkip bool
-			var img image.Image
-			var format string
-
-			/*=====================================================step1、提取图片=========================================================*/
-			var filter string
-			if len(filters) > 0 {
-				filter = filters[0]
-			}
+// extractImageTasks 串行读取页面中需要压缩的图片，位图数据复制后立即释放位图
+func extractImageTasks(ctx context.Context, instance pdfium.Pdfium, document references.FPDF_DOCUMENT, page *pageTasks, progress *ProgressEvent, stat map[string]int) ([]*imageTask, error) {
+	pageRef := page.ref()
 
-			switch filter {
-			case DCTDecodeFilter, JBIG2DecodeFilter, "":
-				img, format, err = GetImageFromBitmap(instance, pdfDoc.Document, requests.Page{
-					ByIndex: &requests.PageByIndex{
-						Document: pdfDoc.Document,
-						Index:    i,
-					},
-				}, objRes.PageObject)
-
-			case FlateDecodeFilter:
-				img, format, err = GetImageFromRenderedBitmap(instance, pdfDoc.Document, requests.Page{
-					ByIndex: &requests.PageByIndex{
-						Document: pdfDoc.Document,
-						Index:    i,
-					},
-				}, objRes.PageObject)
-
-				// if float32(imageMetadataRes.ImageMetadata.Width)/float32(bitmapInfo.Width) > 2 {
-				// 	isSkip = true
-				// }
-			// case JBIG2DecodeFilter:
-			// 	isSkip = true
-			case CCITTFaxDecodeFilter:
-				isSkip = true
-			default:
-				isSkip = true
-			}
+	// 遍历一个页面中的对象
+	objectCountRes, err := instance.FPDFPage_CountObjects(&requests.FPDFPage_CountObjects{
+		Page: pageRef,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法获取页面对象数量: %v", err)
+	}
 
-			if err != nil {
-				return fmt.Errorf("无法获取图片: %v", err)
-			}
+	var tasks []*imageTask
+	for j := 0; j < objectCountRes.Count; j++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-			if isSkip {
-				fmt.Printf("跳过图片:filter:%s %d-%d\n", strings.Join(filters, ","), i, j)
-				continue
-			}
-			// 记录处理的图片数
-			stat["dealed-image"]++
+		objRes, err := instance.FPDFPage_GetObject(&requests.FPDFPage_GetObject{
+			Page:  pageRef,
+			Index: j,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("无法获取页面对象: %v", err)
+		}
 
-			inputFileName := strings.Split(inputPath, "/")[len(strings.Split(inputPath, "/"))-1]
-			filename := fmt.Sprintf("./images-files/%s_%d_%d", inputFileName, i, j)
+		objTypeRes, err := instance.FPDFPageObj_GetType(&requests.FPDFPageObj_GetType{
+			PageObject: objRes.PageObject,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("无法获取页面对象类型: %v", err)
+		}
 
-			/*=====================================================step2、降低图片分辨率=========================================================*/
-			if imageMetadataRes.ImageMetadata.HorizontalDPI > highThanDPI {
-				img = util.ReduceDPI(img, int(imageMetadataRes.ImageMetadata.Width), imageMetadataRes.ImageMetadata.HorizontalDPI, setDPI)
-			}
+		// 当前只压缩图像
+		if objTypeRes.Type != enums.FPDF_PAGEOBJ_IMAGE {
+			continue
+		}
 
-			/*=====================================================step3、图片压缩=========================================================*/
-			switch format {
-			case JPEG:
-				filename = filename + "." + JPEG
-				err = util.ConvertToJPEG(img, filename, quality)
-				if err != nil {
-					return fmt.Errorf("无法保存图片: %v", err)
-				}
-
-				data, err := os.ReadFile(filename)
-				if err != nil {
-					return fmt.Errorf("无法读取图片: %v", err)
-				}
-				os.Remove(filename)
-
-				_, err = instance.FPDFImageObj_LoadJpegFileInline(&requests.FPDFImageObj_LoadJpegFileInline{
-					ImageObject: objRes.PageObject,
-					Page: &requests.Page{
-						ByIndex: &requests.PageByIndex{
-							Document: pdfDoc.Document,
-							Index:    i,
-						},
-					},
-					Count: 1,
-					// FilePath: filename,
-					FileData: data,
-				})
-				log.Printf("JPEG 图像已保存到: %s", filename)
-
-			case PNG:
-				filename = filename + "." + PNG
-				f, _ := os.Create(filename)
-				defer f.Close()
-				png.Encode(f, img)
-				log.Printf("PNG 图像已保存到: %s", filename)
-
-				bitmapRes, err := CreateBitmapFromImage(instance, img, 0)
-				if err != nil {
-					return fmt.Errorf("无法创建位图: %v", err)
-				}
-				_, err = instance.FPDFImageObj_SetBitmap(&requests.FPDFImageObj_SetBitmap{
-					ImageObject: objRes.PageObject,
-					Bitmap:      bitmapRes.bitmapRef,
-					Page: &requests.Page{
-						ByIndex: &requests.PageByIndex{
-							Document: pdfDoc.Document,
-							Index:    i,
-						},
-					},
-					Count: 1,
-				})
-			}
+		progress.Image++
 
-			if err != nil {
-				return fmt.Errorf("无法设置图片: %v", err)
-			}
+		// 获取图片元信息
+		imageMetadataRes, err := instance.FPDFImageObj_GetImageMetadata(&requests.FPDFImageObj_GetImageMetadata{
+			ImageObject: objRes.PageObject,
+			Page:        pageRef,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("无法获取图片元数据: %v", err)
 		}
 
-		rectObj, err := instance.FPDFPageObj_CreateNewRect(&requests.FPDFPageObj_CreateNewRect{
-			X: 0,
-			Y: 0,
-			W: 1,
-			H: 1,
-		})
+		// 获取图片编码
+		filters, err := GetImageObjectFilter(instance, objRes.PageObject)
 		if err != nil {
-			return fmt.Errorf("无法创建矩形对象: %v", err)
+			return nil, err
 		}
 
-		_, err = instance.FPDFPage_InsertObject(&requests.FPDFPage_InsertObject{
-			Page: requests.Page{
-				ByIndex: &requests.PageByIndex{
-					Document: pdfDoc.Document,
-					Index:    i,
-				},
-			},
-			PageObject: rectObj.PageObject,
+		// tips:有些pdf中的图片，居然没有filter
+		// if len(filters) == 0 {
+		// 	fmt.Printf("跳过图片: filter:%s %d-%d\n", strings.Join(filters, ","), i, j)
+		// 	continue
+		// }
+
+		// 获取图片压缩数据
+		dataRawRes, err := instance.FPDFImageObj_GetImageDataRaw(&requests.FPDFImageObj_GetImageDataRaw{
+			ImageObject: objRes.PageObject,
 		})
 		if err != nil {
-			return fmt.Errorf("无法插入矩形对象: %v", err)
+			return nil, fmt.Errorf("无法获取图片数据: %v", err)
+		}
+
+		progress.BytesProcessed += int64(len(dataRawRes.Data))
+
+		stat["total-image"]++
+		stat[strings.Join(filters, ",")]++
+		stat[fmt.Sprintf("color-space-%d", imageMetadataRes.ImageMetadata.Colorspace)]++
+
+		// 图片过小，跳过
+		if len(dataRawRes.Data) < 1000 {
+			continue
+		}
+
+		// if len(dataRawRes.Data) < 1000 || // 图片太小，没必要压缩
+		// 	imageMetadataRes.ImageMetadata.BitsPerPixel <= 8 || // bitmap会转为RGB，即BitsPerPixel会变成24
+		// 	imageMetadataRes.ImageMetadata.Colorspace == enums.FPDF_COLORSPACE_DEVICECMYK ||
+		// 	imageMetadataRes.ImageMetadata.HorizontalDPI/float32(bitmapInfo.Width) > 2 { // 若GetRenderedBitmap得到图片的分辨率已经下降了两倍，不进行处理
+		// 	shouldSkipDecode = true
+		// }s
+
+		progress.Phase = PhaseExtract
+		reportProgress(ctx, *progress)
+
+		var isSkip bool
+		var bitmapInfo *BitmapInfo
+		var format string
+
+		var filter string
+		if len(filters) > 0 {
+			filter = filters[0]
+		}
+
+		switch filter {
+		case DCTDecodeFilter, JBIG2DecodeFilter, "":
+			bitmapInfo, format, err = GetBitmapFromImage(instance, document, pageRef, objRes.PageObject)
+
+		case FlateDecodeFilter:
+			bitmapInfo, format, err = GetBitmapFromRenderedImage(instance, document, pageRef, objRes.PageObject)
+
+			// if float32(imageMetadataRes.ImageMetadata.Width)/float32(bitmapInfo.Width) > 2 {
+			// 	isSkip = true
+			// }
+		// case JBIG2DecodeFilter:
+		// 	isSkip = true
+		case CCITTFaxDecodeFilter:
+			isSkip = true
+		default:
+			isSkip = true
 		}
 
-		_, err = instance.FPDFPage_GenerateContent(&requests.FPDFPage_GenerateContent{
-			Page: requests.Page{
-				ByIndex: &requests.PageByIndex{
-					Document: pdfDoc.Document,
-					Index:    i,
-				},
-			},
-		})
 		if err != nil {
-			return fmt.Errorf("无法生成页面内容: %v", err)
+			return nil, fmt.Errorf("无法获取图片: %v", err)
 		}
 
-		_, err = instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
-			Page: pdfPage.Page,
+		if isSkip {
+			continue
+		}
+		// 记录处理的图片数
+		stat["dealed-image"]++
+
+		tasks = append(tasks, &imageTask{
+			index:    j,
+			object:   objRes.PageObject,
+			metadata: imageMetadataRes.ImageMetadata,
+			bitmap:   bitmapInfo,
+			format:   format,
+			progress: *progress,
+			done:     make(chan struct{}),
 		})
+	}
+
+	return tasks, nil
+}
+
+// applyPageTasks 等待页面中的图片编码完成，按顺序回写并重新生成页面内容
+// 后面的页面可能已经提取，进度中的字节数取 bytesProcessed，即当前的累计值，保证上报的字节数不回退
+func applyPageTasks(ctx context.Context, instance pdfium.Pdfium, page *pageTasks, in PDFInput, opts CompressOptions, bytesProcessed int64) error {
+	pageRef := page.ref()
+
+	for _, task := range page.tasks {
+		<-task.done
+		if task.err != nil {
+			return task.err
+		}
+
+		// 编码在协程池中进行，进度在回写时由当前协程按页面顺序上报，没有降低分辨率时不上报 resample
+		task.progress.BytesProcessed = bytesProcessed
+		if task.resampled {
+			task.progress.Phase = PhaseResample
+			reportProgress(ctx, task.progress)
+		}
+		task.progress.Phase = PhaseEncode
+		reportProgress(ctx, task.progress)
+
+		var err error
+		switch task.format {
+		case JPEG:
+			_, err = instance.FPDFImageObj_LoadJpegFileInline(&requests.FPDFImageObj_LoadJpegFileInline{
+				ImageObject: task.object,
+				Page:        &pageRef,
+				Count:       1,
+				FileData:    task.encoded,
+			})
+
+		case PNG:
+			var bitmapRes BitmapCreateResponse
+			bitmapRes, err = CreateBitmapFromImage(instance, task.img, 0)
+			if err != nil {
+				return fmt.Errorf("无法创建位图: %v", err)
+			}
+			_, err = instance.FPDFImageObj_SetBitmap(&requests.FPDFImageObj_SetBitmap{
+				ImageObject: task.object,
+				Bitmap:      bitmapRes.bitmapRef,
+				Page:        &pageRef,
+				Count:       1,
+			})
+			// SetBitmap 会复制位图数据，设置完即可释放
+			instance.FPDFBitmap_Destroy(&requests.FPDFBitmap_Destroy{
+				Bitmap: bitmapRes.bitmapRef,
+			})
+		}
+
 		if err != nil {
-			return err
+			return fmt.Errorf("无法设置图片: %v", err)
+		}
+
+		if opts.DebugDir != "" {
+			filename := fmt.Sprintf("%s_%d_%d.%s", in.name(), page.index, task.index, task.format)
+			if err = DirImageSink(opts.DebugDir)(filename, task.encoded); err != nil {
+				return fmt.Errorf("无法保存调试图片: %v", err)
+			}
+			log.Printf("调试图片已保存到: %s", filepath.Join(opts.DebugDir, filename))
 		}
 	}
 
-	_, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
-		Document: pdfDoc.Document,
-		FilePath: &copyFilePath,
-		Flags:    requests.SaveFlagNoIncremental,
+	rectObj, err := instance.FPDFPageObj_CreateNewRect(&requests.FPDFPageObj_CreateNewRect{
+		X: 0,
+		Y: 0,
+		W: 1,
+		H: 1,
 	})
 	if err != nil {
-		return fmt.Errorf("无法保存 PDF: %v", err)
+		return fmt.Errorf("无法创建矩形对象: %v", err)
 	}
 
-	util.CompareFileSize(inputPath, copyFilePath)
+	_, err = instance.FPDFPage_InsertObject(&requests.FPDFPage_InsertObject{
+		Page:       pageRef,
+		PageObject: rectObj.PageObject,
+	})
+	if err != nil {
+		return fmt.Errorf("无法插入矩形对象: %v", err)
+	}
 
-	fmt.Printf("压缩后图片信息: %v\n", stat)
+	_, err = instance.FPDFPage_GenerateContent(&requests.FPDFPage_GenerateContent{
+		Page: pageRef,
+	})
+	if err != nil {
+		return fmt.Errorf("无法生成页面内容: %v", err)
+	}
 
 	return nil
 }
@@ -450,51 +578,47 @@ func GetBitmapInfo(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, pa
 		BitmapRef: bitmap,
 	}
 
-	fmt.Printf("bitmap info:%+v\n", bitmapInfo)
-
 	return bitmapInfo, nil
 }
 
-func GetImageFromBitmap(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, page requests.Page, imgObj references.FPDF_PAGEOBJECT) (image.Image, string, error) {
-	bitmapInfo, err := GetBitmapInfo(instance, document, page, imgObj, false)
+// GetBitmapFromImage 读取图片对象的原始位图，复制像素数据后释放位图
+func GetBitmapFromImage(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, page requests.Page, imgObj references.FPDF_PAGEOBJECT) (*BitmapInfo, string, error) {
+	bitmapInfo, err := copyBitmapInfo(instance, document, page, imgObj, false)
 	if err != nil {
-		return nil, "", fmt.Errorf("无法获取图片位图信息: %v", err)
+		return nil, "", err
 	}
-	defer instance.FPDFBitmap_Destroy(&requests.FPDFBitmap_Destroy{
-		Bitmap: bitmapInfo.BitmapRef,
-	})
-	// fmt.Printf("图片元数据: raw len: %d imageMetadataRes:%+v filter:[%s] bitmap info:%v\n",
-	// 	len(dataRawRes.Data), imageMetadataRes.ImageMetadata, strings.Join(filters, ","), bitmapInfo)
 
-	_, img, err := util.RenderImage(bitmapInfo.Data, bitmapInfo.Width, bitmapInfo.Height, bitmapInfo.Stride, int(bitmapInfo.Format))
+	return bitmapInfo, JPEG, nil
+}
+
+// GetBitmapFromRenderedImage 读取图片对象渲染后（应用了 mask 和 matrix）的位图，
+// 存在透明像素时按 PNG 处理，否则退回原始位图按 JPEG 处理
+func GetBitmapFromRenderedImage(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, page requests.Page, imgObj references.FPDF_PAGEOBJECT) (*BitmapInfo, string, error) {
+	bitmapInfo, err := copyBitmapInfo(instance, document, page, imgObj, true)
 	if err != nil {
-		return nil, "", fmt.Errorf("GetImageFromDCTDecode 无法渲染图片: %v", err)
+		return nil, "", err
 	}
 
-	return img, JPEG, nil
+	if util.HasAlpha(bitmapInfo.Data, bitmapInfo.Width, bitmapInfo.Height, bitmapInfo.Stride, int(bitmapInfo.Format)) {
+		return bitmapInfo, PNG, nil
+	}
+
+	return GetBitmapFromImage(instance, document, page, imgObj)
 }
 
-func GetImageFromRenderedBitmap(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, page requests.Page, imgObj references.FPDF_PAGEOBJECT) (image.Image, string, error) {
-	bitmapInfo, err := GetBitmapInfo(instance, document, page, imgObj, true)
+// copyBitmapInfo 获取位图信息并复制像素数据，位图随即释放，返回的数据可交给其他协程解码
+func copyBitmapInfo(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, page requests.Page, imgObj references.FPDF_PAGEOBJECT, isRendered bool) (*BitmapInfo, error) {
+	bitmapInfo, err := GetBitmapInfo(instance, document, page, imgObj, isRendered)
 	if err != nil {
-		return nil, "", fmt.Errorf("无法获取图片位图信息: %v", err)
+		return nil, fmt.Errorf("无法获取图片位图信息: %v", err)
 	}
 	defer instance.FPDFBitmap_Destroy(&requests.FPDFBitmap_Destroy{
 		Bitmap: bitmapInfo.BitmapRef,
 	})
-	// fmt.Printf("图片元数据: raw len: %d imageMetadataRes:%+v filter:[%s] bitmap info:%v\n",
-	// 	len(dataRawRes.Data), imageMetadataRes.ImageMetadata, strings.Join(filters, ","), bitmapInfo)
-
-	isAlphaValid, img, err := util.RenderImage(bitmapInfo.Data, bitmapInfo.Width, bitmapInfo.Height, bitmapInfo.Stride, int(bitmapInfo.Format))
-	if err != nil {
-		return nil, "", fmt.Errorf("GetImageFromDCTDecode 无法渲染图片: %v", err)
-	}
 
-	if isAlphaValid {
-		return img, PNG, nil
-	}
+	// 位图缓冲区在位图释放后失效，必须复制
+	bitmapInfo.Data = append([]byte(nil), bitmapInfo.Data...)
+	bitmapInfo.BitmapRef = ""
 
-	return GetImageFromBitmap(instance, document, page, imgObj)
-
-	// return img, JPEG, nil
+	return bitmapInfo, nil
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressImagesInPlace(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	pdf := newTestPDF(t, processor, 2)
+	processor.Run(context.Background(), nil, nil)
+	instance, err := processor.pool.GetInstance(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.pdf")
+	assert.Nil(t, os.WriteFile(path, pdf, 0640))
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)
+	assert.Nil(t, os.Chtimes(path, modTime, modTime))
+
+	opts, _ := NewCompressOptions(60, 100, 0)
+	assert.Nil(t, CompressImagesInPlace(context.Background(), instance, path, opts))
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Less(t, info.Size(), int64(len(pdf)))
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	assert.True(t, info.ModTime().Equal(modTime))
+
+	// 只剩压缩后的文件，没有备份或临时文件
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+
+	// 失败时原文件不变
+	broken := filepath.Join(dir, "broken.pdf")
+	assert.Nil(t, os.WriteFile(broken, []byte("not a pdf"), 0644))
+	assert.NotNil(t, CompressImagesInPlace(context.Background(), instance, broken, opts))
+	data, _ := os.ReadFile(broken)
+	assert.Equal(t, "not a pdf", string(data))
+}
@@ -1,45 +1,76 @@
 package main
 
 import (
-	"compress-pdf/util"
+	"bytes"
+	"compress-pdfium/util"
+	"context"
 	"fmt"
 	"image/png"
-	"os"
-	"strings"
+	"io"
 
 	"github.com/klippa-app/go-pdfium"
 	"github.com/klippa-app/go-pdfium/enums"
 	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/responses"
 )
 
-func ExtractImages(instance pdfium.Pdfium, inputPath, outputPath string) error {
+func ExtractImages(ctx context.Context, instance pdfium.Pdfium, inputPath, outputPath string) error {
+	if outputPath == "" {
+		outputPath = "./images-files"
+	}
+	return ExtractImagesTo(ctx, instance, PDFInput{Path: inputPath}, DirImageSink(outputPath))
+}
 
-	pdfDoc, err := instance.FPDF_LoadDocument(&requests.FPDF_LoadDocument{
-		Path:     &inputPath,
-		Password: nil,
-	})
+// ExtractImagesToZip 将 in 中的图片打包为 zip 写入 w
+func ExtractImagesToZip(ctx context.Context, instance pdfium.Pdfium, in PDFInput, w io.Writer) error {
+	sink, zw := ZipImageSink(w)
+	if err := ExtractImagesTo(ctx, instance, in, sink); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// ExtractImagesTo 提取 in 中的图片，逐张交给 sink
+func ExtractImagesTo(ctx context.Context, instance pdfium.Pdfium, in PDFInput, sink ImageSink) error {
+
+	document, err := LoadDocument(instance, in)
 	if err != nil {
-		return fmt.Errorf("无法加载 PDF 文档: %v", err)
+		return fmt.Errorf("无法加载 PDF 文档: %w", err)
 	}
 	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
-		Document: pdfDoc.Document,
+		Document: document,
 	})
 
 	// 源文档页面数量
 	pageCountRes, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
-		Document: pdfDoc.Document,
+		Document: document,
 	})
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("pdf page count: %d\n", pageCountRes.PageCount)
+	progress := ProgressEvent{Pages: pageCountRes.PageCount}
 
 	// 遍历所有页面
+	var pdfPage *responses.FPDF_LoadPage
+	defer func() {
+		// 提前返回（出错或被取消）时释放仍打开的页面
+		if pdfPage != nil {
+			instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
+				Page: pdfPage.Page,
+			})
+		}
+	}()
+
 	for i := 0; i < pageCountRes.PageCount; i++ {
-		fmt.Printf("加载页面:%d\n", i)
-		pdfPage, err := instance.FPDF_LoadPage(&requests.FPDF_LoadPage{
-			Document: pdfDoc.Document,
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		progress.Page, progress.Image = i+1, 0
+		pdfPage, err = instance.FPDF_LoadPage(&requests.FPDF_LoadPage{
+			Document: document,
 			Index:    i,
 		})
 		if err != nil {
@@ -50,7 +81,7 @@ func ExtractImages(instance pdfium.Pdfium, inputPath, outputPath string) error {
 		objectCountRes, err := instance.FPDFPage_CountObjects(&requests.FPDFPage_CountObjects{
 			Page: requests.Page{
 				ByIndex: &requests.PageByIndex{
-					Document: pdfDoc.Document,
+					Document: document,
 					Index:    i,
 				},
 			},
@@ -60,10 +91,14 @@ func ExtractImages(instance pdfium.Pdfium, inputPath, outputPath string) error {
 		}
 
 		for j := 0; j < objectCountRes.Count; j++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
 			objRes, err := instance.FPDFPage_GetObject(&requests.FPDFPage_GetObject{
 				Page: requests.Page{
 					ByIndex: &requests.PageByIndex{
-						Document: pdfDoc.Document,
+						Document: document,
 						Index:    i,
 					},
 				},
@@ -86,42 +121,14 @@ func ExtractImages(instance pdfium.Pdfium, inputPath, outputPath string) error {
 				//    - FPDFBitmap_GetHeight
 				//    - FPDFBitmap_GetFormat
 
-				imageMetadataRes, err := instance.FPDFImageObj_GetImageMetadata(&requests.FPDFImageObj_GetImageMetadata{
-					ImageObject: objRes.PageObject,
-					Page: requests.Page{
-						ByIndex: &requests.PageByIndex{
-							Document: pdfDoc.Document,
-							Index:    i,
-						},
-					},
-				})
-				if err != nil {
-					return fmt.Errorf("无法获取图片元数据: %v", err)
-				}
-
-				filterCountRes, err := instance.FPDFImageObj_GetImageFilterCount(&requests.FPDFImageObj_GetImageFilterCount{
-					ImageObject: objRes.PageObject,
-				})
-				if err != nil {
-					return fmt.Errorf("无法获取图片滤镜数量: %v", err)
-				}
-
-				var filters = make([]string, 0, filterCountRes.Count)
-				for k := 0; k < filterCountRes.Count; k++ {
-					filterRes, err := instance.FPDFImageObj_GetImageFilter(&requests.FPDFImageObj_GetImageFilter{
-						ImageObject: objRes.PageObject,
-						Index:       k,
-					})
-					if err != nil {
-						return fmt.Errorf("无法获取图片滤镜: %v", err)
-					}
-					filters = append(filters, filterRes.ImageFilter)
-				}
+				progress.Image++
+				progress.Phase = PhaseExtract
+				reportProgress(ctx, progress)
 
 				// 获取图片位图信息
-				bitmapInfo, err := GetBitmapInfo(instance, pdfDoc.Document, requests.Page{
+				bitmapInfo, err := GetBitmapInfo(instance, document, requests.Page{
 					ByIndex: &requests.PageByIndex{
-						Document: pdfDoc.Document,
+						Document: document,
 						Index:    i,
 					},
 				}, objRes.PageObject, true)
@@ -129,51 +136,57 @@ func ExtractImages(instance pdfium.Pdfium, inputPath, outputPath string) error {
 					return fmt.Errorf("无法获取图片位图信息: %v", err)
 				}
 
-				fmt.Printf("图片元数据: imageMetadataRes:%+v filter:[%s] bitmap info:%s\n",
-					imageMetadataRes.ImageMetadata, strings.Join(filters, ","), bitmapInfo)
-
 				isAlphaValid, img, err := util.RenderImage(bitmapInfo.Data, bitmapInfo.Width, bitmapInfo.Height, bitmapInfo.Stride, int(bitmapInfo.Format))
+
+				// 像素已复制到 img，位图可以立即释放
+				if _, destroyErr := instance.FPDFBitmap_Destroy(&requests.FPDFBitmap_Destroy{
+					Bitmap: bitmapInfo.BitmapRef,
+				}); destroyErr != nil && err == nil {
+					err = destroyErr
+				}
 				if err != nil {
 					return fmt.Errorf("无法渲染图片: %v", err)
 				}
 
-				inputFileName := strings.Split(inputPath, "/")[len(strings.Split(inputPath, "/"))-1]
-				filename := fmt.Sprintf("./images-files/%s_%d_%d", inputFileName, i, j)
+				filename := fmt.Sprintf("%s_%d_%d", in.name(), i, j)
 
+				progress.Phase = PhaseEncode
+				reportProgress(ctx, progress)
+
+				var buf bytes.Buffer
 				if isAlphaValid {
-					// 测试用，留痕，保存为png
-					func() {
-						filename = filename + ".png"
-						outFile, _ := os.Create(filename)
-						defer outFile.Close()
-
-						// 将图像编码为 png 格式并写入输出文件
-						png.Encode(outFile, img)
-					}()
+					filename = filename + ".png"
+					err = png.Encode(&buf, img)
 				} else {
 					filename = filename + ".jpeg"
-					err = util.ConvertToJPEG(img, filename, 100)
-					if err != nil {
-						return fmt.Errorf("无法保存图片: %v", err)
-					}
+					err = util.EncodeJPEG(&buf, img, 100)
+				}
+				if err != nil {
+					return fmt.Errorf("无法编码图片: %v", err)
 				}
-				// os.Remove(filename)
 
-				if _, err = instance.FPDFBitmap_Destroy(&requests.FPDFBitmap_Destroy{
-					Bitmap: bitmapInfo.BitmapRef,
-				}); err != nil {
+				// 取消后不再输出图片
+				if err := ctx.Err(); err != nil {
 					return err
 				}
+				if err = sink(filename, buf.Bytes()); err != nil {
+					return fmt.Errorf("无法保存图片: %v", err)
+				}
+				progress.BytesProcessed += int64(buf.Len())
 			}
 		}
 
 		_, err = instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
 			Page: pdfPage.Page,
 		})
+		pdfPage = nil
 		if err != nil {
 			return err
 		}
 	}
 
+	progress.Phase, progress.Image = PhaseSave, 0
+	reportProgress(ctx, progress)
+
 	return nil
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"compress-pdfium/util"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"sync"
+
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/structs"
+)
+
+// pageTasks 一个页面中待压缩的图片，页面在回写完成前保持打开
+type pageTasks struct {
+	index int
+	page  references.FPDF_PAGE
+	tasks []*imageTask
+}
+
+// ref 按引用访问页面，多个页面可以同时保持打开
+func (p *pageTasks) ref() requests.Page {
+	return requests.Page{
+		ByReference: &p.page,
+	}
+}
+
+// ready 页面中的图片是否都已编码完成
+func (p *pageTasks) ready() bool {
+	for _, task := range p.tasks {
+		select {
+		case <-task.done:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// imageTask 一张待压缩的图片
+// 前半部分字段在提取时由主协程填写，后半部分由编码协程填写，done 关闭后主协程才能读取
+type imageTask struct {
+	index    int // 图片对象在页面中的序号
+	object   references.FPDF_PAGEOBJECT
+	metadata structs.FPDF_IMAGEOBJ_METADATA
+	bitmap   *BitmapInfo // 已复制出的位图数据，pdfium 位图已释放
+	format   string      // 重新编码的格式：JPEG 或 PNG
+	progress ProgressEvent
+
+	resampled bool        // 是否降低了分辨率
+	img       image.Image // PNG 格式回写位图时使用
+	encoded   []byte      // JPEG 数据；PNG 仅在开启调试时编码
+	err       error
+	done      chan struct{}
+}
+
+// encode 解码位图、降低分辨率并编码，不调用 pdfium、不上报进度，可在任意协程执行
+func (t *imageTask) encode(opts CompressOptions) error {
+	bitmapInfo := t.bitmap
+	_, img, err := util.RenderImage(bitmapInfo.Data, bitmapInfo.Width, bitmapInfo.Height, bitmapInfo.Stride, int(bitmapInfo.Format))
+	if err != nil {
+		return fmt.Errorf("无法渲染图片: %v", err)
+	}
+	// 像素已转换到 img，尽早释放原始数据
+	t.bitmap = nil
+
+	/*=====================================================step2、降低图片分辨率=========================================================*/
+	if t.metadata.HorizontalDPI > opts.HighThanDPI {
+		width := img.Bounds().Dx()
+		img = util.ReduceDPI(img, int(t.metadata.Width), t.metadata.HorizontalDPI, opts.SetDPI)
+		t.resampled = img.Bounds().Dx() != width
+	}
+
+	/*=====================================================step3、图片压缩=========================================================*/
+	var encoded bytes.Buffer
+	switch t.format {
+	case JPEG:
+		// 直接在内存中编码，无需落盘再读回
+		if err = util.EncodeJPEG(&encoded, img, opts.Quality); err != nil {
+			return fmt.Errorf("无法编码图片: %v", err)
+		}
+
+	case PNG:
+		// PNG 通过 SetBitmap 回写，由 pdfium 负责压缩
+		t.img = img
+		if opts.DebugDir != "" {
+			if err = png.Encode(&encoded, img); err != nil {
+				return fmt.Errorf("无法编码图片: %v", err)
+			}
+		}
+	}
+	t.encoded = encoded.Bytes()
+
+	return nil
+}
+
+// imageEncoder 固定数量的编码协程，从有界队列中取任务执行 imageTask.encode
+type imageEncoder struct {
+	ctx   context.Context
+	opts  CompressOptions
+	tasks chan *imageTask
+	wg    sync.WaitGroup
+}
+
+// newImageEncoder 启动 workers 个编码协程，队列中最多排队 workers 个任务
+func newImageEncoder(ctx context.Context, workers int, opts CompressOptions) *imageEncoder {
+	e := &imageEncoder{
+		ctx:   ctx,
+		opts:  opts,
+		tasks: make(chan *imageTask, workers),
+	}
+	e.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go e.work()
+	}
+	return e
+}
+
+func (e *imageEncoder) work() {
+	defer e.wg.Done()
+	for task := range e.tasks {
+		// 已取消时不再编码，只让主协程尽快看到错误
+		if err := e.ctx.Err(); err != nil {
+			task.err = err
+		} else {
+			task.err = task.encode(e.opts)
+		}
+		close(task.done)
+	}
+}
+
+// submit 提交任务，队列已满时阻塞，直到有协程空闲或 ctx 被取消；任务结束后关闭 task.done
+func (e *imageEncoder) submit(task *imageTask) {
+	select {
+	case e.tasks <- task:
+	case <-e.ctx.Done():
+		task.err = e.ctx.Err()
+		close(task.done)
+	}
+}
+
+// wait 关闭队列并等待编码协程退出，之后不能再提交任务
+func (e *imageEncoder) wait() {
+	close(e.tasks)
+	e.wg.Wait()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageEncoder(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	encoder := newImageEncoder(ctx, 2, CompressOptions{})
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before+2)
+
+	// 取消后提交不阻塞，也不再编码，任务直接带着错误结束
+	cancel()
+	tasks := make([]*imageTask, 100)
+	for i := range tasks {
+		tasks[i] = &imageTask{done: make(chan struct{})}
+		encoder.submit(tasks[i])
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before+2)
+	encoder.wait()
+	for _, task := range tasks {
+		<-task.done
+		assert.ErrorIs(t, task.err, context.Canceled)
+	}
+}
+
+func TestImageTaskResampled(t *testing.T) {
+	newTask := func(dpi float32) *imageTask {
+		return &imageTask{
+			metadata: structs.FPDF_IMAGEOBJ_METADATA{Width: 40, Height: 40, HorizontalDPI: dpi},
+			bitmap:   &BitmapInfo{Width: 40, Height: 40, Stride: 40, Format: enums.FPDF_BITMAP_FORMAT_GRAY, Data: make([]byte, 40*40)},
+			format:   JPEG,
+		}
+	}
+	opts := CompressOptions{Quality: 80, SetDPI: 150, HighThanDPI: 200}
+
+	// DPI 高于阈值时降低分辨率
+	task := newTask(300)
+	assert.Nil(t, task.encode(opts))
+	assert.True(t, task.resampled)
+
+	// 低于阈值，或已不高于目标 DPI 时不降低
+	task = newTask(100)
+	assert.Nil(t, task.encode(opts))
+	assert.False(t, task.resampled)
+	task = newTask(300)
+	assert.Nil(t, task.encode(CompressOptions{Quality: 80, SetDPI: 600, HighThanDPI: 200}))
+	assert.False(t, task.resampled)
+}
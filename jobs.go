@@ -0,0 +1,545 @@
+package main
+
+import (
+	"compress-pdfium/util"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobState 异步任务状态
+type JobState string
+
+const (
+	JobQueued  JobState = "queued"  // 等待处理，服务重启后处理中的任务也会回到该状态
+	JobRunning JobState = "running" // 处理中
+	JobDone    JobState = "done"    // 处理成功，可以下载结果
+	JobFailed  JobState = "failed"  // 处理失败
+)
+
+// Job 异步任务，持久化为 spool 目录下的 <id>/job.json
+type Job struct {
+	ID         string         `json:"id"`
+	Kind       string         `json:"kind"` // compress、extract、watermark
+	State      JobState       `json:"state"`
+	InputName  string         `json:"input_name"`
+	Options    RequestOptions `json:"options"`             // 不含密码，密码只保存在内存中
+	Encrypted  bool           `json:"encrypted,omitempty"` // 提交时带有密码
+	Progress   *ProgressEvent `json:"progress,omitempty"`
+	Report     *JobReport     `json:"report,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+}
+
+// JobReport 任务完成后的统计
+type JobReport struct {
+	InputSize    int64   `json:"input_size"`
+	OutputSize   int64   `json:"output_size"`
+	SavedPercent float64 `json:"saved_percent"`
+	DurationMS   int64   `json:"duration_ms"`
+}
+
+// JobQueueConfig 异步任务配置
+type JobQueueConfig struct {
+	SpoolDir    string        // 保存上传文件、结果和任务状态的目录
+	Concurrency int           // 同时处理的任务数，默认 1
+	Timeout     time.Duration // 单个任务的处理超时，默认 30 分钟
+	Retention   time.Duration // 完成或失败的任务保留多久，默认 24 小时
+}
+
+// JobQueue 异步任务队列，任务持久化在 spool 目录中，服务重启后继续处理
+//
+//	POST   /jobs/{compress|extract|watermark}  提交任务，参数与同步接口相同，返回 202 和任务状态
+//	GET    /jobs/{id}                          查询状态、进度和统计
+//	GET    /jobs/{id}/result                   下载结果
+//	DELETE /jobs/{id}                          取消并删除任务
+type JobQueue struct {
+	server *Server
+	config JobQueueConfig
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	jobs      map[string]*Job
+	passwords map[string]string // 未结束任务的文档密码，不写入 spool
+	pending   []string
+	running   map[string]context.CancelFunc
+	closed    bool
+}
+
+// NewJobQueue 打开 spool 目录，恢复未完成的任务并启动处理协程
+func NewJobQueue(server *Server, config JobQueueConfig) (*JobQueue, error) {
+	if config.SpoolDir == "" {
+		return nil, errors.New("未指定 spool 目录")
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Minute * 30
+	}
+	if config.Retention <= 0 {
+		config.Retention = time.Hour * 24
+	}
+	if err := os.MkdirAll(config.SpoolDir, 0700); err != nil {
+		return nil, fmt.Errorf("无法创建 spool 目录: %v", err)
+	}
+
+	q := &JobQueue{
+		server:    server,
+		config:    config,
+		jobs:      make(map[string]*Job),
+		passwords: make(map[string]string),
+		running:   make(map[string]context.CancelFunc),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < config.Concurrency; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+
+	return q, nil
+}
+
+// load 读取 spool 中的任务，未完成的按提交时间重新排队，过期的删除
+func (q *JobQueue) load() error {
+	entries, err := os.ReadDir(q.config.SpoolDir)
+	if err != nil {
+		return fmt.Errorf("无法读取 spool 目录: %v", err)
+	}
+
+	var queued []*Job
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(q.config.SpoolDir, entry.Name(), "job.json"))
+		if err != nil {
+			// 提交时写了一半就中断的任务
+			os.RemoveAll(filepath.Join(q.config.SpoolDir, entry.Name()))
+			continue
+		}
+		var job Job
+		if err = json.Unmarshal(data, &job); err != nil || job.ID != entry.Name() {
+			os.RemoveAll(filepath.Join(q.config.SpoolDir, entry.Name()))
+			continue
+		}
+
+		if q.expired(&job) {
+			os.RemoveAll(filepath.Join(q.config.SpoolDir, job.ID))
+			continue
+		}
+		if job.State == JobRunning {
+			job.State, job.Progress, job.StartedAt = JobQueued, nil, time.Time{}
+		}
+		q.jobs[job.ID] = &job
+		if job.State == JobQueued {
+			queued = append(queued, &job)
+		}
+	}
+
+	sort.Slice(queued, func(i, j int) bool {
+		return queued[i].CreatedAt.Before(queued[j].CreatedAt)
+	})
+	for _, job := range queued {
+		q.pending = append(q.pending, job.ID)
+	}
+	return nil
+}
+
+// Close 停止接收新任务，中断处理中的任务（重启后重新处理）并等待处理协程退出
+func (q *JobQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+
+	q.cancel()
+	q.wg.Wait()
+}
+
+// Submit 保存上传的文件并排队，返回任务状态。密码只保存在内存中，
+// 服务重启后带密码的任务无法继续处理
+func (q *JobQueue) Submit(kind string, u *upload) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	job := &Job{
+		ID:        id,
+		Kind:      kind,
+		State:     JobQueued,
+		InputName: u.name,
+		Options:   u.opts,
+		Encrypted: u.opts.Password != "",
+		CreatedAt: time.Now(),
+	}
+	job.Options.Password = ""
+
+	dir := q.jobDir(id)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return Job{}, err
+	}
+	err = os.WriteFile(filepath.Join(dir, "input.pdf"), u.pdf, 0600)
+	if err == nil && u.logo != nil {
+		err = os.WriteFile(filepath.Join(dir, "logo.png"), u.logo, 0600)
+	}
+	if err == nil {
+		err = q.save(job)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return Job{}, fmt.Errorf("无法保存任务: %v", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		os.RemoveAll(dir)
+		return Job{}, errors.New("任务队列已关闭")
+	}
+	q.jobs[id] = job
+	if job.Encrypted {
+		q.passwords[id] = u.opts.Password
+	}
+	q.pending = append(q.pending, id)
+	q.cond.Signal()
+
+	return *job, nil
+}
+
+// Get 返回任务的当前状态
+func (q *JobQueue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Delete 取消并删除任务
+func (q *JobQueue) Delete(id string) bool {
+	q.mu.Lock()
+	job, ok := q.jobs[id]
+	// 处理中的任务由处理协程在退出时发现任务已删除并删除目录，这里删除会与仍在写入的处理协程冲突
+	running := ok && job.State == JobRunning
+	if ok {
+		delete(q.jobs, id)
+		delete(q.passwords, id)
+		for i, pendingID := range q.pending {
+			if pendingID == id {
+				q.pending = append(q.pending[:i], q.pending[i+1:]...)
+				break
+			}
+		}
+		if cancel, ok := q.running[id]; ok {
+			cancel()
+		}
+	}
+	q.mu.Unlock()
+
+	if ok && !running {
+		os.RemoveAll(q.jobDir(id))
+	}
+	return ok
+}
+
+// next 取出下一个任务及其密码，队列关闭时返回 false
+func (q *JobQueue) next() (*Job, string, context.Context, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.pending) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, "", nil, false
+	}
+
+	id := q.pending[0]
+	q.pending = q.pending[1:]
+	job := q.jobs[id]
+
+	ctx, cancel := context.WithTimeout(q.ctx, q.config.Timeout)
+	q.running[id] = cancel
+	job.State, job.StartedAt, job.Error = JobRunning, time.Now(), ""
+
+	return job, q.passwords[id], ctx, true
+}
+
+func (q *JobQueue) worker() {
+	defer q.wg.Done()
+
+	for {
+		job, password, ctx, ok := q.next()
+		if !ok {
+			return
+		}
+		q.run(ctx, job, password)
+		q.cleanup()
+	}
+}
+
+// run 处理一个任务，结果原子地写入 output 文件，状态变化都写回 job.json
+func (q *JobQueue) run(ctx context.Context, job *Job, password string) {
+	defer func() {
+		q.mu.Lock()
+		if cancel, ok := q.running[job.ID]; ok {
+			cancel()
+			delete(q.running, job.ID)
+		}
+		q.mu.Unlock()
+	}()
+
+	dir := q.jobDir(job.ID)
+	if err := q.save(job); err != nil {
+		log.Printf("任务 %s 无法保存状态: %v", job.ID, err)
+	}
+
+	kind := q.server.kinds()[job.Kind]
+	u := &upload{name: job.InputName, opts: job.Options}
+	u.opts.Password = password
+	if _, err := os.Stat(filepath.Join(dir, "logo.png")); err == nil {
+		u.logoPath = filepath.Join(dir, "logo.png")
+	}
+
+	res := BatchResult{}
+	fn, cleanup, err := kind.job(u)
+	if err == nil && job.Encrypted && password == "" {
+		// 密码不写入 spool，服务重启后无法恢复
+		cleanup()
+		err = errors.New("服务重启后文档密码已丢失，请重新提交任务")
+	}
+	if err == nil {
+		ctx = WithProgress(ctx, func(e ProgressEvent) {
+			q.mu.Lock()
+			job.Progress = &e
+			q.mu.Unlock()
+		})
+
+		in := PDFInput{Path: filepath.Join(dir, "input.pdf"), Name: job.InputName}
+		if password != "" {
+			in.Password = &password
+		}
+
+		res = q.server.processor.process(ctx, BatchJob{
+			Name:   job.ID,
+			Input:  in,
+			Output: PDFOutput{Path: filepath.Join(dir, "output"+kind.ext)},
+		}, fn)
+		cleanup()
+		err = res.Err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.jobs[job.ID]; !ok {
+		// 处理过程中任务被删除
+		os.RemoveAll(dir)
+		return
+	}
+	if q.closed && errors.Is(err, context.Canceled) {
+		// 服务关闭导致中断，保持排队状态，重启后重新处理
+		job.State, job.Progress, job.StartedAt = JobQueued, nil, time.Time{}
+		q.saveLocked(job)
+		return
+	}
+
+	delete(q.passwords, job.ID)
+	job.FinishedAt = time.Now()
+	if err != nil {
+		job.State, job.Error = JobFailed, err.Error()
+		log.Printf("任务 %s (%s %s) 失败: %v", job.ID, job.Kind, job.InputName, err)
+	} else {
+		job.State = JobDone
+		job.Report = &JobReport{DurationMS: res.Duration.Milliseconds()}
+		if info, err := os.Stat(filepath.Join(dir, "input.pdf")); err == nil {
+			job.Report.InputSize = info.Size()
+		}
+		if info, err := os.Stat(filepath.Join(dir, "output"+kind.ext)); err == nil {
+			job.Report.OutputSize = info.Size()
+		}
+		job.Report.SavedPercent = savedPercent(job.Report.InputSize, job.Report.OutputSize)
+	}
+	if err = q.saveLocked(job); err != nil {
+		log.Printf("任务 %s 无法保存状态: %v", job.ID, err)
+	}
+}
+
+// cleanup 删除超过保留时间的任务
+func (q *JobQueue) cleanup() {
+	q.mu.Lock()
+	var expired []string
+	for id, job := range q.jobs {
+		if q.expired(job) {
+			expired = append(expired, id)
+			delete(q.jobs, id)
+		}
+	}
+	q.mu.Unlock()
+
+	for _, id := range expired {
+		os.RemoveAll(q.jobDir(id))
+	}
+}
+
+func (q *JobQueue) expired(job *Job) bool {
+	return (job.State == JobDone || job.State == JobFailed) && time.Since(job.FinishedAt) > q.config.Retention
+}
+
+func (q *JobQueue) jobDir(id string) string {
+	return filepath.Join(q.config.SpoolDir, id)
+}
+
+func (q *JobQueue) save(job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.saveLocked(job)
+}
+
+// saveLocked 先写临时文件再改名，崩溃时不会留下写了一半的 job.json
+func (q *JobQueue) saveLocked(job *Job) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(q.jobDir(job.ID), "job.json")
+	return util.AtomicWriteFile(path, util.AtomicWriteOptions{Perm: 0600}, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Handler 返回 /jobs/ 下的接口
+func (q *JobQueue) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/"), "/")
+
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodPost:
+			q.submit(w, r, parts[0])
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			q.status(w, parts[0])
+		case len(parts) == 1 && r.Method == http.MethodDelete:
+			if !q.Delete(parts[0]) {
+				writeError(w, requestError{status: http.StatusNotFound, err: errors.New("任务不存在")})
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case len(parts) == 2 && parts[1] == "result" && r.Method == http.MethodGet:
+			q.result(w, r, parts[0])
+		default:
+			writeError(w, requestError{status: http.StatusNotFound, err: fmt.Errorf("不支持的接口: %s %s", r.Method, r.URL.Path)})
+		}
+	})
+}
+
+func (q *JobQueue) submit(w http.ResponseWriter, r *http.Request, kindName string) {
+	kind, ok := q.server.kinds()[kindName]
+	if !ok {
+		writeError(w, requestError{status: http.StatusNotFound, err: fmt.Errorf("不支持的任务类型: %s", kindName)})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, q.server.config.MaxUploadSize)
+	u, err := readUpload(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// 提交时就检查参数，避免排队后才失败
+	_, cleanup, err := kind.job(u)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	cleanup()
+
+	job, err := q.Submit(kindName, u)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJob(w, http.StatusAccepted, job)
+}
+
+func (q *JobQueue) status(w http.ResponseWriter, id string) {
+	job, ok := q.Get(id)
+	if !ok {
+		writeError(w, requestError{status: http.StatusNotFound, err: errors.New("任务不存在")})
+		return
+	}
+	writeJob(w, http.StatusOK, job)
+}
+
+func (q *JobQueue) result(w http.ResponseWriter, r *http.Request, id string) {
+	job, ok := q.Get(id)
+	if !ok {
+		writeError(w, requestError{status: http.StatusNotFound, err: errors.New("任务不存在")})
+		return
+	}
+	if job.State != JobDone {
+		writeError(w, requestError{status: http.StatusConflict, err: fmt.Errorf("任务尚未完成: %s", job.State)})
+		return
+	}
+
+	kind := q.server.kinds()[job.Kind]
+	f, err := os.Open(filepath.Join(q.jobDir(id), "output"+kind.ext))
+	if err != nil {
+		// 结果已被删除
+		writeError(w, requestError{status: http.StatusNotFound, err: errors.New("任务结果不存在")})
+		return
+	}
+	defer f.Close()
+
+	filename := strings.TrimSuffix(job.InputName, filepath.Ext(job.InputName)) + kind.ext
+	w.Header().Set("Content-Type", kind.contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	http.ServeContent(w, r, filename, job.FinishedAt, f)
+}
+
+func writeJob(w http.ResponseWriter, status int, job Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobQueue(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	pdf := newTestPDF(t, processor, 2)
+	spool := t.TempDir()
+	s := NewServer(processor, ServerConfig{})
+
+	open := func() (*JobQueue, *httptest.Server) {
+		queue, err := NewJobQueue(s, JobQueueConfig{SpoolDir: spool, Concurrency: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return queue, httptest.NewServer(queue.Handler())
+	}
+
+	// 轮询直到任务结束
+	wait := func(server *httptest.Server, id string) Job {
+		deadline := time.Now().Add(time.Second * 30)
+		for time.Now().Before(deadline) {
+			res, err := http.Get(server.URL + "/jobs/" + id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var job Job
+			json.NewDecoder(res.Body).Decode(&job)
+			res.Body.Close()
+			if job.State == JobDone || job.State == JobFailed {
+				return job
+			}
+			time.Sleep(time.Millisecond * 50)
+		}
+		t.Fatalf("任务 %s 超时", id)
+		return Job{}
+	}
+
+	queue, server := open()
+
+	res, err := http.Post(server.URL+"/jobs/compress?quality=60&password=secret", "application/pdf", bytes.NewReader(pdf))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+	var submitted Job
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&submitted))
+	res.Body.Close()
+	assert.Equal(t, "/jobs/"+submitted.ID, res.Header.Get("Location"))
+	assert.Empty(t, submitted.Options.Password)
+
+	job := wait(server, submitted.ID)
+	assert.Equal(t, JobDone, job.State, job.Error)
+	assert.Equal(t, 60, job.Options.Quality)
+	assert.True(t, job.Encrypted)
+	// 密码不写入 spool
+	saved, err := os.ReadFile(filepath.Join(spool, job.ID, "job.json"))
+	assert.Nil(t, err)
+	assert.NotContains(t, string(saved), "secret")
+	assert.NotNil(t, job.Report)
+	assert.Equal(t, int64(len(pdf)), job.Report.InputSize)
+	assert.Less(t, job.Report.OutputSize, job.Report.InputSize)
+	assert.NotNil(t, job.Progress)
+	assert.Equal(t, PhaseSave, job.Progress.Phase)
+
+	res, err = http.Get(server.URL + "/jobs/" + job.ID + "/result")
+	assert.Nil(t, err)
+	data, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/pdf", res.Header.Get("Content-Type"))
+	assert.Equal(t, job.Report.OutputSize, int64(len(data)))
+
+	// 导出图片的结果为 zip
+	res, err = http.Post(server.URL+"/jobs/extract", "application/pdf", bytes.NewReader(pdf))
+	assert.Nil(t, err)
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&submitted))
+	res.Body.Close()
+	extracted := wait(server, submitted.ID)
+	assert.Equal(t, JobDone, extracted.State, extracted.Error)
+	res, err = http.Get(server.URL + "/jobs/" + extracted.ID + "/result")
+	assert.Nil(t, err)
+	data, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, "application/zip", res.Header.Get("Content-Type"))
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.Nil(t, err)
+	assert.Len(t, zr.File, 2)
+
+	// 参数错误在提交时返回
+	res, err = http.Post(server.URL+"/jobs/compress?quality=101", "application/pdf", bytes.NewReader(pdf))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res.Body.Close()
+
+	res, err = http.Post(server.URL+"/jobs/unknown", "application/pdf", bytes.NewReader(pdf))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res.Body.Close()
+
+	server.Close()
+	queue.Close()
+
+	// 模拟处理到一半时服务崩溃，重启后重新处理；带密码的任务密码已丢失，直接失败
+	for _, j := range []Job{job, extracted} {
+		j.State, j.Report = JobRunning, nil
+		data, _ = json.Marshal(j)
+		assert.Nil(t, os.WriteFile(filepath.Join(spool, j.ID, "job.json"), data, 0600))
+	}
+	assert.Nil(t, os.Remove(filepath.Join(spool, extracted.ID, "output.zip")))
+
+	queue, server = open()
+	defer queue.Close()
+	defer server.Close()
+
+	failed := wait(server, job.ID)
+	assert.Equal(t, JobFailed, failed.State)
+	assert.Contains(t, failed.Error, "密码")
+	extracted = wait(server, extracted.ID)
+	assert.Equal(t, JobDone, extracted.State, extracted.Error)
+	_, err = os.Stat(filepath.Join(spool, extracted.ID, "output.zip"))
+	assert.Nil(t, err)
+
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/jobs/"+job.ID, nil)
+	res, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	res.Body.Close()
+	_, err = os.Stat(filepath.Join(spool, job.ID))
+	assert.True(t, os.IsNotExist(err))
+
+	res, err = http.Get(server.URL + "/jobs/" + job.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res.Body.Close()
+
+	// 删除处理中的任务：处理协程退出时删除目录
+	res, err = http.Post(server.URL+"/jobs/compress?quality=60", "application/pdf", bytes.NewReader(newTestPDF(t, processor, 6)))
+	assert.Nil(t, err)
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&submitted))
+	res.Body.Close()
+	assert.Eventually(t, func() bool {
+		queue.mu.Lock()
+		defer queue.mu.Unlock()
+		return queue.jobs[submitted.ID].State == JobRunning
+	}, time.Second*10, time.Millisecond*10)
+	assert.True(t, queue.Delete(submitted.ID))
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(spool, submitted.ID))
+		return os.IsNotExist(err)
+	}, time.Second*30, time.Millisecond*50)
+}
@@ -1,43 +1,17 @@
 package main
 
 import (
-	"context"
-	"fmt"
-	"log"
-	"strings"
-	"time"
+	"os"
 
-	"github.com/klippa-app/go-pdfium"
-	"github.com/klippa-app/go-pdfium/single_threaded"
+	"github.com/klippa-app/go-pdfium/multi_threaded/worker"
 )
 
-// Be sure to close pools/instances when you're done with them.
-var pool pdfium.Pool
-var instance pdfium.Pdfium
-
-func init() {
-	// Init the PDFium library and return the instance to open documents.
-	pool = single_threaded.Init(single_threaded.Config{})
-
-	var err error
-	instance, err = pool.GetInstance(time.Second * 30)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func CompressPDF() {
-	inputPath := "../pdf-files/cbook1.pdf"
-
-	if err := CompressImagesInPlace(instance, inputPath, 90); err != nil {
-		log.Fatalf("压缩 PDF 失败: %v", err)
-	}
-}
-
 func main() {
-	beginTime := time.Now()
-
-	CompressPDF()
+	// 作为 multi_threaded 的子进程启动时，由 worker 自行初始化 pdfium，stdout 留给与父进程的握手
+	if isWorkerProcess() {
+		worker.StartWorker(nil)
+		return
+	}
 
-	fmt.Printf("PDF 压缩成功，耗时: %dms\n", time.Since(beginTime).Milliseconds())
+	os.Exit(runCLI(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/responses"
+)
+
+// PDFInfo 文档概况
+type PDFInfo struct {
+	Name        string         `json:"name"`
+	FileVersion int            `json:"file_version"` // 14 表示 1.4，未知时为 0
+	PageCount   int            `json:"page_count"`
+	Pages       []PageInfo     `json:"pages"`
+	Images      int            `json:"images"`
+	ImageBytes  int64          `json:"image_bytes"` // 图片压缩数据的总大小
+	Filters     map[string]int `json:"filters"`     // 按图片编码统计，无编码的图片记为 "none"
+}
+
+// PageInfo 单个页面的概况
+type PageInfo struct {
+	Page       int     `json:"page"`   // 从 1 开始
+	Width      float64 `json:"width"`  // 单位为点（1/72 英寸）
+	Height     float64 `json:"height"` // 单位为点（1/72 英寸）
+	Objects    int     `json:"objects"`
+	Images     int     `json:"images"`
+	ImageBytes int64   `json:"image_bytes"`
+}
+
+// GetPDFInfo 读取文档的版本、页面尺寸和图片统计，不修改文档
+func GetPDFInfo(ctx context.Context, instance pdfium.Pdfium, in PDFInput) (*PDFInfo, error) {
+
+	document, err := LoadDocument(instance, in)
+	if err != nil {
+		return nil, fmt.Errorf("无法加载 PDF 文档=%s: %w", in.name(), err)
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
+		Document: document,
+	})
+
+	info := &PDFInfo{Name: in.name(), Filters: make(map[string]int)}
+
+	// 部分文档没有版本号，此时忽略错误
+	if versionRes, err := instance.FPDF_GetFileVersion(&requests.FPDF_GetFileVersion{
+		Document: document,
+	}); err == nil {
+		info.FileVersion = versionRes.FileVersion
+	}
+
+	pageCountRes, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
+		Document: document,
+	})
+	if err != nil {
+		return nil, err
+	}
+	info.PageCount = pageCountRes.PageCount
+
+	var pdfPage *responses.FPDF_LoadPage
+	defer func() {
+		if pdfPage != nil {
+			instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
+				Page: pdfPage.Page,
+			})
+		}
+	}()
+
+	for i := 0; i < pageCountRes.PageCount; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		sizeRes, err := instance.FPDF_GetPageSizeByIndex(&requests.FPDF_GetPageSizeByIndex{
+			Document: document,
+			Index:    i,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("无法获取页面尺寸: %v", err)
+		}
+
+		pdfPage, err = instance.FPDF_LoadPage(&requests.FPDF_LoadPage{
+			Document: document,
+			Index:    i,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("无法加载页面: %v", err)
+		}
+		pageRef := requests.Page{
+			ByReference: &pdfPage.Page,
+		}
+
+		objectCountRes, err := instance.FPDFPage_CountObjects(&requests.FPDFPage_CountObjects{
+			Page: pageRef,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("无法获取页面对象数量: %v", err)
+		}
+
+		page := PageInfo{
+			Page:    i + 1,
+			Width:   sizeRes.Width,
+			Height:  sizeRes.Height,
+			Objects: objectCountRes.Count,
+		}
+
+		for j := 0; j < objectCountRes.Count; j++ {
+			objRes, err := instance.FPDFPage_GetObject(&requests.FPDFPage_GetObject{
+				Page:  pageRef,
+				Index: j,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("无法获取页面对象: %v", err)
+			}
+
+			objTypeRes, err := instance.FPDFPageObj_GetType(&requests.FPDFPageObj_GetType{
+				PageObject: objRes.PageObject,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("无法获取页面对象类型: %v", err)
+			}
+			if objTypeRes.Type != enums.FPDF_PAGEOBJ_IMAGE {
+				continue
+			}
+
+			filters, err := GetImageObjectFilter(instance, objRes.PageObject)
+			if err != nil {
+				return nil, err
+			}
+			if len(filters) == 0 {
+				filters = []string{"none"}
+			}
+			info.Filters[strings.Join(filters, ",")]++
+
+			rawRes, err := instance.FPDFImageObj_GetImageDataRaw(&requests.FPDFImageObj_GetImageDataRaw{
+				ImageObject: objRes.PageObject,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("无法获取图片数据: %v", err)
+			}
+
+			page.Images++
+			page.ImageBytes += int64(len(rawRes.Data))
+		}
+
+		instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
+			Page: pdfPage.Page,
+		})
+		pdfPage = nil
+
+		info.Pages = append(info.Pages, page)
+		info.Images += page.Images
+		info.ImageBytes += page.ImageBytes
+	}
+
+	return info, nil
+}
+
+// Print 以表格形式输出文档概况
+func (info *PDFInfo) Print(w io.Writer) {
+	fmt.Fprintf(w, "文件: %s\n", info.Name)
+	if info.FileVersion > 0 {
+		fmt.Fprintf(w, "版本: %d.%d\n", info.FileVersion/10, info.FileVersion%10)
+	}
+	fmt.Fprintf(w, "页数: %d\n", info.PageCount)
+	fmt.Fprintf(w, "图片: %d 张，%.fKB\n", info.Images, float64(info.ImageBytes)/1024)
+
+	filters := make([]string, 0, len(info.Filters))
+	for f := range info.Filters {
+		filters = append(filters, f)
+	}
+	sort.Strings(filters)
+	for _, f := range filters {
+		fmt.Fprintf(w, "  %s: %d\n", f, info.Filters[f])
+	}
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "页\t宽(pt)\t高(pt)\t对象\t图片\t图片大小\t")
+	for _, p := range info.Pages {
+		fmt.Fprintf(tw, "%d\t%.1f\t%.1f\t%d\t%d\t%.fKB\t\n", p.Page, p.Width, p.Height, p.Objects, p.Images, float64(p.ImageBytes)/1024)
+	}
+	tw.Flush()
+}
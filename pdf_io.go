@@ -0,0 +1,167 @@
+package main
+
+import (
+	"archive/zip"
+	"compress-pdfium/util"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+// PDFInput 描述 PDF 的来源，Path、Data、Reader 三选一
+//   - Path: 本地文件路径，走 FPDF_LoadDocument
+//   - Data: 内存中的完整文件内容，走 FPDF_LoadMemDocument
+//   - Reader: 可 Seek 的流（如 HTTP body 落到的缓冲），走 FPDF_LoadCustomDocument
+type PDFInput struct {
+	Path   string
+	Data   []byte
+	Reader io.ReadSeeker
+	Size   int64 // Reader 的总长度，为 0 时通过 Seek 计算
+
+	Name     string  // 用于日志和导出图片命名，为空时取 Path 的文件名
+	Password *string // 文档密码
+}
+
+// PDFOutput 描述 PDF 的输出位置，Path、Writer 二选一
+// 写入 Path 时先写同目录下的临时文件，fsync 后再改名，中途失败不会损坏已有文件
+type PDFOutput struct {
+	Path   string
+	Writer io.Writer
+
+	// PreserveFrom 非空时输出文件沿用该文件的权限和修改时间，仅对 Path 有效
+	PreserveFrom string
+}
+
+// name 返回输入的展示名称
+func (in PDFInput) name() string {
+	if in.Name != "" {
+		return in.Name
+	}
+	if in.Path != "" {
+		return filepath.Base(in.Path)
+	}
+	return "memory"
+}
+
+// ErrInvalidInput 输入无法读取、不是有效的 PDF 或密码错误，LoadDocument 返回的 pdfium 错误都包装了它
+var ErrInvalidInput = errors.New("无法读取 PDF")
+
+// LoadDocument 按输入类型加载 PDF 文档，调用方负责 FPDF_CloseDocument
+func LoadDocument(instance pdfium.Pdfium, in PDFInput) (references.FPDF_DOCUMENT, error) {
+	switch {
+	case in.Path != "":
+		res, err := instance.FPDF_LoadDocument(&requests.FPDF_LoadDocument{
+			Path:     &in.Path,
+			Password: in.Password,
+		})
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		return res.Document, nil
+
+	case in.Data != nil:
+		res, err := instance.FPDF_LoadMemDocument(&requests.FPDF_LoadMemDocument{
+			Data:     &in.Data,
+			Password: in.Password,
+		})
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		return res.Document, nil
+
+	case in.Reader != nil:
+		size := in.Size
+		if size <= 0 {
+			end, err := in.Reader.Seek(0, io.SeekEnd)
+			if err != nil {
+				return "", fmt.Errorf("%w: 无法获取输入流长度: %v", ErrInvalidInput, err)
+			}
+			if _, err = in.Reader.Seek(0, io.SeekStart); err != nil {
+				return "", fmt.Errorf("%w: 无法重置输入流: %v", ErrInvalidInput, err)
+			}
+			size = end
+		}
+		res, err := instance.FPDF_LoadCustomDocument(&requests.FPDF_LoadCustomDocument{
+			Reader:   in.Reader,
+			Size:     size,
+			Password: in.Password,
+		})
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		return res.Document, nil
+	}
+
+	return "", errors.New("未指定 PDF 输入")
+}
+
+// SaveDocument 将文档保存到文件或 io.Writer
+func SaveDocument(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, out PDFOutput, flags requests.SaveFlags) error {
+	save := func(w io.Writer) error {
+		_, err := instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{
+			Document:   document,
+			Flags:      flags,
+			FileWriter: w,
+		})
+		return err
+	}
+
+	switch {
+	case out.Path != "":
+		return util.AtomicWriteFile(out.Path, util.AtomicWriteOptions{PreserveFrom: out.PreserveFrom}, save)
+	case out.Writer != nil:
+		return save(out.Writer)
+	}
+	return errors.New("未指定 PDF 输出")
+}
+
+// WriteOutput 将已生成的 PDF 数据写入文件或 io.Writer
+func WriteOutput(out PDFOutput, data []byte) error {
+	write := func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}
+
+	switch {
+	case out.Path != "":
+		return util.AtomicWriteFile(out.Path, util.AtomicWriteOptions{PreserveFrom: out.PreserveFrom}, write)
+	case out.Writer != nil:
+		return write(out.Writer)
+	}
+	return errors.New("未指定 PDF 输出")
+}
+
+// ImageSink 接收导出的图片，name 为带扩展名的文件名
+type ImageSink func(name string, data []byte) error
+
+// DirImageSink 将图片写入目录
+func DirImageSink(dir string) ImageSink {
+	return func(name string, data []byte) error {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, name), data, 0644)
+	}
+}
+
+// ZipImageSink 将图片写入 zip 包，调用方负责关闭返回的 zip.Writer
+func ZipImageSink(w io.Writer) (ImageSink, *zip.Writer) {
+	zw := zip.NewWriter(w)
+	return func(name string, data []byte) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   name,
+			Method: zip.Store, // 图片本身已压缩，无需再 deflate
+		})
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		return err
+	}, zw
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Phase 处理阶段
+type Phase string
+
+const (
+	PhaseExtract   Phase = "extract"   // 从 PDF 中提取图片
+	PhaseResample  Phase = "resample"  // 降低图片分辨率
+	PhaseEncode    Phase = "encode"    // 图片编码
+	PhaseWatermark Phase = "watermark" // 添加水印
+	PhaseSave      Phase = "save"      // 保存文档
+)
+
+// ProgressEvent 进度事件
+type ProgressEvent struct {
+	Phase          Phase `json:"phase"`
+	Page           int   `json:"page"`            // 当前页，从 1 开始
+	Pages          int   `json:"pages"`           // 总页数
+	Image          int   `json:"image"`           // 当前页中的第几张图片，从 1 开始，0 表示与图片无关
+	BytesProcessed int64 `json:"bytes_processed"` // 累计处理的图片数据字节数
+}
+
+func (e ProgressEvent) String() string {
+	if e.Image > 0 {
+		return fmt.Sprintf("%s page %d/%d image %d %dKB", e.Phase, e.Page, e.Pages, e.Image, e.BytesProcessed/1024)
+	}
+	return fmt.Sprintf("%s page %d/%d %dKB", e.Phase, e.Page, e.Pages, e.BytesProcessed/1024)
+}
+
+// ProgressFunc 进度回调，在处理协程中同步调用，不应阻塞
+type ProgressFunc func(ProgressEvent)
+
+type progressKey struct{}
+
+// WithProgress 返回携带进度回调的 ctx，压缩、提取、水印都会通过它上报进度
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// reportProgress 上报进度，ctx 中没有回调时什么也不做
+func reportProgress(ctx context.Context, e ProgressEvent) {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok && fn != nil {
+		fn(e)
+	}
+}
+
+// ProgressChan 将进度事件转发到 ch，ch 满时丢弃事件，避免拖慢处理
+func ProgressChan(ch chan<- ProgressEvent) ProgressFunc {
+	return func(e ProgressEvent) {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// ProgressBar 在终端上绘制单行进度条
+func ProgressBar(w io.Writer) ProgressFunc {
+	const width = 30
+
+	var mu sync.Mutex
+	return func(e ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		done := 0
+		if e.Pages > 0 {
+			done = e.Page * width / e.Pages
+		}
+		fmt.Fprintf(w, "\r[%s%s] %-50s", strings.Repeat("#", done), strings.Repeat(" ", width-done), e)
+		if e.Phase == PhaseSave {
+			fmt.Fprintln(w)
+		}
+	}
+}
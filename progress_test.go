@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancel(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	pdf := newTestPDF(t, processor, 3)
+	processor.Run(context.Background(), nil, nil)
+	instance, err := processor.pool.GetInstance(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	dir := t.TempDir()
+	logoPath := filepath.Join(t.TempDir(), "logo.png")
+	assert.Nil(t, os.WriteFile(logoPath, newTestLogo(t), 0644))
+
+	// cancelled 返回在第 1 页的第一个进度事件时取消的 ctx
+	cancelled := func() context.Context {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		return WithProgress(ctx, func(e ProgressEvent) {
+			assert.Equal(t, 1, e.Page)
+			cancel()
+		})
+	}
+
+	opts, _ := NewCompressOptions(60, 100, 0)
+	err = CompressImages(cancelled(), instance, PDFInput{Data: pdf}, PDFOutput{Path: filepath.Join(dir, "compressed.pdf")}, opts)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	var images []string
+	err = ExtractImagesTo(cancelled(), instance, PDFInput{Data: pdf}, func(name string, data []byte) error {
+		images = append(images, name)
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, images)
+
+	_, err = Watermark(cancelled(), instance, logoPath, PDFInput{Data: pdf}, PDFOutput{Path: filepath.Join(dir, "watermarked.pdf")}, DefaultWatermarkOptions())
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// 没有输出文件，也没有残留的临时文件
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries)
+
+	// 实例仍然可用
+	assert.Nil(t, CompressImages(context.Background(), instance, PDFInput{Data: pdf}, PDFOutput{Path: filepath.Join(dir, "compressed.pdf")}, opts))
+	assert.FileExists(t, filepath.Join(dir, "compressed.pdf"))
+}
+
+func TestProgress(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	const pages = 3
+	pdf := newTestPDF(t, processor, pages)
+	processor.Run(context.Background(), nil, nil)
+	instance, err := processor.pool.GetInstance(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	// check 检查事件顺序：同一张图片先提取后编码，最后一个事件是保存，页码和字节数不回退
+	check := func(events []ProgressEvent, phases ...Phase) {
+		if !assert.NotEmpty(t, events) {
+			return
+		}
+		last := events[len(events)-1]
+		assert.Equal(t, PhaseSave, last.Phase)
+		assert.Equal(t, pages, last.Page)
+
+		seen := map[Phase]int{}
+		extracted := map[[2]int]bool{}
+		var bytesProcessed int64
+		for i, e := range events {
+			seen[e.Phase]++
+			assert.Equal(t, pages, e.Pages)
+			assert.True(t, e.Page >= 1 && e.Page <= pages, "page %d", e.Page)
+			assert.GreaterOrEqual(t, e.BytesProcessed, bytesProcessed, "event %d %s", i, e)
+			bytesProcessed = e.BytesProcessed
+
+			image := [2]int{e.Page, e.Image}
+			switch e.Phase {
+			case PhaseExtract:
+				extracted[image] = true
+			case PhaseResample, PhaseEncode:
+				assert.True(t, extracted[image], "%s 之前没有提取", e)
+			}
+		}
+		assert.Equal(t, 1, seen[PhaseSave])
+		for _, phase := range phases {
+			assert.Equal(t, pages, seen[phase], "phase %s", phase)
+		}
+	}
+	record := func(events *[]ProgressEvent) context.Context {
+		return WithProgress(context.Background(), func(e ProgressEvent) {
+			*events = append(*events, e)
+		})
+	}
+
+	// 每页一张 288 DPI 的图片，降到 100 DPI 时每张都会 resample
+	var events []ProgressEvent
+	opts, _ := NewCompressOptions(60, 100, 0)
+	assert.Nil(t, CompressImages(record(&events), instance, PDFInput{Data: pdf}, PDFOutput{Writer: io.Discard}, opts))
+	check(events, PhaseExtract, PhaseResample, PhaseEncode)
+
+	// 不降低分辨率时没有 resample 事件
+	events = nil
+	opts, _ = NewCompressOptions(60, 0, 0)
+	assert.Nil(t, CompressImages(record(&events), instance, PDFInput{Data: pdf}, PDFOutput{Writer: io.Discard}, opts))
+	check(events, PhaseExtract, PhaseEncode)
+	for _, e := range events {
+		assert.NotEqual(t, PhaseResample, e.Phase)
+	}
+
+	events = nil
+	assert.Nil(t, ExtractImagesTo(record(&events), instance, PDFInput{Data: pdf}, func(name string, data []byte) error { return nil }))
+	check(events, PhaseExtract, PhaseEncode)
+	assert.Positive(t, events[len(events)-1].BytesProcessed)
+
+	events = nil
+	logoPath := filepath.Join(t.TempDir(), "logo.png")
+	assert.Nil(t, os.WriteFile(logoPath, newTestLogo(t), 0644))
+	_, err = Watermark(record(&events), instance, logoPath, PDFInput{Data: pdf}, PDFOutput{Writer: io.Discard}, DefaultWatermarkOptions())
+	assert.Nil(t, err)
+	check(events, PhaseWatermark)
+}
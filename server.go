@@ -0,0 +1,458 @@
+package main
+
+import (
+	"compress-pdfium/util"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klippa-app/go-pdfium"
+)
+
+// ServerConfig HTTP 服务配置
+type ServerConfig struct {
+	MaxUploadSize  int64         // 请求体的最大字节数，默认 100MB
+	RequestTimeout time.Duration // 单个请求的处理超时，默认 2 分钟
+	LogoPath       string        // 默认水印图片，请求中没有上传 logo 时使用
+	FontDir        string        // 文字水印可用的 TTF 字体目录，为空时只能用标准字体
+}
+
+// Server 提供压缩、提取图片、添加水印的 HTTP 接口，每个请求从批处理器的池中借出一个 pdfium 实例
+//
+//	POST /compress   返回压缩后的 PDF
+//	POST /extract    返回图片的 zip 包
+//	POST /watermark  返回添加水印后的 PDF
+//	GET  /health     健康检查
+//
+// PDF 可以作为整个请求体上传，也可以放在 multipart 的 file 字段中；
+// 参数通过 query（?quality=80&dpi=150）或 multipart 的 options 字段（JSON）传入，
+// 水印图片可以放在 multipart 的 logo 字段中，传入 text 参数时添加文字水印
+type Server struct {
+	processor *BatchProcessor
+	config    ServerConfig
+}
+
+func NewServer(processor *BatchProcessor, config ServerConfig) *Server {
+	if config.MaxUploadSize <= 0 {
+		config.MaxUploadSize = 100 << 20
+	}
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = time.Minute * 2
+	}
+	return &Server{processor: processor, config: config}
+}
+
+// Handler 返回注册好所有接口的 http.Handler
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.health)
+	for name, kind := range s.kinds() {
+		mux.HandleFunc("/"+name, s.handle(kind))
+	}
+	return mux
+}
+
+// jobKind 一种处理接口：结果类型和处理函数
+type jobKind struct {
+	contentType string
+	ext         string
+	job         jobFunc
+}
+
+// kinds 返回所有处理接口，同步接口和异步任务共用
+func (s *Server) kinds() map[string]jobKind {
+	return map[string]jobKind{
+		"compress":  {contentType: "application/pdf", ext: ".pdf", job: s.compressJob},
+		"extract":   {contentType: "application/zip", ext: ".zip", job: s.extractJob},
+		"watermark": {contentType: "application/pdf", ext: ".pdf", job: s.watermarkJob},
+	}
+}
+
+// RequestOptions 请求参数，未传入的字段保持默认值
+type RequestOptions struct {
+	Quality  int     `json:"quality"`   // JPEG 压缩质量，默认 90
+	DPI      float64 `json:"dpi"`       // 降低分辨率后的目标 DPI，默认 120，0 表示不降低分辨率
+	AboveDPI float64 `json:"above_dpi"` // 水平 DPI 高于该值的图片才降低分辨率，默认与 dpi 相同
+	Password string  `json:"password"`  // 文档密码
+
+	// 水印的位置、大小和外观，文字水印的内容和样式，JSON 中与上面的字段平铺在一起
+	WatermarkOptions
+	TextOptions
+}
+
+func defaultRequestOptions() RequestOptions {
+	return RequestOptions{
+		Quality: 90,
+		DPI:     DPIRecommend,
+
+		WatermarkOptions: DefaultWatermarkOptions(),
+		TextOptions:      DefaultTextOptions(),
+	}
+}
+
+// parseQuery 用 query 中出现的参数覆盖默认值
+func (o *RequestOptions) parseQuery(query url.Values) error {
+	var err error
+	for key, values := range query {
+		value := values[len(values)-1]
+		switch key {
+		case "quality":
+			o.Quality, err = strconv.Atoi(value)
+		case "dpi":
+			o.DPI, err = strconv.ParseFloat(value, 64)
+		case "above_dpi":
+			o.AboveDPI, err = strconv.ParseFloat(value, 64)
+		case "anchor":
+			o.Anchor, err = ParseAnchor(value)
+		case "margin_x":
+			o.MarginX, err = ParseLength(value)
+		case "margin_y":
+			o.MarginY, err = ParseLength(value)
+		case "size":
+			o.Size, err = strconv.ParseFloat(value, 64)
+		case "rotation":
+			o.Rotation, err = strconv.ParseFloat(value, 64)
+		case "opacity":
+			o.Opacity, err = strconv.ParseFloat(value, 64)
+		case "z_order":
+			o.ZOrder = ZOrder(value)
+		case "tile":
+			o.Tile, err = strconv.ParseBool(value)
+		case "spacing_x":
+			o.SpacingX, err = ParseLength(value)
+		case "spacing_y":
+			o.SpacingY, err = ParseLength(value)
+		case "stagger":
+			o.Stagger, err = strconv.ParseBool(value)
+		case "id":
+			o.ID = value
+		case "auto":
+			o.Auto, err = strconv.ParseBool(value)
+		case "candidates":
+			o.Candidates, err = parseAnchors(value)
+		case "contrast":
+			o.Contrast = ContrastMode(value)
+		case "min_contrast":
+			o.MinContrast, err = strconv.ParseFloat(value, 64)
+		case "contrast_color":
+			o.ContrastColor, err = ParseColor(value)
+		case "plate_padding":
+			o.PlatePadding, err = ParseLength(value)
+		case "outline_width":
+			o.OutlineWidth, err = strconv.ParseFloat(value, 64)
+		case "text":
+			o.Text = value
+		case "font":
+			o.Font = value
+		case "font_size":
+			o.FontSize, err = strconv.ParseFloat(value, 64)
+		case "color":
+			o.Color, err = ParseColor(value)
+		case "stroke_color":
+			o.StrokeColor, err = ParseColor(value)
+		case "stroke_width":
+			o.StrokeWidth, err = strconv.ParseFloat(value, 64)
+		case "line_spacing":
+			o.LineSpacing, err = strconv.ParseFloat(value, 64)
+		case "password":
+			o.Password = value
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("参数 %s 无效: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// upload 解析后的请求
+type upload struct {
+	name     string
+	pdf      []byte
+	logo     []byte
+	logoPath string // 已落盘的 logo，优先于 logo
+	opts     RequestOptions
+}
+
+func (u *upload) input() PDFInput {
+	in := PDFInput{Data: u.pdf, Name: u.name}
+	if u.opts.Password != "" {
+		in.Password = &u.opts.Password
+	}
+	return in
+}
+
+// requestError 请求本身有问题，返回对应的 4xx 状态码
+type requestError struct {
+	status int
+	err    error
+}
+
+func (e requestError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, a ...interface{}) error {
+	return requestError{status: http.StatusBadRequest, err: fmt.Errorf(format, a...)}
+}
+
+// readUpload 读取请求中的 PDF、水印图片和参数，multipart 按字段流式读取，不落盘
+func readUpload(r *http.Request) (*upload, error) {
+	u := &upload{name: "upload.pdf", opts: defaultRequestOptions()}
+	if err := u.opts.parseQuery(r.URL.Query()); err != nil {
+		return nil, badRequest("%v", err)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, readError(err)
+		}
+		u.pdf = data
+	} else {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			return nil, badRequest("无法解析 multipart: %v", err)
+		}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, readError(err)
+			}
+			if err = u.readPart(part); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(u.pdf) == 0 {
+		return nil, badRequest("没有上传 PDF")
+	}
+	return u, nil
+}
+
+func (u *upload) readPart(part *multipart.Part) error {
+	defer part.Close()
+
+	data, err := io.ReadAll(part)
+	if err != nil {
+		return readError(err)
+	}
+
+	switch part.FormName() {
+	case "file":
+		u.pdf = data
+		if part.FileName() != "" {
+			u.name = filepath.Base(part.FileName())
+		}
+	case "logo":
+		u.logo = data
+	case "options":
+		if err = json.Unmarshal(data, &u.opts); err != nil {
+			return badRequest("options 不是有效的 JSON: %v", err)
+		}
+	}
+	return nil
+}
+
+// readError 读取请求体失败，超过大小限制时返回 413
+func readError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return requestError{status: http.StatusRequestEntityTooLarge, err: fmt.Errorf("请求超过 %d 字节", maxBytesErr.Limit)}
+	}
+	return badRequest("无法读取请求: %v", err)
+}
+
+// jobFunc 根据请求生成处理函数，返回的 cleanup 在处理完成后调用
+type jobFunc func(u *upload) (fn BatchFunc, cleanup func(), err error)
+
+func (s *Server) compressJob(u *upload) (BatchFunc, func(), error) {
+	opts, err := NewCompressOptions(u.opts.Quality, u.opts.DPI, u.opts.AboveDPI)
+	if err != nil {
+		return nil, nil, badRequest("%v", err)
+	}
+	return CompressJob(opts), func() {}, nil
+}
+
+func (s *Server) extractJob(u *upload) (BatchFunc, func(), error) {
+	return func(ctx context.Context, instance pdfium.Pdfium, job BatchJob) error {
+		if job.Output.Path != "" {
+			// 异步任务写入文件
+			return util.AtomicWriteFile(job.Output.Path, util.AtomicWriteOptions{}, func(w io.Writer) error {
+				return ExtractImagesToZip(ctx, instance, job.Input, w)
+			})
+		}
+		return ExtractImagesToZip(ctx, instance, job.Input, job.Output.Writer)
+	}, func() {}, nil
+}
+
+func (s *Server) watermarkJob(u *upload) (BatchFunc, func(), error) {
+	opts := u.opts.WatermarkOptions
+	if err := opts.Validate(); err != nil {
+		return nil, nil, badRequest("%v", err)
+	}
+
+	if u.opts.Text != "" {
+		text := u.opts.TextOptions
+		if !IsStandardFont(text.Font) {
+			// 只能使用配置目录中的字体，不能读取服务器上的任意文件
+			if s.config.FontDir == "" {
+				return nil, nil, badRequest("服务没有配置字体目录，只能使用标准字体: %s", text.Font)
+			}
+			text.Font = filepath.Join(s.config.FontDir, filepath.Base(text.Font))
+			if _, err := os.Stat(text.Font); err != nil {
+				return nil, nil, badRequest("字体不存在: %s", filepath.Base(text.Font))
+			}
+		}
+		if err := text.Validate(); err != nil {
+			return nil, nil, badRequest("%v", err)
+		}
+		return func(ctx context.Context, instance pdfium.Pdfium, job BatchJob) error {
+			_, err := WatermarkText(ctx, instance, job.Input, job.Output, text, opts)
+			return err
+		}, func() {}, nil
+	}
+
+	logoPath, cleanup := s.config.LogoPath, func() {}
+	if u.logoPath != "" {
+		logoPath = u.logoPath
+	} else if u.logo != nil {
+		// 水印函数按路径读取图片，上传的 logo 先写入临时文件
+		f, err := os.CreateTemp("", "logo-*")
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = f.Write(u.logo)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return nil, nil, err
+		}
+		logoPath, cleanup = f.Name(), func() { os.Remove(f.Name()) }
+	}
+	if logoPath == "" {
+		return nil, nil, badRequest("没有上传 logo，服务也没有配置默认水印")
+	}
+
+	return func(ctx context.Context, instance pdfium.Pdfium, job BatchJob) error {
+		_, err := Watermark(ctx, instance, logoPath, job.Input, job.Output, opts)
+		return err
+	}, cleanup, nil
+}
+
+// handle 解析请求、借出 pdfium 实例处理，并把结果流式写回
+func (s *Server) handle(kind jobKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, requestError{status: http.StatusMethodNotAllowed, err: errors.New("只支持 POST")})
+			return
+		}
+
+		beginTime := time.Now()
+		r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxUploadSize)
+
+		u, err := readUpload(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		fn, cleanup, err := kind.job(u)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer cleanup()
+
+		ctx, cancel := context.WithTimeout(r.Context(), s.config.RequestTimeout)
+		defer cancel()
+
+		filename := strings.TrimSuffix(u.name, filepath.Ext(u.name)) + kind.ext
+		stream := &responseStream{w: w, contentType: kind.contentType, filename: filename}
+		res := s.processor.process(ctx, BatchJob{
+			Name:   u.name,
+			Input:  u.input(),
+			Output: PDFOutput{Writer: stream},
+		}, fn)
+
+		if res.Err != nil {
+			log.Printf("%s %s 失败: %v", r.URL.Path, u.name, res.Err)
+			if stream.started {
+				// 响应头已发出，只能中断连接，让客户端知道结果不完整
+				panic(http.ErrAbortHandler)
+			}
+			writeError(w, res.Err)
+			return
+		}
+
+		log.Printf("%s %s %dKB -> %dKB，耗时 %s", r.URL.Path, u.name, len(u.pdf)/1024, stream.written/1024, time.Since(beginTime).Round(time.Millisecond))
+	}
+}
+
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// responseStream 第一次写入时才发出响应头，写入之前出错仍可以返回错误状态码
+type responseStream struct {
+	w           http.ResponseWriter
+	contentType string
+	filename    string
+	started     bool
+	written     int64
+}
+
+func (s *responseStream) Write(p []byte) (int, error) {
+	if !s.started {
+		s.started = true
+		s.w.Header().Set("Content-Type", s.contentType)
+		s.w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": s.filename}))
+		s.w.WriteHeader(http.StatusOK)
+	}
+	n, err := s.w.Write(p)
+	s.written += int64(n)
+	return n, err
+}
+
+// writeError 按错误类型返回状态码和 JSON 错误信息
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var reqErr requestError
+	switch {
+	case errors.As(err, &reqErr):
+		status = reqErr.status
+	case errors.Is(err, ErrInvalidInput):
+		status = http.StatusUnprocessableEntity
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		// 客户端已断开
+		status = 499
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
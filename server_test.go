@@ -0,0 +1,235 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestPDF 生成每页一张大 JPEG 的 PDF
+func newTestPDF(t *testing.T, processor *BatchProcessor, pages int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 1200, 1600))
+	for y := 0; y < 1600; y++ {
+		for x := 0; x < 1200; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: uint8(x ^ y), A: 255})
+		}
+	}
+	return newTestPDFWithImage(t, processor, pages, img)
+}
+
+// newTestPDFWithImage 生成每页放置 img 的 PDF
+func newTestPDFWithImage(t *testing.T, processor *BatchProcessor, pages int, img image.Image) []byte {
+	instance, err := processor.pool.GetInstance(time.Second * 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	doc, err := instance.FPDF_CreateNewDocument(&requests.FPDF_CreateNewDocument{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{Document: doc.Document})
+
+	var jpegData bytes.Buffer
+	if err = jpeg.Encode(&jpegData, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < pages; i++ {
+		page, err := instance.FPDFPage_New(&requests.FPDFPage_New{Document: doc.Document, PageIndex: i, Width: 595, Height: 842})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageRef := requests.Page{ByReference: &page.Page}
+
+		obj, err := instance.FPDFPageObj_NewImageObj(&requests.FPDFPageObj_NewImageObj{Document: doc.Document})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = instance.FPDFImageObj_LoadJpegFileInline(&requests.FPDFImageObj_LoadJpegFileInline{
+			ImageObject: obj.PageObject,
+			FileData:    jpegData.Bytes(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		instance.FPDFImageObj_SetMatrix(&requests.FPDFImageObj_SetMatrix{
+			ImageObject: obj.PageObject,
+			Transform:   structs.FPDF_FS_MATRIX{A: 300, D: 400, E: 50, F: 50},
+		})
+		instance.FPDFPage_InsertObject(&requests.FPDFPage_InsertObject{Page: pageRef, PageObject: obj.PageObject})
+		instance.FPDFPage_GenerateContent(&requests.FPDFPage_GenerateContent{Page: pageRef})
+		instance.FPDF_ClosePage(&requests.FPDF_ClosePage{Page: page.Page})
+	}
+
+	var out bytes.Buffer
+	if _, err = instance.FPDF_SaveAsCopy(&requests.FPDF_SaveAsCopy{Document: doc.Document, FileWriter: &out}); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func newTestLogo(t *testing.T) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.NRGBA{R: 200, A: uint8(x * 4)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// multipartBody 按 字段名->内容 生成 multipart 请求体，file、logo 作为文件上传
+func multipartBody(t *testing.T, fields map[string][]byte) (io.Reader, string) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, data := range fields {
+		var w io.Writer
+		var err error
+		if name == "options" {
+			w, err = mw.CreateFormField(name)
+		} else {
+			w, err = mw.CreateFormFile(name, name+".bin")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	mw.Close()
+	return &body, mw.FormDataContentType()
+}
+
+func TestServer(t *testing.T) {
+	// webassembly 不依赖系统中的 libpdfium
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	pdf := newTestPDF(t, processor, 2)
+	logo := newTestLogo(t)
+
+	server := httptest.NewServer(NewServer(processor, ServerConfig{MaxUploadSize: 4 << 20}).Handler())
+	defer server.Close()
+
+	post := func(path, contentType string, body io.Reader) (*http.Response, []byte) {
+		res, err := http.Post(server.URL+path, contentType, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, data
+	}
+
+	t.Run("health", func(t *testing.T) {
+		res, err := http.Get(server.URL + "/health")
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		res.Body.Close()
+	})
+
+	t.Run("compress raw", func(t *testing.T) {
+		res, data := post("/compress?quality=60&dpi=100", "application/pdf", bytes.NewReader(pdf))
+		assert.Equal(t, http.StatusOK, res.StatusCode, string(data))
+		assert.Equal(t, "application/pdf", res.Header.Get("Content-Type"))
+		assert.True(t, bytes.HasPrefix(data, []byte("%PDF")))
+		assert.Less(t, len(data), len(pdf))
+	})
+
+	t.Run("compress multipart", func(t *testing.T) {
+		body, contentType := multipartBody(t, map[string][]byte{
+			"file":    pdf,
+			"options": []byte(`{"quality": 50, "dpi": 0}`),
+		})
+		res, data := post("/compress", contentType, body)
+		assert.Equal(t, http.StatusOK, res.StatusCode, string(data))
+		assert.Contains(t, res.Header.Get("Content-Disposition"), "file.pdf")
+		assert.True(t, bytes.HasPrefix(data, []byte("%PDF")))
+	})
+
+	t.Run("extract", func(t *testing.T) {
+		res, data := post("/extract", "application/pdf", bytes.NewReader(pdf))
+		assert.Equal(t, http.StatusOK, res.StatusCode, string(data))
+		assert.Equal(t, "application/zip", res.Header.Get("Content-Type"))
+
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		assert.Nil(t, err)
+		assert.Len(t, zr.File, 2)
+	})
+
+	t.Run("watermark", func(t *testing.T) {
+		body, contentType := multipartBody(t, map[string][]byte{"file": pdf, "logo": logo})
+		res, data := post("/watermark", contentType, body)
+		assert.Equal(t, http.StatusOK, res.StatusCode, string(data))
+		assert.True(t, bytes.HasPrefix(data, []byte("%PDF")))
+
+		// 文字水印不需要 logo
+		res, data = post("/watermark?text=DRAFT&anchor=center&rotation=45&opacity=0.3", "application/pdf", bytes.NewReader(pdf))
+		assert.Equal(t, http.StatusOK, res.StatusCode, string(data))
+		assert.True(t, bytes.HasPrefix(data, []byte("%PDF")))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		res, err := http.Get(server.URL + "/compress")
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+		res.Body.Close()
+
+		res, data := post("/compress", "application/pdf", bytes.NewReader(make([]byte, 5<<20)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode, string(data))
+
+		res, data = post("/compress", "application/pdf", strings.NewReader("not a pdf"))
+		assert.Equal(t, http.StatusUnprocessableEntity, res.StatusCode, string(data))
+
+		res, data = post("/compress?quality=0", "application/pdf", bytes.NewReader(pdf))
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, string(data))
+
+		res, data = post("/compress", "application/pdf", nil)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, string(data))
+
+		res, data = post("/watermark?text=DRAFT&font=/etc/passwd", "application/pdf", bytes.NewReader(pdf))
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, string(data))
+
+		res, data = post("/watermark", "application/pdf", bytes.NewReader(pdf))
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, string(data))
+
+		var body map[string]string
+		assert.Nil(t, json.Unmarshal(data, &body))
+		assert.NotEmpty(t, body["error"])
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		timeoutServer := httptest.NewServer(NewServer(processor, ServerConfig{RequestTimeout: time.Nanosecond}).Handler())
+		defer timeoutServer.Close()
+
+		res, err := http.Post(timeoutServer.URL+"/compress", "application/pdf", bytes.NewReader(pdf))
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusGatewayTimeout, res.StatusCode)
+		res.Body.Close()
+	})
+}
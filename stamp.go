@@ -0,0 +1,344 @@
+package main
+
+import (
+	"compress-pdfium/util"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+// StampContext 渲染印章模板时的页面信息
+type StampContext struct {
+	Page     int // 从 1 开始
+	Pages    int
+	Bates    int // 本页的 Bates 编号
+	Date     time.Time
+	Filename string
+}
+
+// stampPart 模板的一段，Field 为空时是普通文字
+type stampPart struct {
+	Literal string
+	Field   string
+	Arg     string
+}
+
+// StampTemplate 解析后的印章模板
+type StampTemplate struct {
+	parts []stampPart
+}
+
+// ParseStampTemplate 解析印章模板，支持的占位符：
+//
+//	{page}            当前页码，{page:3} 补零到 3 位
+//	{pages}           总页数，同样可以指定位数
+//	{bates}           Bates 编号，{bates:6} 补零到 6 位，如 ACME-{bates:6}
+//	{date}            日期，默认格式 2006-01-02，{date:2006年01月02日} 指定 Go 时间格式
+//	{filename}        输入文件名
+//
+// {{ 和 }} 表示字面的 { 和 }
+func ParseStampTemplate(s string) (*StampTemplate, error) {
+	t := &StampTemplate{}
+	var literal strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"), strings.HasPrefix(s[i:], "}}"):
+			literal.WriteByte(s[i])
+			i++
+		case s[i] == '}':
+			return nil, fmt.Errorf("模板 %q 中有多余的 }，字面的 } 请写为 }}", s)
+		case s[i] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("模板 %q 中的 { 没有对应的 }", s)
+			}
+			field, arg, _ := strings.Cut(s[i+1:i+end], ":")
+			if err := checkStampField(field, arg); err != nil {
+				return nil, err
+			}
+			if literal.Len() > 0 {
+				t.parts = append(t.parts, stampPart{Literal: literal.String()})
+				literal.Reset()
+			}
+			t.parts = append(t.parts, stampPart{Field: field, Arg: arg})
+			i += end
+		default:
+			literal.WriteByte(s[i])
+		}
+	}
+	if literal.Len() > 0 {
+		t.parts = append(t.parts, stampPart{Literal: literal.String()})
+	}
+	return t, nil
+}
+
+// checkStampField 检查占位符名称和参数
+func checkStampField(field, arg string) error {
+	switch field {
+	case "page", "pages", "bates":
+		if arg == "" {
+			return nil
+		}
+		if width, err := strconv.Atoi(arg); err != nil || width < 1 || width > 20 {
+			return fmt.Errorf("{%s:%s} 的位数应为 1-20", field, arg)
+		}
+	case "date":
+	case "filename":
+		if arg != "" {
+			return fmt.Errorf("{filename} 不支持参数: %s", arg)
+		}
+	default:
+		return fmt.Errorf("未知的占位符 {%s}，可选 page、pages、bates、date、filename", field)
+	}
+	return nil
+}
+
+// formatNumber 按位数补零
+func formatNumber(n int, width string) string {
+	if width == "" {
+		return strconv.Itoa(n)
+	}
+	w, _ := strconv.Atoi(width)
+	return fmt.Sprintf("%0*d", w, n)
+}
+
+// Render 用页面信息替换占位符
+func (t *StampTemplate) Render(c StampContext) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		switch p.Field {
+		case "":
+			b.WriteString(p.Literal)
+		case "page":
+			b.WriteString(formatNumber(c.Page, p.Arg))
+		case "pages":
+			b.WriteString(formatNumber(c.Pages, p.Arg))
+		case "bates":
+			b.WriteString(formatNumber(c.Bates, p.Arg))
+		case "date":
+			layout := p.Arg
+			if layout == "" {
+				layout = "2006-01-02"
+			}
+			b.WriteString(c.Date.Format(layout))
+		case "filename":
+			b.WriteString(c.Filename)
+		}
+	}
+	return b.String()
+}
+
+// StampSpec 一个印章：TextOptions.Text 为模板，位置和外观见 WatermarkOptions
+type StampSpec struct {
+	TextOptions
+	WatermarkOptions
+}
+
+// DefaultStampSpec 10pt 黑色 Helvetica，距页边 36pt、24pt，放在页面底部居中
+func DefaultStampSpec(template string) StampSpec {
+	spec := StampSpec{TextOptions: DefaultTextOptions(), WatermarkOptions: DefaultWatermarkOptions()}
+	spec.Text, spec.FontSize, spec.Color = template, 10, Color{0, 0, 0, 255}
+	spec.Anchor, spec.MarginX, spec.MarginY = AnchorBottom, Length{Value: 36}, Length{Value: 24}
+	return spec
+}
+
+// StampOptions 印章参数
+type StampOptions struct {
+	Stamps     []StampSpec
+	Barcodes   []BarcodeSpec
+	BatesStart int       // 第一页的 Bates 编号，默认 1
+	Date       time.Time // {date} 使用的时间，默认为当前时间，同一批文件使用同一个时间
+	Filename   string    // {filename}，为空时取输入文件名
+	ID         string    // 水印标识，再次添加时替换同一标识的旧印章，默认 stamp。各印章自身的 ID 不使用
+}
+
+// Validate 解析模板并检查参数，返回解析后的模板，依次为 Stamps 和 Barcodes 的模板
+func (o *StampOptions) Validate() ([]*StampTemplate, error) {
+	if len(o.Stamps) == 0 && len(o.Barcodes) == 0 {
+		return nil, errors.New("没有指定印章或条码")
+	}
+	if o.BatesStart < 0 {
+		return nil, fmt.Errorf("Bates 起始编号不能小于 0: %d", o.BatesStart)
+	}
+	if o.BatesStart == 0 {
+		o.BatesStart = 1
+	}
+	if o.Date.IsZero() {
+		o.Date = time.Now()
+	}
+	if o.ID == "" {
+		o.ID = WatermarkIDStamp
+	}
+
+	templates := make([]*StampTemplate, len(o.Stamps), len(o.Stamps)+len(o.Barcodes))
+	for i := range o.Stamps {
+		spec := &o.Stamps[i]
+		if spec.FontSize <= 0 {
+			return nil, fmt.Errorf("印章 %q 必须指定字号", spec.Text)
+		}
+		// 印章不平铺，总在内容上方
+		spec.Tile, spec.ZOrder = false, ZOrderFront
+		if err := spec.TextOptions.Validate(); err != nil {
+			return nil, err
+		}
+		if err := spec.WatermarkOptions.Validate(); err != nil {
+			return nil, err
+		}
+		var err error
+		if templates[i], err = ParseStampTemplate(spec.Text); err != nil {
+			return nil, err
+		}
+	}
+	for i := range o.Barcodes {
+		spec := &o.Barcodes[i]
+		if err := spec.Validate(); err != nil {
+			return nil, err
+		}
+		template, err := ParseStampTemplate(spec.Data)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+// layerGroup 把多个水印层合并为一个，依次生成各层的对象
+type layerGroup []watermarkLayer
+
+func (g layerGroup) objects(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, page *watermarkPage, index int) ([]references.FPDF_PAGEOBJECT, error) {
+	var objects []references.FPDF_PAGEOBJECT
+	for _, l := range g {
+		layerObjects, err := l.objects(instance, document, page, index)
+		if err != nil {
+			destroyObjects(instance, objects)
+			return nil, err
+		}
+		objects = append(objects, layerObjects...)
+	}
+	return objects, nil
+}
+
+func (g layerGroup) close(instance pdfium.Pdfium) {
+	for _, l := range g {
+		l.close(instance)
+	}
+}
+
+// AddStamps 在每一页添加按模板生成的页码、Bates 编号、日期等文字和条码，返回页数，
+// 下一个文件的 Bates 编号从 BatesStart+页数 开始
+func AddStamps(ctx context.Context, instance pdfium.Pdfium, in PDFInput, out PDFOutput, opts StampOptions) (int, error) {
+	templates, err := opts.Validate()
+	if err != nil {
+		return 0, err
+	}
+	if opts.Filename == "" {
+		opts.Filename = filepath.Base(in.name())
+	}
+
+	pages := 0
+	_, err = applyWatermark(ctx, instance, in, out, ZOrderFront, opts.ID, func(document references.FPDF_DOCUMENT) (watermarkLayer, error) {
+		pageCount, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
+			Document: document,
+		})
+		if err != nil {
+			return nil, err
+		}
+		pages = pageCount.PageCount
+
+		// content 返回第 i 个模板在某一页的内容
+		content := func(i int) func(index int) string {
+			template := templates[i]
+			return func(index int) string {
+				return template.Render(StampContext{
+					Page:     index + 1,
+					Pages:    pages,
+					Bates:    opts.BatesStart + index,
+					Date:     opts.Date,
+					Filename: opts.Filename,
+				})
+			}
+		}
+
+		var group layerGroup
+		for i, spec := range opts.Stamps {
+			layer, err := newTextLayer(instance, document, spec.TextOptions, spec.WatermarkOptions, content(i))
+			if err != nil {
+				group.close(instance)
+				return nil, err
+			}
+			group = append(group, layer)
+		}
+		for i, spec := range opts.Barcodes {
+			group = append(group, &barcodeLayer{spec: spec, content: content(len(opts.Stamps) + i)})
+		}
+		return group, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pages, nil
+}
+
+// BatesRange 一个文件使用的 Bates 编号，First 到 Last 含两端
+type BatesRange struct {
+	Path  string `json:"path"`
+	First int    `json:"first"`
+	Last  int    `json:"last"`
+}
+
+func (r BatesRange) String() string {
+	return fmt.Sprintf("%s: Bates %d-%d", r.Path, r.First, r.Last)
+}
+
+// StampDir 按文件名顺序处理 inputDir 下的所有 PDF，Bates 编号在文件之间连续，
+// 返回每个已处理文件的编号范围和下一个 Bates 编号。某个文件失败时停止，避免编号出现空缺。
+// outputDir 不能与 inputDir 相同，在 inputDir 之内时其中的文件不作为输入
+func StampDir(ctx context.Context, instance pdfium.Pdfium, inputDir, outputDir string, opts StampOptions) ([]BatesRange, int, error) {
+	if _, err := opts.Validate(); err != nil {
+		return nil, 0, err
+	}
+	inputDir, outputDir, err := resolveDirs(inputDir, outputDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	var ranges []BatesRange
+	next := opts.BatesStart
+
+	for _, inputPath := range util.GetFilePath(inputDir, ".pdf") {
+		if err := ctx.Err(); err != nil {
+			return ranges, next, err
+		}
+		// 输出目录在输入目录之内时，上次的输出不再编号
+		if insideDir(inputPath, outputDir) {
+			continue
+		}
+
+		outputPath, err := util.MirrorPath(inputDir, outputDir, inputPath)
+		if err != nil {
+			return ranges, next, err
+		}
+		if err = os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+			return ranges, next, err
+		}
+
+		fileOpts := opts
+		fileOpts.BatesStart = next
+		pages, err := AddStamps(ctx, instance, PDFInput{Path: inputPath}, PDFOutput{Path: outputPath}, fileOpts)
+		if err != nil {
+			return ranges, next, fmt.Errorf("%s: %w", inputPath, err)
+		}
+		ranges = append(ranges, BatesRange{Path: inputPath, First: next, Last: next + pages - 1})
+		next += pages
+	}
+	return ranges, next, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"compress-pdfium/util"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStampTemplate(t *testing.T) {
+	c := StampContext{
+		Page:     3,
+		Pages:    12,
+		Bates:    42,
+		Date:     time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC),
+		Filename: "合同.pdf",
+	}
+
+	tpl, err := ParseStampTemplate("第 {page} 页 共 {pages} 页")
+	assert.Nil(t, err)
+	assert.Equal(t, "第 3 页 共 12 页", tpl.Render(c))
+
+	tpl, err = ParseStampTemplate("ACME-{bates:6} {page:3}/{pages}")
+	assert.Nil(t, err)
+	assert.Equal(t, "ACME-000042 003/12", tpl.Render(c))
+
+	tpl, err = ParseStampTemplate("{filename} {date} {date:2006年01月02日}")
+	assert.Nil(t, err)
+	assert.Equal(t, "合同.pdf 2024-05-06 2024年05月06日", tpl.Render(c))
+
+	tpl, err = ParseStampTemplate("{{page}} }}")
+	assert.Nil(t, err)
+	assert.Equal(t, "{page} }", tpl.Render(c))
+
+	for _, s := range []string{"{page", "page}", "{author}", "{bates:x}", "{bates:0}", "{filename:3}"} {
+		_, err = ParseStampTemplate(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+// pageTexts 返回每一页的文字
+func pageTexts(t *testing.T, instance pdfium.Pdfium, data []byte) []string {
+	doc, err := LoadDocument(instance, PDFInput{Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{Document: doc})
+	structure, err := ReadStructure(context.Background(), instance, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, p := range structure.Pages {
+		texts = append(texts, p.Text)
+	}
+	return texts
+}
+
+func TestAddStamps(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	pdf := newTestPDF(t, processor, 2)
+	processor.Run(context.Background(), nil, nil)
+	instance, err := processor.pool.GetInstance(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	footer := DefaultStampSpec("Page {page} of {pages}")
+	footer.Color = Color{255, 0, 0, 255}
+	bates := DefaultStampSpec("ACME-{bates:6}")
+	bates.Anchor = AnchorBottomRight
+	opts := StampOptions{Stamps: []StampSpec{footer, bates}, BatesStart: 41}
+
+	var out bytes.Buffer
+	pages, err := AddStamps(context.Background(), instance, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, pages)
+
+	texts := pageTexts(t, instance, out.Bytes())
+	assert.Len(t, texts, 2)
+	assert.Contains(t, texts[0], "Page 1 of 2")
+	assert.Contains(t, texts[0], "ACME-000041")
+	assert.Contains(t, texts[1], "Page 2 of 2")
+	assert.Contains(t, texts[1], "ACME-000042")
+
+	// 页脚在页面底部居中，距下边缘 24pt
+	bounds, _ := redBounds(renderFirstPage(t, instance, out.Bytes()), 0)
+	assert.InDelta(t, 595/2, (bounds.Min.X+bounds.Max.X)/2, 2)
+	assert.InDelta(t, 842-24, bounds.Max.Y, 2)
+	assert.Less(t, bounds.Dy(), 12)
+
+	// 未知占位符在处理前报错
+	out.Reset()
+	_, err = AddStamps(context.Background(), instance, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, StampOptions{Stamps: []StampSpec{DefaultStampSpec("{author}")}})
+	assert.NotNil(t, err)
+	assert.Zero(t, out.Len())
+
+	// 目录中的文件按文件名顺序连续编号
+	inputDir, outputDir := t.TempDir(), t.TempDir()
+	for _, name := range []string{"a.pdf", "b.pdf"} {
+		assert.Nil(t, os.WriteFile(filepath.Join(inputDir, name), pdf, 0644))
+	}
+	ranges, next, err := StampDir(context.Background(), instance, inputDir, outputDir, StampOptions{Stamps: []StampSpec{DefaultStampSpec("{filename} ACME-{bates:4}")}})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, next)
+	assert.Equal(t, []BatesRange{
+		{Path: filepath.Join(inputDir, "a.pdf"), First: 1, Last: 2},
+		{Path: filepath.Join(inputDir, "b.pdf"), First: 3, Last: 4},
+	}, ranges)
+	data, err := os.ReadFile(filepath.Join(outputDir, "b.pdf"))
+	assert.Nil(t, err)
+	texts = pageTexts(t, instance, data)
+	assert.True(t, strings.Contains(texts[0], "b.pdf ACME-0003"), texts[0])
+	assert.True(t, strings.Contains(texts[1], "b.pdf ACME-0004"), texts[1])
+
+	// 输出目录在输入目录之内时，重新运行不给上次的输出编号
+	outputDir = filepath.Join(inputDir, "out")
+	for i := 0; i < 2; i++ {
+		ranges, next, err = StampDir(context.Background(), instance, inputDir, outputDir, StampOptions{Stamps: []StampSpec{DefaultStampSpec("ACME-{bates:4}")}})
+		assert.Nil(t, err)
+		assert.Equal(t, 5, next)
+		assert.Len(t, ranges, 2)
+	}
+	entries, _ := os.ReadDir(outputDir)
+	assert.Len(t, entries, 2)
+
+	// 输出目录与输入目录相同时不处理，不覆盖原文件
+	_, _, err = StampDir(context.Background(), instance, inputDir, inputDir, StampOptions{Stamps: []StampSpec{DefaultStampSpec("ACME-{bates:4}")}})
+	assert.ErrorIs(t, err, errSameDir)
+	data, _ = os.ReadFile(filepath.Join(inputDir, "a.pdf"))
+	assert.Equal(t, pdf, data)
+}
+
+func TestStampBarcode(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	pdf := newTestPDF(t, processor, 2)
+	processor.Run(context.Background(), nil, nil)
+	instance, err := processor.pool.GetInstance(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	qr := DefaultBarcodeSpec(BarcodeQR, "DOC-42/{page}/{pages}")
+	code128 := DefaultBarcodeSpec(BarcodeCode128, "DOC-{bates:6}")
+	code128.Anchor = AnchorTopRight
+	var out bytes.Buffer
+	_, err = AddStamps(context.Background(), instance, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, StampOptions{})
+	assert.NotNil(t, err)
+	_, err = AddStamps(context.Background(), instance, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, StampOptions{Barcodes: []BarcodeSpec{qr, code128}, BatesStart: 7})
+	assert.Nil(t, err)
+
+	// 以 288 DPI 渲染，逐个模块取中心点与编码结果比对
+	doc, err := LoadDocument(instance, PDFInput{Data: out.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{Document: doc})
+	img, err := RenderPage(instance, doc, 0, 288)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modules, err := util.EncodeQR("DOC-42/1/2", util.QRLevelM)
+	assert.Nil(t, err)
+	// 左下角距页边 36pt、24pt，宽 59.5pt，含 4 个模块的空白
+	size := len(modules) + 8
+	module := 595 * 0.1 / float64(size) * 4
+	left, top := 36.0*4, (842-24-595*0.1)*4
+	mismatches := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dark := false
+			if y >= 4 && y < size-4 && x >= 4 && x < size-4 {
+				dark = modules[y-4][x-4]
+			}
+			r, _, _, _ := img.At(int(left+(float64(x)+0.5)*module), int(top+(float64(y)+0.5)*module)).RGBA()
+			if (r>>8 < 128) != dark {
+				mismatches++
+			}
+		}
+	}
+	assert.Zero(t, mismatches)
+
+	// Code128 在右上角，取距条码顶部 10 个模块的一行比对
+	bars, err := util.EncodeCode128("DOC-000007")
+	assert.Nil(t, err)
+	width := 595 * 0.3 * 4
+	module = width / float64(len(bars)+20)
+	right := (595 - 36) * 4.0
+	y := int(24*4 + 10*module)
+	for i, bar := range bars {
+		r, _, _, _ := img.At(int(right-width+(float64(i+10)+0.5)*module), y).RGBA()
+		if (r>>8 < 128) != bar {
+			mismatches++
+		}
+	}
+	assert.Zero(t, mismatches)
+
+	// Code128 不支持中文
+	out.Reset()
+	_, err = AddStamps(context.Background(), instance, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, StampOptions{Barcodes: []BarcodeSpec{DefaultBarcodeSpec(BarcodeCode128, "机密-{page}")}})
+	assert.NotNil(t, err)
+	assert.Zero(t, out.Len())
+}
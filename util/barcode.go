@@ -0,0 +1,695 @@
+package util
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// QRLevel QR 码的纠错等级
+type QRLevel int
+
+const (
+	QRLevelL QRLevel = iota // 约 7% 的码字可以恢复
+	QRLevelM                // 约 15%
+	QRLevelQ                // 约 25%
+	QRLevelH                // 约 30%
+)
+
+var qrLevelNames = [4]string{"L", "M", "Q", "H"}
+
+// qrLevelBits 格式信息中纠错等级的编码
+var qrLevelBits = [4]int{1, 0, 3, 2}
+
+// ParseQRLevel 解析纠错等级 L、M、Q、H
+func ParseQRLevel(s string) (QRLevel, error) {
+	for i, name := range qrLevelNames {
+		if strings.EqualFold(s, name) {
+			return QRLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("未知的纠错等级 %q，可选 L、M、Q、H", s)
+}
+
+func (l QRLevel) String() string {
+	return qrLevelNames[l]
+}
+
+// 每个纠错块的纠错码字数和纠错块数，按 [纠错等级][版本] 索引，版本 0 不使用
+var qrECCodewordsPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var qrECBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// qrRawModules 去掉功能图形后可以放数据的模块数
+func qrRawModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// qrDataCodewords 数据码字数
+func qrDataCodewords(version int, level QRLevel) int {
+	return qrRawModules(version)/8 - qrECCodewordsPerBlock[level][version]*qrECBlocks[level][version]
+}
+
+// qrAlignmentPositions 对齐图形中心的行列坐标
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, version*4+10; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+// qrMul GF(256) 乘法，本原多项式 0x11D
+func qrMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11d)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// qrReedSolomon 计算 data 的 degree 个纠错码字
+func qrReedSolomon(data []byte, degree int) []byte {
+	divisor := make([]byte, degree)
+	divisor[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range divisor {
+			divisor[j] = qrMul(divisor[j], root)
+			if j+1 < degree {
+				divisor[j] ^= divisor[j+1]
+			}
+		}
+		root = qrMul(root, 2)
+	}
+
+	result := make([]byte, degree)
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[degree-1] = 0
+		for i := range result {
+			result[i] ^= qrMul(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// bitBuffer 按位追加的缓冲区
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>i)&1 != 0)
+	}
+}
+
+const qrAlphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// qrSegment 按内容选择最紧凑的模式：纯数字、大写字母数字或字节（UTF-8）
+type qrSegment struct {
+	mode      int
+	countBits [3]int // 版本 1-9、10-26、27-40 的字符数位数
+	count     int
+	data      bitBuffer
+}
+
+func newQRSegment(content string) qrSegment {
+	numeric, alphanumeric := true, true
+	for _, c := range content {
+		if c < '0' || c > '9' {
+			numeric = false
+		}
+		if !strings.ContainsRune(qrAlphanumeric, c) {
+			alphanumeric = false
+		}
+	}
+
+	var s qrSegment
+	switch {
+	case numeric:
+		s = qrSegment{mode: 1, countBits: [3]int{10, 12, 14}, count: len(content)}
+		for i := 0; i < len(content); i += 3 {
+			n := len(content) - i
+			if n > 3 {
+				n = 3
+			}
+			value := 0
+			for _, c := range content[i : i+n] {
+				value = value*10 + int(c-'0')
+			}
+			s.data.append(value, n*3+1)
+		}
+	case alphanumeric:
+		s = qrSegment{mode: 2, countBits: [3]int{9, 11, 13}, count: len(content)}
+		for i := 0; i < len(content); i += 2 {
+			if i+1 < len(content) {
+				s.data.append(strings.IndexByte(qrAlphanumeric, content[i])*45+strings.IndexByte(qrAlphanumeric, content[i+1]), 11)
+			} else {
+				s.data.append(strings.IndexByte(qrAlphanumeric, content[i]), 6)
+			}
+		}
+	default:
+		s = qrSegment{mode: 4, countBits: [3]int{8, 16, 16}, count: len(content)}
+		for i := 0; i < len(content); i++ {
+			s.data.append(int(content[i]), 8)
+		}
+	}
+	return s
+}
+
+// bits 在指定版本下的总位数，字符数超出范围时返回 -1
+func (s qrSegment) bits(version int) int {
+	countBits := s.countBits[0]
+	if version >= 27 {
+		countBits = s.countBits[2]
+	} else if version >= 10 {
+		countBits = s.countBits[1]
+	}
+	if s.count >= 1<<countBits {
+		return -1
+	}
+	return 4 + countBits + len(s.data)
+}
+
+// qrCode 编码中的 QR 码
+type qrCode struct {
+	version    int
+	size       int
+	level      QRLevel
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// EncodeQR 把内容编码为 QR 码，自动选择最小的版本，返回模块矩阵（不含空白边），true 为深色
+func EncodeQR(content string, level QRLevel) ([][]bool, error) {
+	if level < QRLevelL || level > QRLevelH {
+		return nil, fmt.Errorf("未知的纠错等级: %d", level)
+	}
+	segment := newQRSegment(content)
+
+	version := 1
+	for ; version <= 40; version++ {
+		if bits := segment.bits(version); bits >= 0 && bits <= qrDataCodewords(version, level)*8 {
+			break
+		}
+	}
+	if version > 40 {
+		return nil, fmt.Errorf("内容太长，无法编码为纠错等级 %s 的 QR 码: %d 字节", level, len(content))
+	}
+
+	// 模式、字符数、数据，然后补终止符和填充码字
+	capacity := qrDataCodewords(version, level) * 8
+	var bits bitBuffer
+	bits.append(segment.mode, 4)
+	bits.append(segment.count, segment.bits(version)-4-len(segment.data))
+	bits = append(bits, segment.data...)
+	terminator := capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xec; len(bits) < capacity; pad ^= 0xec ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	data := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			data[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+
+	qr := &qrCode{version: version, size: version*4 + 17, level: level}
+	qr.modules = make([][]bool, qr.size)
+	qr.isFunction = make([][]bool, qr.size)
+	for i := range qr.modules {
+		qr.modules[i] = make([]bool, qr.size)
+		qr.isFunction[i] = make([]bool, qr.size)
+	}
+	qr.drawFunctionPatterns()
+	qr.drawCodewords(qr.addECAndInterleave(data))
+
+	// 选择惩罚分最低的掩码
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormatBits(mask)
+		if penalty := qr.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		qr.applyMask(mask)
+	}
+	qr.applyMask(best)
+	qr.drawFormatBits(best)
+	return qr.modules, nil
+}
+
+func (qr *qrCode) setFunction(x, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.isFunction[y][x] = true
+}
+
+func (qr *qrCode) drawFunctionPatterns() {
+	// 定时图形
+	for i := 0; i < qr.size; i++ {
+		qr.setFunction(6, i, i%2 == 0)
+		qr.setFunction(i, 6, i%2 == 0)
+	}
+
+	// 三个角上的定位图形，连同分隔符
+	for _, center := range [][2]int{{3, 3}, {qr.size - 4, 3}, {3, qr.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x < 0 || x >= qr.size || y < 0 || y >= qr.size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				qr.setFunction(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	// 对齐图形，避开定位图形
+	positions := qrAlignmentPositions(qr.version)
+	n := len(positions)
+	for i := range positions {
+		for j := range positions {
+			if i == 0 && j == 0 || i == 0 && j == n-1 || i == n-1 && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qr.setFunction(positions[i]+dx, positions[j]+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// 先占住格式信息的位置，选好掩码后再写入
+	qr.drawFormatBits(0)
+	qr.drawVersion()
+}
+
+// qrFormatBits 纠错等级和掩码的 15 位格式信息（BCH 编码后异或 0x5412）
+func qrFormatBits(level QRLevel, mask int) int {
+	data := qrLevelBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// qrVersionBits 版本 7 以上的 18 位版本信息
+func qrVersionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1f25)
+	}
+	return version<<12 | rem
+}
+
+func (qr *qrCode) drawFormatBits(mask int) {
+	bits := qrFormatBits(qr.level, mask)
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+
+	// 左上角
+	for i := 0; i <= 5; i++ {
+		qr.setFunction(8, i, bit(i))
+	}
+	qr.setFunction(8, 7, bit(6))
+	qr.setFunction(8, 8, bit(7))
+	qr.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunction(14-i, 8, bit(i))
+	}
+
+	// 右上角和左下角各一份，外加固定的深色模块
+	for i := 0; i < 8; i++ {
+		qr.setFunction(qr.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunction(8, qr.size-15+i, bit(i))
+	}
+	qr.setFunction(8, qr.size-8, true)
+}
+
+func (qr *qrCode) drawVersion() {
+	if qr.version < 7 {
+		return
+	}
+	bits := qrVersionBits(qr.version)
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 != 0
+		a, b := qr.size-11+i%3, i/3
+		qr.setFunction(a, b, dark)
+		qr.setFunction(b, a, dark)
+	}
+}
+
+// addECAndInterleave 分块计算纠错码字，再按列交错
+func (qr *qrCode) addECAndInterleave(data []byte) []byte {
+	numBlocks := qrECBlocks[qr.level][qr.version]
+	ecLen := qrECCodewordsPerBlock[qr.level][qr.version]
+	rawCodewords := qrRawModules(qr.version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		n := shortBlockLen - ecLen
+		if i >= numShortBlocks {
+			n++
+		}
+		block := append([]byte(nil), data[k:k+n]...)
+		k += n
+		ec := qrReedSolomon(block, ecLen)
+		if i < numShortBlocks {
+			// 占位，交错时跳过
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ec...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := 0; i <= shortBlockLen; i++ {
+		for j, block := range blocks {
+			if i != shortBlockLen-ecLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// drawCodewords 从右下角开始，两列一组蛇形填充数据
+func (qr *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < qr.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = qr.size - 1 - vert
+				}
+				if !qr.isFunction[y][x] && i < len(data)*8 {
+					qr.modules[y][x] = (data[i>>3]>>(7-i&7))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask 对数据模块应用掩码，应用两次即可还原
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !qr.isFunction[y][x] {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// qrFinderLike 1:1:3:1:1 的类定位图形，一侧带 4 个浅色模块
+var qrFinderLike = [][]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+// penalty 按规范的四条规则计算惩罚分
+func (qr *qrCode) penalty() int {
+	score := 0
+	at := func(horizontal bool, line, i int) bool {
+		if horizontal {
+			return qr.modules[line][i]
+		}
+		return qr.modules[i][line]
+	}
+
+	for _, horizontal := range []bool{true, false} {
+		for line := 0; line < qr.size; line++ {
+			// 同色连续 5 个以上
+			run := 1
+			for i := 1; i <= qr.size; i++ {
+				if i < qr.size && at(horizontal, line, i) == at(horizontal, line, i-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += 3 + run - 5
+				}
+				run = 1
+			}
+			// 类定位图形
+			for i := 0; i+11 <= qr.size; i++ {
+				for _, pattern := range qrFinderLike {
+					match := true
+					for k, dark := range pattern {
+						if at(horizontal, line, i+k) != dark {
+							match = false
+							break
+						}
+					}
+					if match {
+						score += 40
+					}
+				}
+			}
+		}
+	}
+
+	// 2x2 同色块
+	dark := 0
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.modules[y][x] {
+				dark++
+			}
+			if x+1 < qr.size && y+1 < qr.size {
+				c := qr.modules[y][x]
+				if c == qr.modules[y][x+1] && c == qr.modules[y+1][x] && c == qr.modules[y+1][x+1] {
+					score += 3
+				}
+			}
+		}
+	}
+
+	// 深色比例偏离 50%，每 5% 加 10 分
+	total := qr.size * qr.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return score + k*10
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// QRCodeImage 生成 QR 码图片，每个模块 scale 像素，四周留 4 个模块的空白
+func QRCodeImage(content string, level QRLevel, scale int) (*image.Gray, error) {
+	modules, err := EncodeQR(content, level)
+	if err != nil {
+		return nil, err
+	}
+	const quiet = 4
+	size := len(modules) + quiet*2
+	img := image.NewGray(image.Rect(0, 0, size*scale, size*scale))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	for y, row := range modules {
+		for x, dark := range row {
+			if dark {
+				fillRect(img, (x+quiet)*scale, (y+quiet)*scale, scale, scale)
+			}
+		}
+	}
+	return img, nil
+}
+
+func fillRect(img *image.Gray, x, y, width, height int) {
+	for dy := 0; dy < height; dy++ {
+		for dx := 0; dx < width; dx++ {
+			img.SetGray(x+dx, y+dy, color.Gray{})
+		}
+	}
+}
+
+// code128Patterns 各符号的条、空宽度，最后一个是终止符
+var code128Patterns = []string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128CodeC  = 99
+	code128CodeB  = 100
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = 106
+)
+
+// code128Symbols 把内容编码为符号值（含起始符和校验符），
+// 一般用字符集 B，较长的连续数字切换到字符集 C 每两位一个符号
+func code128Symbols(content string) ([]int, error) {
+	if content == "" {
+		return nil, fmt.Errorf("Code128 内容不能为空")
+	}
+	for i := 0; i < len(content); i++ {
+		if content[i] < 32 || content[i] > 126 {
+			return nil, fmt.Errorf("Code128 只支持可打印的 ASCII 字符: %q", content)
+		}
+	}
+	digits := func(i int) int {
+		n := 0
+		for i+n < len(content) && content[i+n] >= '0' && content[i+n] <= '9' {
+			n++
+		}
+		return n
+	}
+
+	var symbols []int
+	setC := false
+	if n := digits(0); n >= 4 || n == len(content) && n%2 == 0 {
+		symbols, setC = []int{code128StartC}, true
+	} else {
+		symbols = []int{code128StartB}
+	}
+	for i := 0; i < len(content); {
+		if setC {
+			if digits(i) >= 2 {
+				symbols = append(symbols, int(content[i]-'0')*10+int(content[i+1]-'0'))
+				i += 2
+				continue
+			}
+			symbols, setC = append(symbols, code128CodeB), false
+		}
+		if n := digits(i); n >= 6 || n >= 4 && i+n == len(content) {
+			if n%2 == 1 {
+				symbols = append(symbols, int(content[i])-32)
+				i++
+			}
+			symbols, setC = append(symbols, code128CodeC), true
+			continue
+		}
+		symbols = append(symbols, int(content[i])-32)
+		i++
+	}
+
+	checksum := symbols[0]
+	for i, s := range symbols[1:] {
+		checksum += (i + 1) * s
+	}
+	return append(symbols, checksum%103), nil
+}
+
+// EncodeCode128 把内容编码为 Code128 条码，返回每个模块是否为条（不含空白区）
+func EncodeCode128(content string) ([]bool, error) {
+	symbols, err := code128Symbols(content)
+	if err != nil {
+		return nil, err
+	}
+	var modules []bool
+	for _, s := range append(symbols, code128Stop) {
+		for i, w := range code128Patterns[s] {
+			for k := 0; k < int(w-'0'); k++ {
+				modules = append(modules, i%2 == 0)
+			}
+		}
+	}
+	return modules, nil
+}
+
+// Code128Image 生成 Code128 条码图片，每个模块 scale 像素宽，高 height 像素，两侧各留 10 个模块的空白。
+// height 为 0 时取宽度的 1/4，至少 24 个模块
+func Code128Image(content string, scale, height int) (*image.Gray, error) {
+	modules, err := EncodeCode128(content)
+	if err != nil {
+		return nil, err
+	}
+	const quiet = 10
+	if height <= 0 {
+		height = max((len(modules)+quiet*2)/4, 24) * scale
+	}
+	img := image.NewGray(image.Rect(0, 0, (len(modules)+quiet*2)*scale, height))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	for x, bar := range modules {
+		if bar {
+			fillRect(img, (x+quiet)*scale, 0, scale, height)
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQRCode(t *testing.T) {
+	// 规范附录中的 "HELLO WORLD" 1-M：数据码字和纠错码字
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	assert.Equal(t, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}, qrReedSolomon(data, 10))
+
+	// 格式信息和版本信息
+	assert.Equal(t, 0b111011111000100, qrFormatBits(QRLevelL, 0))
+	assert.Equal(t, 0b101010000010010, qrFormatBits(QRLevelM, 0))
+	assert.Equal(t, 0b000100000111011, qrFormatBits(QRLevelH, 7))
+	assert.Equal(t, 0b000111110010010100, qrVersionBits(7))
+	assert.Equal(t, 0b101000110001101001, qrVersionBits(40))
+
+	// 数据容量
+	for _, c := range []struct {
+		version int
+		level   QRLevel
+		words   int
+	}{{1, QRLevelL, 19}, {1, QRLevelH, 9}, {5, QRLevelQ, 62}, {10, QRLevelM, 216}, {40, QRLevelL, 2956}, {40, QRLevelH, 1276}} {
+		assert.Equal(t, c.words, qrDataCodewords(c.version, c.level), "%d-%s", c.version, c.level)
+	}
+	assert.Equal(t, []int{6, 30, 58, 86, 114, 142, 170}, qrAlignmentPositions(40))
+
+	modules, err := EncodeQR("HELLO WORLD", QRLevelM)
+	assert.Nil(t, err)
+	assert.Len(t, modules, 21)
+	// 左上角定位图形和固定的深色模块
+	assert.Equal(t, []bool{true, true, true, true, true, true, true, false}, modules[0][:8])
+	assert.Equal(t, []bool{true, false, false, false, false, false, true, false}, modules[1][:8])
+	assert.True(t, modules[21-8][8])
+
+	// 字节模式自动选大版本，超出容量报错
+	modules, err = EncodeQR(strings.Repeat("doc-42/page-7 ", 20), QRLevelQ)
+	assert.Nil(t, err)
+	assert.Len(t, modules, 4*15+17)
+	_, err = EncodeQR(strings.Repeat("x", 3000), QRLevelL)
+	assert.NotNil(t, err)
+
+	img, err := QRCodeImage("HELLO WORLD", QRLevelM, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, (21+8)*3, img.Bounds().Dx())
+	assert.Equal(t, uint8(255), img.GrayAt(11, 11).Y)
+	assert.Equal(t, uint8(0), img.GrayAt(12, 12).Y)
+
+	level, err := ParseQRLevel("q")
+	assert.Nil(t, err)
+	assert.Equal(t, QRLevelQ, level)
+	_, err = ParseQRLevel("X")
+	assert.NotNil(t, err)
+}
+
+func TestCode128(t *testing.T) {
+	for _, p := range code128Patterns[:code128Stop] {
+		sum := 0
+		for _, w := range p {
+			sum += int(w - '0')
+		}
+		assert.Equal(t, 11, sum, p)
+	}
+
+	// 字符集 B，校验符 (104 + 48*1 + 42*2 + 42*3 + 17*4 + 18*5 + 19*6 + 35*7) % 103 = 55
+	symbols, err := code128Symbols("PJJ123C")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{104, 48, 42, 42, 17, 18, 19, 35, 55}, symbols)
+
+	// 全是数字时用字符集 C，长数字串中途切换
+	symbols, err = code128Symbols("123456")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{105, 12, 34, 56}, symbols[:4])
+	symbols, err = code128Symbols("DOC-0012345")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{104, 36, 47, 35, 13, 16, 99, 1, 23, 45}, symbols[:10])
+
+	modules, err := EncodeCode128("PJJ123C")
+	assert.Nil(t, err)
+	assert.Len(t, modules, 9*11+13)
+	assert.True(t, modules[0])
+	assert.True(t, modules[len(modules)-1])
+
+	_, err = EncodeCode128("机密")
+	assert.NotNil(t, err)
+	_, err = EncodeCode128("")
+	assert.NotNil(t, err)
+
+	img, err := Code128Image("PJJ123C", 2, 40)
+	assert.Nil(t, err)
+	assert.Equal(t, (9*11+13+20)*2, img.Bounds().Dx())
+	assert.Equal(t, 40, img.Bounds().Dy())
+	assert.Equal(t, uint8(0), img.GrayAt(20, 39).Y)
+	img, err = Code128Image("PJJ123C", 2, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 33*2, img.Bounds().Dy())
+}
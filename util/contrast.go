@@ -0,0 +1,152 @@
+package util
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// linearize 把 sRGB 分量（0-255）换算为线性值（0-1）
+func linearize(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// RelativeLuminance 按 WCAG 计算颜色的相对亮度，0 为黑色，1 为白色
+func RelativeLuminance(c color.Color) float64 {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return 0.2126*linearize(n.R) + 0.7152*linearize(n.G) + 0.0722*linearize(n.B)
+}
+
+// GrayLuminance 灰度值（0-255）的相对亮度
+func GrayLuminance(v uint8) float64 {
+	return linearize(v)
+}
+
+// ContrastRatio 按 WCAG 计算两个相对亮度的对比度，1-21
+func ContrastRatio(a, b float64) float64 {
+	if a < b {
+		a, b = b, a
+	}
+	return (a + 0.05) / (b + 0.05)
+}
+
+// ImageLuminance 返回图片按不透明度加权的平均相对亮度，完全透明时返回 0.5
+func ImageLuminance(img image.Image) float64 {
+	b := img.Bounds()
+	var sum, weight float64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				continue
+			}
+			a := float64(c.A) / 255
+			sum += a * RelativeLuminance(c)
+			weight += a
+		}
+	}
+	if weight == 0 {
+		return 0.5
+	}
+	return sum / weight
+}
+
+// InvertLightness 保持色相、饱和度和透明度，把 HSL 亮度取反，深色的 logo 变为浅色，浅色的变为深色
+func InvertLightness(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			dst.SetNRGBA(x-b.Min.X, y-b.Min.Y, InvertColorLightness(c))
+		}
+	}
+	return dst
+}
+
+// InvertColorLightness 把颜色的 HSL 亮度取反，色相、饱和度和透明度不变
+func InvertColorLightness(c color.NRGBA) color.NRGBA {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	hi, lo := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	// 亮度 L=(hi+lo)/2 取反后为 1-L，每个分量 v 变为 1-L+(v-L)，即 hi+lo 关于 1 翻转
+	shift := 1 - (hi + lo)
+	channel := func(v float64) uint8 {
+		return uint8(math.Round(math.Max(0, math.Min(1, v+shift)) * 255))
+	}
+	return color.NRGBA{R: channel(r), G: channel(g), B: channel(b), A: c.A}
+}
+
+// Outline 给图片的不透明部分加上宽 radius 像素的描边，返回的图片四周各扩大 radius 像素
+func Outline(img image.Image, c color.NRGBA, radius int) *image.NRGBA {
+	b := img.Bounds()
+	w, h := b.Dx()+2*radius, b.Dy()+2*radius
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, image.Rect(radius, radius, radius+b.Dx(), radius+b.Dy()), img, b.Min, draw.Src)
+
+	// 描边的透明度取半径内最不透明的像素，即对 alpha 通道做圆形膨胀。
+	// 圆内第 dy 行是半宽 sqrt(r²-dy²) 的一段，先按每种半宽求行内的滑动最大值，再逐行合并
+	alpha := make([]uint8, w*h)
+	for i := range alpha {
+		alpha[i] = src.Pix[i*4+3]
+	}
+	rows := make(map[int][]uint8)
+	dst := image.NewNRGBA(src.Rect)
+	for dy := -radius; dy <= radius; dy++ {
+		k := int(math.Sqrt(float64(radius*radius - dy*dy)))
+		row, ok := rows[k]
+		if !ok {
+			row = slidingMax(alpha, w, h, k)
+			rows[k] = row
+		}
+		for y := 0; y < h; y++ {
+			sy := y + dy
+			if sy < 0 || sy >= h {
+				continue
+			}
+			for x := 0; x < w; x++ {
+				if a := row[sy*w+x]; a > dst.Pix[dst.PixOffset(x, y)+3] {
+					dst.Pix[dst.PixOffset(x, y)+3] = a
+				}
+			}
+		}
+	}
+	for i := 0; i < len(dst.Pix); i += 4 {
+		dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2] = c.R, c.G, c.B
+		dst.Pix[i+3] = uint8(uint32(dst.Pix[i+3]) * uint32(c.A) / 255)
+	}
+	draw.Draw(dst, dst.Rect, src, image.Point{}, draw.Over)
+	return dst
+}
+
+// slidingMax 返回每个像素所在行中 [x-k, x+k] 范围内的最大值
+func slidingMax(values []uint8, w, h, k int) []uint8 {
+	out := make([]uint8, len(values))
+	window := make([]int, 0, w) // 窗口内值单调递减的下标，从 head 开始
+	for y := 0; y < h; y++ {
+		row := values[y*w : (y+1)*w]
+		window = window[:0]
+		head := 0
+		for j := 0; j < w+k; j++ {
+			if j < w {
+				for len(window) > head && row[window[len(window)-1]] <= row[j] {
+					window = window[:len(window)-1]
+				}
+				window = append(window, j)
+			}
+			x := j - k
+			if x < 0 {
+				continue
+			}
+			for window[head] < x-k {
+				head++
+			}
+			out[y*w+x] = row[window[head]]
+		}
+	}
+	return out
+}
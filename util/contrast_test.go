@@ -0,0 +1,48 @@
+package util
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContrast(t *testing.T) {
+	assert.InDelta(t, 1, RelativeLuminance(color.White), 1e-9)
+	assert.InDelta(t, 0, RelativeLuminance(color.Black), 1e-9)
+	assert.InDelta(t, 21, ContrastRatio(0, 1), 1e-9)
+	assert.InDelta(t, 21, ContrastRatio(1, 0), 1e-9)
+	assert.InDelta(t, GrayLuminance(128), RelativeLuminance(color.Gray{Y: 128}), 1e-9)
+
+	assert.Equal(t, color.NRGBA{255, 255, 255, 200}, InvertColorLightness(color.NRGBA{0, 0, 0, 200}))
+	assert.Equal(t, color.NRGBA{0, 0, 0, 255}, InvertColorLightness(color.NRGBA{255, 255, 255, 255}))
+	// 纯色亮度为 0.5，不变；深蓝变为浅蓝
+	assert.Equal(t, color.NRGBA{255, 0, 0, 255}, InvertColorLightness(color.NRGBA{255, 0, 0, 255}))
+	assert.Equal(t, color.NRGBA{127, 127, 255, 255}, InvertColorLightness(color.NRGBA{0, 0, 128, 255}))
+
+	// 只统计不透明的像素
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	assert.Equal(t, 0.5, ImageLuminance(img))
+	img.SetNRGBA(0, 0, color.NRGBA{255, 255, 255, 255})
+	assert.InDelta(t, 1, ImageLuminance(img), 1e-9)
+	assert.InDelta(t, 0, ImageLuminance(InvertLightness(img)), 1e-9)
+}
+
+func TestOutline(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	red := color.NRGBA{255, 0, 0, 255}
+	img.SetNRGBA(1, 0, red)
+
+	white := color.NRGBA{255, 255, 255, 255}
+	out := Outline(img, white, 2)
+	assert.Equal(t, image.Rect(0, 0, 7, 5), out.Bounds())
+	assert.Equal(t, red, out.NRGBAAt(3, 2))
+	assert.Equal(t, white, out.NRGBAAt(1, 2))
+	assert.Equal(t, white, out.NRGBAAt(3, 0))
+	assert.Equal(t, white, out.NRGBAAt(4, 3))
+	// 圆形膨胀，对角方向距离超过半径的像素保持透明
+	assert.Zero(t, out.NRGBAAt(1, 0).A)
+	assert.Zero(t, out.NRGBAAt(5, 4).A)
+	assert.Zero(t, out.NRGBAAt(0, 2).A)
+}
@@ -0,0 +1,108 @@
+package util
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+// JPEGOrientation 读取 JPEG 中 EXIF 的方向（1-8），没有 EXIF 或无法解析时返回 1
+func JPEGOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return 1
+		}
+		marker := data[i+1]
+		// SOS 之后是图像数据，EXIF 只会在前面
+		if marker == 0xda || marker == 0xd9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xe1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation 在 TIFF 结构的 IFD0 中查找方向标签 0x0112
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// ApplyOrientation 按 EXIF 方向把图片摆正，方向 5-8 时宽高互换
+func ApplyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	for v := 0; v < h; v++ {
+		for u := 0; u < w; u++ {
+			var x, y int
+			switch orientation {
+			case 2: // 水平翻转
+				x, y = w-1-u, v
+			case 3: // 旋转 180 度
+				x, y = w-1-u, h-1-v
+			case 4: // 垂直翻转
+				x, y = u, h-1-v
+			case 5: // 沿主对角线翻转
+				x, y = v, u
+			case 6: // 顺时针旋转 90 度
+				x, y = h-1-v, u
+			case 7: // 沿副对角线翻转
+				x, y = h-1-v, w-1-u
+			case 8: // 逆时针旋转 90 度
+				x, y = v, w-1-u
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(u, v):src.PixOffset(u, v)+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withOrientation 在 JPEG 的 SOI 之后插入只含方向标签的 EXIF 段
+func withOrientation(data []byte, order binary.ByteOrder, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	if order == binary.LittleEndian {
+		tiff.WriteString("II*\x00")
+	} else {
+		tiff.WriteString("MM\x00*")
+	}
+	binary.Write(&tiff, order, uint32(8))
+	binary.Write(&tiff, order, uint16(1))
+	binary.Write(&tiff, order, []uint16{0x0112, 3})
+	binary.Write(&tiff, order, uint32(1))
+	binary.Write(&tiff, order, []uint16{orientation, 0})
+	binary.Write(&tiff, order, uint32(0))
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(append(append([]byte{}, data[:2]...), append(segment, payload...)...), data[2:]...)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil))
+	data := buf.Bytes()
+
+	assert.Equal(t, 1, JPEGOrientation(data))
+	assert.Equal(t, 6, JPEGOrientation(withOrientation(data, binary.BigEndian, 6)))
+	assert.Equal(t, 8, JPEGOrientation(withOrientation(data, binary.LittleEndian, 8)))
+	assert.Equal(t, 1, JPEGOrientation(withOrientation(data, binary.BigEndian, 9)))
+	assert.Equal(t, 1, JPEGOrientation([]byte("not a jpeg")))
+
+	// 带 EXIF 后仍是合法的 JPEG
+	_, err := jpeg.Decode(bytes.NewReader(withOrientation(data, binary.BigEndian, 6)))
+	assert.Nil(t, err)
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// 2x1：左红右蓝
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	red, blue := color.NRGBA{R: 255, A: 255}, color.NRGBA{B: 255, A: 255}
+	img.SetNRGBA(0, 0, red)
+	img.SetNRGBA(1, 0, blue)
+
+	assert.Equal(t, img, ApplyOrientation(img, 1))
+
+	flipped := ApplyOrientation(img, 2).(*image.NRGBA)
+	assert.Equal(t, blue, flipped.NRGBAAt(0, 0))
+
+	// 顺时针旋转 90 度后左边到了上边
+	rotated := ApplyOrientation(img, 6).(*image.NRGBA)
+	assert.Equal(t, image.Rect(0, 0, 1, 2), rotated.Bounds())
+	assert.Equal(t, red, rotated.NRGBAAt(0, 0))
+	assert.Equal(t, blue, rotated.NRGBAAt(0, 1))
+
+	rotated = ApplyOrientation(img, 8).(*image.NRGBA)
+	assert.Equal(t, blue, rotated.NRGBAAt(0, 0))
+	assert.Equal(t, red, rotated.NRGBAAt(0, 1))
+}
@@ -0,0 +1,174 @@
+package util
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// ssimWindow SSIM 的窗口大小和步长
+const (
+	ssimWindow = 8
+	ssimStep   = 4
+)
+
+// toRGBA 转为 RGBA，已是 RGBA 时直接返回
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+func checkSameSize(a, b image.Image) error {
+	if a.Bounds().Dx() != b.Bounds().Dx() || a.Bounds().Dy() != b.Bounds().Dy() {
+		return fmt.Errorf("图片尺寸不一致: %dx%d != %dx%d", a.Bounds().Dx(), a.Bounds().Dy(), b.Bounds().Dx(), b.Bounds().Dy())
+	}
+	return nil
+}
+
+// PSNR 峰值信噪比（dB），按 RGB 三个通道计算，两张图完全相同时返回 +Inf
+func PSNR(a, b image.Image) (float64, error) {
+	if err := checkSameSize(a, b); err != nil {
+		return 0, err
+	}
+	ra, rb := toRGBA(a), toRGBA(b)
+
+	width, height := ra.Rect.Dx(), ra.Rect.Dy()
+	var sum float64
+	for y := 0; y < height; y++ {
+		pa := ra.Pix[y*ra.Stride : y*ra.Stride+width*4]
+		pb := rb.Pix[y*rb.Stride : y*rb.Stride+width*4]
+		for i := 0; i < len(pa); i += 4 {
+			for c := 0; c < 3; c++ {
+				d := float64(pa[i+c]) - float64(pb[i+c])
+				sum += d * d
+			}
+		}
+	}
+
+	mse := sum / float64(width*height*3)
+	if mse == 0 {
+		return math.Inf(1), nil
+	}
+	return 10 * math.Log10(255*255/mse), nil
+}
+
+// luma 按 BT.601 转为灰度
+func luma(img *image.RGBA) []float64 {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	gray := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*img.Stride + x*4
+			gray[y*width+x] = 0.299*float64(img.Pix[i]) + 0.587*float64(img.Pix[i+1]) + 0.114*float64(img.Pix[i+2])
+		}
+	}
+	return gray
+}
+
+// SSIM 结构相似度，在灰度图上按 8x8 窗口（步长 4）计算后取平均，1 表示完全相同
+func SSIM(a, b image.Image) (float64, error) {
+	if err := checkSameSize(a, b); err != nil {
+		return 0, err
+	}
+	ra, rb := toRGBA(a), toRGBA(b)
+	width, height := ra.Rect.Dx(), ra.Rect.Dy()
+	if width == 0 || height == 0 {
+		return 1, nil
+	}
+	ga, gb := luma(ra), luma(rb)
+
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+
+	// 图片小于窗口时整张图作为一个窗口
+	winW, winH := ssimWindow, ssimWindow
+	if width < winW {
+		winW = width
+	}
+	if height < winH {
+		winH = height
+	}
+
+	var total float64
+	var windows int
+	for y0 := 0; y0+winH <= height; y0 += ssimStep {
+		for x0 := 0; x0+winW <= width; x0 += ssimStep {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for y := y0; y < y0+winH; y++ {
+				for x := x0; x < x0+winW; x++ {
+					va, vb := ga[y*width+x], gb[y*width+x]
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+				}
+			}
+
+			n := float64(winW * winH)
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			cov := sumAB/n - meanA*meanB
+
+			total += ((2*meanA*meanB + c1) * (2*cov + c2)) / ((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+
+	return total / float64(windows), nil
+}
+
+// DiffImage 生成差异图：底图为淡化的 a，差异越大的像素越红
+func DiffImage(a, b image.Image) (*image.RGBA, error) {
+	if err := checkSameSize(a, b); err != nil {
+		return nil, err
+	}
+	ra, rb := toRGBA(a), toRGBA(b)
+	width, height := ra.Rect.Dx(), ra.Rect.Dy()
+
+	diff := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*ra.Stride + x*4
+			j := y*rb.Stride + x*4
+
+			var maxDelta int
+			for c := 0; c < 3; c++ {
+				d := int(ra.Pix[i+c]) - int(rb.Pix[j+c])
+				if d < 0 {
+					d = -d
+				}
+				if d > maxDelta {
+					maxDelta = d
+				}
+			}
+
+			// 原图淡化为浅灰，便于看出差异所在的位置
+			gray := uint8(192 + (0.299*float64(ra.Pix[i])+0.587*float64(ra.Pix[i+1])+0.114*float64(ra.Pix[i+2]))/4)
+			if maxDelta == 0 {
+				diff.SetRGBA(x, y, color.RGBA{R: gray, G: gray, B: gray, A: 255})
+				continue
+			}
+
+			// 差异放大 4 倍，避免轻微失真看不出来
+			strength := maxDelta * 4
+			if strength > 255 {
+				strength = 255
+			}
+			fade := uint8(int(gray) * (255 - strength) / 255)
+			diff.SetRGBA(x, y, color.RGBA{R: 255, G: fade, B: fade, A: 255})
+		}
+	}
+
+	return diff, nil
+}
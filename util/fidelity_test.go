@@ -0,0 +1,110 @@
+package util
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// gradient 生成带纹理的测试图
+func gradient(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: uint8((x + y) * 2), A: 255})
+		}
+	}
+	return img
+}
+
+func TestPSNR(t *testing.T) {
+	a := gradient(32, 32)
+
+	psnr, err := PSNR(a, a)
+	assert.Nil(t, err)
+	assert.True(t, math.IsInf(psnr, 1))
+
+	// 每个通道相差 10，MSE=100
+	b := image.NewRGBA(a.Rect)
+	for i := range a.Pix {
+		b.Pix[i] = a.Pix[i]
+		if i%4 != 3 {
+			b.Pix[i] = a.Pix[i] + 10
+			if a.Pix[i] > 245 {
+				b.Pix[i] = a.Pix[i] - 10
+			}
+		}
+	}
+	psnr, err = PSNR(a, b)
+	assert.Nil(t, err)
+	assert.InDelta(t, 10*math.Log10(255*255/100.0), psnr, 1e-9)
+
+	// 非 RGBA 及非零起点的图片
+	sub := gradient(64, 64).SubImage(image.Rect(16, 16, 48, 48))
+	gray := image.NewGray(image.Rect(0, 0, 32, 32))
+	_, err = PSNR(sub, gray)
+	assert.Nil(t, err)
+
+	_, err = PSNR(a, gradient(16, 32))
+	assert.NotNil(t, err)
+}
+
+func TestSSIM(t *testing.T) {
+	a := gradient(32, 32)
+
+	ssim, err := SSIM(a, a)
+	assert.Nil(t, err)
+	assert.InDelta(t, 1, ssim, 1e-9)
+
+	// 轻微失真的 SSIM 高于严重失真
+	slight, heavy := image.NewRGBA(a.Rect), image.NewRGBA(a.Rect)
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			c := a.RGBAAt(x, y)
+			n := uint8((x*7 + y*13) % 5)
+			slight.SetRGBA(x, y, color.RGBA{R: c.R ^ n, G: c.G ^ n, B: c.B ^ n, A: 255})
+			if (x/4+y/4)%2 == 0 {
+				heavy.SetRGBA(x, y, color.RGBA{A: 255})
+			} else {
+				heavy.SetRGBA(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+			}
+		}
+	}
+	ssimSlight, err := SSIM(a, slight)
+	assert.Nil(t, err)
+	ssimHeavy, err := SSIM(a, heavy)
+	assert.Nil(t, err)
+	assert.Greater(t, ssimSlight, 0.9)
+	assert.Less(t, ssimHeavy, 0.5)
+	assert.Greater(t, ssimSlight, ssimHeavy)
+
+	// 小于窗口的图片
+	ssim, err = SSIM(gradient(3, 3), gradient(3, 3))
+	assert.Nil(t, err)
+	assert.InDelta(t, 1, ssim, 1e-9)
+
+	_, err = SSIM(a, gradient(32, 16))
+	assert.NotNil(t, err)
+}
+
+func TestDiffImage(t *testing.T) {
+	a := gradient(16, 16)
+	b := image.NewRGBA(a.Rect)
+	copy(b.Pix, a.Pix)
+	b.SetRGBA(5, 7, color.RGBA{R: 0, G: 0, B: 0, A: 255})
+
+	diff, err := DiffImage(a, b)
+	assert.Nil(t, err)
+	assert.Equal(t, a.Rect, diff.Rect)
+
+	changed := diff.RGBAAt(5, 7)
+	assert.Equal(t, uint8(255), changed.R)
+	assert.Less(t, changed.G, uint8(128))
+
+	same := diff.RGBAAt(0, 0)
+	assert.Equal(t, same.R, same.G)
+	assert.Equal(t, same.G, same.B)
+}
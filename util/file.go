@@ -8,6 +8,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/klippa-app/go-pdfium/enums"
 	"github.com/nfnt/resize"
@@ -51,7 +53,6 @@ func RenderImage(data []byte, width int, height int, stride int, format int) (is
 	switch enums.FPDF_BITMAP_FORMAT(format) {
 
 	case enums.FPDF_BITMAP_FORMAT_GRAY:
-		fmt.Println("GRAY GRAY GRAY GRAY GRAY")
 		img := image.NewGray(image.Rect(0, 0, width, height))
 		for y := 0; y < height; y++ {
 			for x := 0; x < width; x++ {
@@ -61,7 +62,6 @@ func RenderImage(data []byte, width int, height int, stride int, format int) (is
 		return false, img, nil
 
 	case enums.FPDF_BITMAP_FORMAT_BGR:
-		fmt.Println("BGR BGR BGR BGR BGR")
 		img := image.NewRGBA(image.Rect(0, 0, width, height))
 
 		for y := 0; y < height; y++ {
@@ -83,7 +83,6 @@ func RenderImage(data []byte, width int, height int, stride int, format int) (is
 		return false, img, nil
 
 	case enums.FPDF_BITMAP_FORMAT_BGRA:
-		fmt.Println("BGRA BGRA BGRA BGRA BGRA")
 		img := image.NewRGBA(image.Rect(0, 0, width, height))
 		for y := 0; y < height; y++ {
 			for x := 0; x < width; x++ {
@@ -109,7 +108,6 @@ func RenderImage(data []byte, width int, height int, stride int, format int) (is
 		return isAlphaValid, img, nil
 
 	case enums.FPDF_BITMAP_FORMAT_BGRX:
-		fmt.Println("BGRX BGRX BGRX BGRX BGRX")
 		img := image.NewRGBA(image.Rect(0, 0, width, height))
 		for y := 0; y < height; y++ {
 			for x := 0; x < width; x++ {
@@ -133,6 +131,23 @@ func RenderImage(data []byte, width int, height int, stride int, format int) (is
 	return false, nil, fmt.Errorf("不支持的图片格式: %d", format)
 }
 
+// HasAlpha 判断 BGRA 位图中是否存在非不透明像素，其他格式没有 alpha 通道
+func HasAlpha(data []byte, width int, height int, stride int, format int) bool {
+	if enums.FPDF_BITMAP_FORMAT(format) != enums.FPDF_BITMAP_FORMAT_BGRA {
+		return false
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			index := y*stride + x*4 + 3
+			if index < len(data) && data[index] != 255 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func ConvertToJPEG(img image.Image, outputPath string, quality int) error {
 
 	// 创建输出文件
@@ -142,11 +157,17 @@ func ConvertToJPEG(img image.Image, outputPath string, quality int) error {
 	}
 	defer outFile.Close()
 
+	return EncodeJPEG(outFile, img, quality)
+}
+
+// EncodeJPEG 将图像按指定质量编码为 JPEG 格式并写入 w
+func EncodeJPEG(w io.Writer, img image.Image, quality int) error {
+
 	// 设置 JPEG 压缩质量
 	jpegOptions := &jpeg.Options{Quality: quality}
 
-	// 将图像编码为 JPEG 格式并写入输出文件
-	if err := jpeg.Encode(outFile, img, jpegOptions); err != nil {
+	// 将图像编码为 JPEG 格式并写入输出
+	if err := jpeg.Encode(w, img, jpegOptions); err != nil {
 		return err
 	}
 
@@ -194,8 +215,6 @@ func ReduceDPI(img image.Image, originalWidth int, originalDPI, newDPI float32)
 	if width > uint(img.Bounds().Dx()) {
 		return img
 	}
-	fmt.Printf("original width: %d, new width: %d originalDPI: %f, newDPI: %f\n", img.Bounds().Dx(), width, originalDPI, newDPI)
-
 	return resize.Resize(width, 0, img, resize.Bicubic)
 }
 
@@ -216,3 +235,99 @@ func GetFilePath(inputDir string, fileExt string) []string {
 
 	return pdfPaths
 }
+
+// MirrorPath 计算 path 相对 inputDir 的路径，并映射到 outputDir 下的同名位置
+func MirrorPath(inputDir string, outputDir string, path string) (string, error) {
+	rel, err := filepath.Rel(inputDir, path)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s 不在目录 %s 中", path, inputDir)
+	}
+	return filepath.Join(outputDir, rel), nil
+}
+
+// IsUpToDate 判断 dstPath 是否存在且不早于 srcPath
+func IsUpToDate(srcPath string, dstPath string) bool {
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return false
+	}
+	dstInfo, err := os.Stat(dstPath)
+	if err != nil {
+		return false
+	}
+	return dstInfo.Size() > 0 && !dstInfo.ModTime().Before(srcInfo.ModTime())
+}
+
+// AtomicWriteOptions AtomicWriteFile 的选项
+type AtomicWriteOptions struct {
+	Perm         os.FileMode // 新建文件的权限，为 0 时为 0644；覆盖已有文件时沿用原文件的权限
+	PreserveFrom string      // 非空时沿用该文件的权限和修改时间，通常为输入文件
+}
+
+// AtomicWriteFile 先写入同目录下的临时文件并 fsync，再改名为 path，
+// write 失败或进程中途崩溃时 path 保持原样，不会留下看起来完整、实际写了一半的文件
+func AtomicWriteFile(path string, opts AtomicWriteOptions, write func(w io.Writer) error) error {
+	perm := opts.Perm
+	if perm == 0 {
+		perm = 0644
+	}
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	var modTime time.Time
+	if opts.PreserveFrom != "" {
+		info, err := os.Stat(opts.PreserveFrom)
+		if err != nil {
+			return err
+		}
+		perm, modTime = info.Mode().Perm(), info.ModTime()
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	// CreateTemp 创建的文件权限为 0600
+	if err = os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		if err = os.Chtimes(tmpPath, time.Now(), modTime); err != nil {
+			return err
+		}
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	committed = true
+
+	// 改名记录在目录中，同步目录才能保证断电后新文件可见，部分平台不支持，忽略错误
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -2,9 +2,13 @@ package util
 
 import (
 	"bytes"
+	"errors"
 	"image"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -28,3 +32,66 @@ func TestDetermineImgType(t *testing.T) {
 		assert.Equal(t, v, format)
 	}
 }
+
+func TestMirrorPath(t *testing.T) {
+	out, err := MirrorPath("in", "out", filepath.Join("in", "a", "b.pdf"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("out", "a", "b.pdf"), out)
+
+	_, err = MirrorPath("in", "out", filepath.Join("other", "b.pdf"))
+	assert.Error(t, err)
+}
+
+func TestIsUpToDate(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.pdf")
+	dst := filepath.Join(dir, "dst.pdf")
+
+	assert.NoError(t, os.WriteFile(src, []byte("src"), 0644))
+	assert.False(t, IsUpToDate(src, dst))
+
+	assert.NoError(t, os.WriteFile(dst, []byte("dst"), 0644))
+	assert.True(t, IsUpToDate(src, dst))
+
+	// 输入比输出新，需要重新处理
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(src, later, later))
+	assert.False(t, IsUpToDate(src, dst))
+}
+
+func TestAtomicWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.pdf")
+
+	assert.NoError(t, AtomicWriteFile(path, AtomicWriteOptions{}, func(w io.Writer) error {
+		_, err := w.Write([]byte("first"))
+		return err
+	}))
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "first", string(data))
+
+	// 写入失败时保留原文件，不留下临时文件
+	assert.Error(t, AtomicWriteFile(path, AtomicWriteOptions{}, func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return errors.New("failed")
+	}))
+	data, _ = os.ReadFile(path)
+	assert.Equal(t, "first", string(data))
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+
+	// 沿用源文件的权限和修改时间
+	src := filepath.Join(dir, "src.pdf")
+	assert.NoError(t, os.WriteFile(src, []byte("src"), 0600))
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)
+	assert.NoError(t, os.Chtimes(src, modTime, modTime))
+
+	assert.NoError(t, AtomicWriteFile(path, AtomicWriteOptions{PreserveFrom: src}, func(w io.Writer) error {
+		_, err := w.Write([]byte("second"))
+		return err
+	}))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.True(t, info.ModTime().Equal(modTime))
+}
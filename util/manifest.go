@@ -0,0 +1,192 @@
+package util
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileState 批处理中单个文件的状态
+type FileState string
+
+const (
+	StatePending FileState = "pending" // 已排队，尚未完成（中断时停留在该状态）
+	StateDone    FileState = "done"    // 处理成功
+	StateFailed  FileState = "failed"  // 处理失败
+	StateSkipped FileState = "skipped" // 旧版本给清单外、输出比输入新的文件记录的状态，参数不可信，会重新处理
+)
+
+// ManifestEntry 清单中的一条记录
+type ManifestEntry struct {
+	Path         string    `json:"path"` // 相对输入目录的路径
+	State        FileState `json:"state"`
+	InputHash    string    `json:"input_hash"`
+	InputSize    int64     `json:"input_size"`
+	InputModTime time.Time `json:"input_mod_time"`
+	OutputSize   int64     `json:"output_size,omitempty"`
+	Settings     string    `json:"settings"` // 处理参数的指纹
+	Error        string    `json:"error,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Manifest 批处理清单，以 JSON Lines 追加写入，同一路径以最后一条为准
+// 进程崩溃时最多丢失最后一行，打开时会忽略不完整的行并压缩成每个文件一行
+type Manifest struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries map[string]ManifestEntry
+}
+
+// OpenManifest 打开（不存在时创建）清单文件
+func OpenManifest(path string) (*Manifest, error) {
+	m := &Manifest{entries: make(map[string]ManifestEntry)}
+
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var entry ManifestEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Path == "" {
+				// 中断时写了一半的行
+				continue
+			}
+			m.entries[entry.Path] = entry
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := m.compact(path); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	m.file = f
+
+	return m, nil
+}
+
+// compact 每个文件只保留最新一条记录，先写临时文件再改名，避免损坏原清单
+func (m *Manifest) compact(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	paths := make([]string, 0, len(m.entries))
+	for p := range m.entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, p := range paths {
+		if err := enc.Encode(m.entries[p]); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get 返回 path 的最新记录
+func (m *Manifest) Get(path string) (ManifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[path]
+	return entry, ok
+}
+
+// Entries 返回所有记录，按路径排序
+func (m *Manifest) Entries() []ManifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := make([]ManifestEntry, 0, len(m.entries))
+	for _, entry := range m.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
+}
+
+// Update 记录 entry 并立即追加到清单文件，UpdatedAt 取当前时间
+func (m *Manifest) Update(entry ManifestEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.UpdatedAt = time.Now()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err = m.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	m.entries[entry.Path] = entry
+	return nil
+}
+
+// Close 关闭清单文件
+func (m *Manifest) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.file.Sync(); err != nil {
+		m.file.Close()
+		return err
+	}
+	return m.file.Close()
+}
+
+// HashFile 计算文件的 sha256
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.jsonl")
+
+	m, err := OpenManifest(path)
+	assert.NoError(t, err)
+	assert.NoError(t, m.Update(ManifestEntry{Path: "a.pdf", State: StatePending, InputHash: "h1"}))
+	assert.NoError(t, m.Update(ManifestEntry{Path: "a.pdf", State: StateDone, InputHash: "h1"}))
+	assert.NoError(t, m.Update(ManifestEntry{Path: "b.pdf", State: StatePending, InputHash: "h2"}))
+	assert.NoError(t, m.Close())
+
+	// 模拟中断时写了一半的行
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"path":"b.pdf","sta`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	m, err = OpenManifest(path)
+	assert.NoError(t, err)
+	defer m.Close()
+
+	a, ok := m.Get("a.pdf")
+	assert.True(t, ok)
+	assert.Equal(t, StateDone, a.State)
+
+	b, ok := m.Get("b.pdf")
+	assert.True(t, ok)
+	assert.Equal(t, StatePending, b.State)
+
+	assert.Len(t, m.Entries(), 2)
+}
+
+func TestHashFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.pdf")
+	assert.NoError(t, os.WriteFile(path, []byte("abc"), 0644))
+
+	hash, err := HashFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", hash)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/responses"
+)
+
+// pageObjectTypeNames 页面对象类型的展示名称
+var pageObjectTypeNames = map[enums.FPDF_PAGEOBJ]string{
+	enums.FPDF_PAGEOBJ_UNKNOWN: "unknown",
+	enums.FPDF_PAGEOBJ_TEXT:    "text",
+	enums.FPDF_PAGEOBJ_PATH:    "path",
+	enums.FPDF_PAGEOBJ_IMAGE:   "image",
+	enums.FPDF_PAGEOBJ_SHADING: "shading",
+	enums.FPDF_PAGEOBJ_FORM:    "form",
+}
+
+// PageStructure 页面结构，用于比较保存前后的文档
+type PageStructure struct {
+	Width      float64
+	Height     float64
+	Objects    []enums.FPDF_PAGEOBJ // 按顺序记录每个页面对象的类型
+	Text       string
+	Links      int
+	FormFields int
+}
+
+// DocumentStructure 文档结构
+type DocumentStructure struct {
+	Pages     []PageStructure
+	Bookmarks []string // 按层级展开的书签标题
+}
+
+// StructureError 保存结果与原文档结构不一致，此时不写出结果
+type StructureError struct {
+	Problems []string
+}
+
+func (e *StructureError) Error() string {
+	// 问题太多时只列出前几条
+	const maxShown = 5
+	if len(e.Problems) > maxShown {
+		return fmt.Sprintf("保存结果与原文档结构不一致: %s 等 %d 处", strings.Join(e.Problems[:maxShown], "; "), len(e.Problems))
+	}
+	return fmt.Sprintf("保存结果与原文档结构不一致: %s", strings.Join(e.Problems, "; "))
+}
+
+// ReadStructure 读取文档的页面尺寸、页面对象、文字、链接、表单字段和书签
+func ReadStructure(ctx context.Context, instance pdfium.Pdfium, document references.FPDF_DOCUMENT) (*DocumentStructure, error) {
+	pageCountRes, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
+		Document: document,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	structure := &DocumentStructure{}
+	for i := 0; i < pageCountRes.PageCount; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		page, err := readPageStructure(instance, document, i)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 页: %v", i+1, err)
+		}
+		structure.Pages = append(structure.Pages, *page)
+	}
+
+	bookmarksRes, err := instance.GetBookmarks(&requests.GetBookmarks{
+		Document: document,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法获取书签: %v", err)
+	}
+	var walk func(bookmarks []responses.GetBookmarksBookmark, prefix string)
+	walk = func(bookmarks []responses.GetBookmarksBookmark, prefix string) {
+		for _, b := range bookmarks {
+			structure.Bookmarks = append(structure.Bookmarks, prefix+b.Title)
+			walk(b.Children, prefix+b.Title+"/")
+		}
+	}
+	walk(bookmarksRes.Bookmarks, "")
+
+	return structure, nil
+}
+
+func readPageStructure(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, index int) (*PageStructure, error) {
+	sizeRes, err := instance.FPDF_GetPageSizeByIndex(&requests.FPDF_GetPageSizeByIndex{
+		Document: document,
+		Index:    index,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法获取页面尺寸: %v", err)
+	}
+
+	pdfPage, err := instance.FPDF_LoadPage(&requests.FPDF_LoadPage{
+		Document: document,
+		Index:    index,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法加载页面: %v", err)
+	}
+	defer instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
+		Page: pdfPage.Page,
+	})
+	pageRef := requests.Page{
+		ByReference: &pdfPage.Page,
+	}
+
+	page := &PageStructure{Width: sizeRes.Width, Height: sizeRes.Height}
+
+	objectCountRes, err := instance.FPDFPage_CountObjects(&requests.FPDFPage_CountObjects{
+		Page: pageRef,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法获取页面对象数量: %v", err)
+	}
+	for j := 0; j < objectCountRes.Count; j++ {
+		objRes, err := instance.FPDFPage_GetObject(&requests.FPDFPage_GetObject{
+			Page:  pageRef,
+			Index: j,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("无法获取页面对象: %v", err)
+		}
+		objTypeRes, err := instance.FPDFPageObj_GetType(&requests.FPDFPageObj_GetType{
+			PageObject: objRes.PageObject,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("无法获取页面对象类型: %v", err)
+		}
+		page.Objects = append(page.Objects, objTypeRes.Type)
+	}
+
+	textPageRes, err := instance.FPDFText_LoadPage(&requests.FPDFText_LoadPage{
+		Page: pageRef,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法加载页面文字: %v", err)
+	}
+	defer instance.FPDFText_ClosePage(&requests.FPDFText_ClosePage{
+		TextPage: textPageRes.TextPage,
+	})
+	charCountRes, err := instance.FPDFText_CountChars(&requests.FPDFText_CountChars{
+		TextPage: textPageRes.TextPage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法获取页面文字: %v", err)
+	}
+	if charCountRes.Count > 0 {
+		textRes, err := instance.FPDFText_GetText(&requests.FPDFText_GetText{
+			TextPage: textPageRes.TextPage,
+			Count:    charCountRes.Count,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("无法获取页面文字: %v", err)
+		}
+		page.Text = textRes.Text
+	}
+
+	// 链接和表单字段都是注释，按子类型统计
+	annotCountRes, err := instance.FPDFPage_GetAnnotCount(&requests.FPDFPage_GetAnnotCount{
+		Page: pageRef,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法获取注释数量: %v", err)
+	}
+	for j := 0; j < annotCountRes.Count; j++ {
+		annotRes, err := instance.FPDFPage_GetAnnot(&requests.FPDFPage_GetAnnot{
+			Page:  pageRef,
+			Index: j,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("无法获取注释: %v", err)
+		}
+		subtypeRes, err := instance.FPDFAnnot_GetSubtype(&requests.FPDFAnnot_GetSubtype{
+			Annotation: annotRes.Annotation,
+		})
+		instance.FPDFPage_CloseAnnot(&requests.FPDFPage_CloseAnnot{
+			Annotation: annotRes.Annotation,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("无法获取注释类型: %v", err)
+		}
+		switch subtypeRes.Subtype {
+		case enums.FPDF_ANNOT_SUBTYPE_LINK:
+			page.Links++
+		case enums.FPDF_ANNOT_SUBTYPE_WIDGET:
+			page.FormFields++
+		}
+	}
+
+	return page, nil
+}
+
+// countObjectTypes 按类型统计页面对象，如 "image=2, text=10"
+func countObjectTypes(objects []enums.FPDF_PAGEOBJ) string {
+	counts := make(map[enums.FPDF_PAGEOBJ]int)
+	for _, t := range objects {
+		counts[t]++
+	}
+	var parts []string
+	for t := enums.FPDF_PAGEOBJ_UNKNOWN; t <= enums.FPDF_PAGEOBJ_FORM; t++ {
+		if counts[t] > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", pageObjectTypeNames[t], counts[t]))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// CompareStructure 比较保存前后的文档结构，不一致时返回 *StructureError
+func CompareStructure(before, after *DocumentStructure) error {
+	var problems []string
+
+	if len(before.Pages) != len(after.Pages) {
+		problems = append(problems, fmt.Sprintf("页数 %d != %d", len(before.Pages), len(after.Pages)))
+	}
+
+	for i := 0; i < len(before.Pages) && i < len(after.Pages); i++ {
+		b, a := before.Pages[i], after.Pages[i]
+		prefix := fmt.Sprintf("第 %d 页", i+1)
+
+		// 保存时尺寸会按浮点格式化，允许微小误差
+		if math.Abs(b.Width-a.Width) > 0.01 || math.Abs(b.Height-a.Height) > 0.01 {
+			problems = append(problems, fmt.Sprintf("%s尺寸 %.2fx%.2f != %.2fx%.2f", prefix, b.Width, b.Height, a.Width, a.Height))
+		}
+
+		sameObjects := len(b.Objects) == len(a.Objects)
+		for j := 0; sameObjects && j < len(b.Objects); j++ {
+			sameObjects = b.Objects[j] == a.Objects[j]
+		}
+		if !sameObjects {
+			problems = append(problems, fmt.Sprintf("%s页面对象 [%s] != [%s]", prefix, countObjectTypes(b.Objects), countObjectTypes(a.Objects)))
+		}
+
+		if b.Text != a.Text {
+			problems = append(problems, fmt.Sprintf("%s文字不一致（%d 个字符 != %d 个字符）", prefix, len([]rune(b.Text)), len([]rune(a.Text))))
+		}
+		if b.Links != a.Links {
+			problems = append(problems, fmt.Sprintf("%s链接 %d != %d", prefix, b.Links, a.Links))
+		}
+		if b.FormFields != a.FormFields {
+			problems = append(problems, fmt.Sprintf("%s表单字段 %d != %d", prefix, b.FormFields, a.FormFields))
+		}
+	}
+
+	if strings.Join(before.Bookmarks, "\n") != strings.Join(after.Bookmarks, "\n") {
+		problems = append(problems, fmt.Sprintf("书签 %d 个 != %d 个", len(before.Bookmarks), len(after.Bookmarks)))
+	}
+
+	if len(problems) > 0 {
+		return &StructureError{Problems: problems}
+	}
+	return nil
+}
+
+// ValidateOutput 重新加载保存结果，与保存前读取的结构比较，不一致时返回 *StructureError
+func ValidateOutput(ctx context.Context, instance pdfium.Pdfium, before *DocumentStructure, saved PDFInput) error {
+	document, err := LoadDocument(instance, saved)
+	if err != nil {
+		return &StructureError{Problems: []string{fmt.Sprintf("无法重新加载: %v", err)}}
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
+		Document: document,
+	})
+
+	after, err := ReadStructure(ctx, instance, document)
+	if err != nil {
+		return fmt.Errorf("无法读取保存结果: %w", err)
+	}
+	return CompareStructure(before, after)
+}
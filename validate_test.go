@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareStructure(t *testing.T) {
+	before := &DocumentStructure{
+		Pages: []PageStructure{
+			{Width: 595, Height: 842, Objects: []enums.FPDF_PAGEOBJ{enums.FPDF_PAGEOBJ_TEXT, enums.FPDF_PAGEOBJ_IMAGE}, Text: "hello", Links: 1, FormFields: 2},
+			{Width: 595, Height: 842},
+		},
+		Bookmarks: []string{"a", "a/b"},
+	}
+	assert.Nil(t, CompareStructure(before, before))
+
+	after := &DocumentStructure{
+		Pages: []PageStructure{
+			{Width: 595.001, Height: 842, Objects: []enums.FPDF_PAGEOBJ{enums.FPDF_PAGEOBJ_TEXT, enums.FPDF_PAGEOBJ_PATH}, Text: "hell", FormFields: 2},
+		},
+		Bookmarks: []string{"a"},
+	}
+	err := CompareStructure(before, after)
+	var structureErr *StructureError
+	assert.True(t, errors.As(err, &structureErr))
+	assert.Equal(t, []string{
+		"页数 2 != 1",
+		"第 1 页页面对象 [text=1, image=1] != [text=1, path=1]",
+		"第 1 页文字不一致（5 个字符 != 4 个字符）",
+		"第 1 页链接 1 != 0",
+		"书签 2 个 != 1 个",
+	}, structureErr.Problems)
+}
+
+func TestValidateOutput(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	pdf := newTestPDF(t, processor, 2)
+	single := newTestPDF(t, processor, 1)
+	processor.Run(context.Background(), nil, nil)
+	instance, err := processor.pool.GetInstance(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	ctx := context.Background()
+	document, err := LoadDocument(instance, PDFInput{Data: pdf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{Document: document})
+	structure, err := ReadStructure(ctx, instance, document)
+	assert.Nil(t, err)
+	assert.Len(t, structure.Pages, 2)
+	assert.Equal(t, []enums.FPDF_PAGEOBJ{enums.FPDF_PAGEOBJ_IMAGE}, structure.Pages[0].Objects)
+
+	// 压缩只替换图片数据，结构不变
+	opts, _ := NewCompressOptions(60, 100, 0)
+	opts.Validate = true
+	out := filepath.Join(t.TempDir(), "out.pdf")
+	assert.Nil(t, CompressImages(ctx, instance, PDFInput{Data: pdf}, PDFOutput{Path: out}, opts))
+	data, err := os.ReadFile(out)
+	assert.Nil(t, err)
+	assert.Less(t, len(data), len(pdf))
+	assert.Nil(t, ValidateOutput(ctx, instance, structure, PDFInput{Data: data}))
+
+	// 少了一页
+	err = ValidateOutput(ctx, instance, structure, PDFInput{Data: single})
+	var structureErr *StructureError
+	assert.True(t, errors.As(err, &structureErr))
+	assert.Equal(t, exitRejected, exitCode(err))
+
+	// 无法加载的结果
+	err = ValidateOutput(ctx, instance, structure, PDFInput{Data: bytes.Repeat([]byte("x"), 16)})
+	assert.True(t, errors.As(err, &structureErr))
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"compress-pdfium/util"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+// maxPSNR 两页完全相同时 PSNR 为无穷大，记为该值，便于输出 JSON
+const maxPSNR = 100
+
+// VerifyOptions 渲染比对参数
+type VerifyOptions struct {
+	DPI     float64 // 渲染分辨率，默认 72
+	MinPSNR float64 // PSNR 低于该值（dB）的页面不合格，默认 30
+	MinSSIM float64 // SSIM 低于该值的页面不合格，默认 0.9
+	DiffDir string  // 非空时把不合格页面的差异图写入该目录
+	Reject  bool    // 为 true 时有页面不合格就不写出结果，返回 *FidelityError
+}
+
+func (o VerifyOptions) withDefaults() VerifyOptions {
+	if o.DPI <= 0 {
+		o.DPI = 72
+	}
+	if o.MinPSNR <= 0 {
+		o.MinPSNR = 30
+	}
+	if o.MinSSIM <= 0 {
+		o.MinSSIM = 0.9
+	}
+	return o
+}
+
+// PageFidelity 单页的比对结果
+type PageFidelity struct {
+	Page     int     `json:"page"` // 从 1 开始
+	PSNR     float64 `json:"psnr"`
+	SSIM     float64 `json:"ssim"`
+	Passed   bool    `json:"passed"`
+	DiffPath string  `json:"diff_path,omitempty"`
+}
+
+// VerifyReport 比对报告
+type VerifyReport struct {
+	Pages  []PageFidelity `json:"pages"`
+	Failed []int          `json:"failed,omitempty"` // 不合格的页码
+}
+
+// Passed 是否所有页面都合格
+func (r *VerifyReport) Passed() bool {
+	return len(r.Failed) == 0
+}
+
+// Print 输出每页的 PSNR、SSIM 和不合格页面
+func (r *VerifyReport) Print(w io.Writer) {
+	for _, p := range r.Pages {
+		status := "通过"
+		if !p.Passed {
+			status = "不合格"
+		}
+		fmt.Fprintf(w, "第 %d 页: PSNR %.2fdB SSIM %.4f %s", p.Page, p.PSNR, p.SSIM, status)
+		if p.DiffPath != "" {
+			fmt.Fprintf(w, " 差异图: %s", p.DiffPath)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// FidelityError 压缩结果有页面与原文档差异过大
+type FidelityError struct {
+	Report *VerifyReport
+}
+
+func (e *FidelityError) Error() string {
+	pages := make([]string, 0, len(e.Report.Failed))
+	for _, p := range e.Report.Failed {
+		pages = append(pages, fmt.Sprint(p))
+	}
+	return fmt.Sprintf("第 %s 页与原文档差异过大", strings.Join(pages, ", "))
+}
+
+// VerifyRender 逐页渲染原文档和压缩结果，计算 PSNR 和 SSIM，低于阈值的页面记为不合格
+func VerifyRender(ctx context.Context, instance pdfium.Pdfium, original, compressed PDFInput, opts VerifyOptions) (*VerifyReport, error) {
+	opts = opts.withDefaults()
+
+	originalDoc, err := LoadDocument(instance, original)
+	if err != nil {
+		return nil, fmt.Errorf("无法加载原文档=%s: %w", original.name(), err)
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
+		Document: originalDoc,
+	})
+
+	compressedDoc, err := LoadDocument(instance, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("无法加载压缩结果: %w", err)
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
+		Document: compressedDoc,
+	})
+
+	originalCount, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
+		Document: originalDoc,
+	})
+	if err != nil {
+		return nil, err
+	}
+	compressedCount, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
+		Document: compressedDoc,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if originalCount.PageCount != compressedCount.PageCount {
+		return nil, fmt.Errorf("页数不一致: %d != %d", originalCount.PageCount, compressedCount.PageCount)
+	}
+
+	report := &VerifyReport{}
+	for i := 0; i < originalCount.PageCount; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		before, err := RenderPage(instance, originalDoc, i, opts.DPI)
+		if err != nil {
+			return nil, err
+		}
+		after, err := RenderPage(instance, compressedDoc, i, opts.DPI)
+		if err != nil {
+			return nil, err
+		}
+
+		page := PageFidelity{Page: i + 1}
+		if page.PSNR, err = util.PSNR(before, after); err != nil {
+			return nil, fmt.Errorf("第 %d 页: %v", i+1, err)
+		}
+		if page.PSNR > maxPSNR {
+			page.PSNR = maxPSNR
+		}
+		if page.SSIM, err = util.SSIM(before, after); err != nil {
+			return nil, fmt.Errorf("第 %d 页: %v", i+1, err)
+		}
+
+		page.Passed = page.PSNR >= opts.MinPSNR && page.SSIM >= opts.MinSSIM
+		if !page.Passed {
+			report.Failed = append(report.Failed, page.Page)
+			if opts.DiffDir != "" {
+				if page.DiffPath, err = writeDiffImage(opts.DiffDir, original.name(), page.Page, before, after); err != nil {
+					return nil, err
+				}
+			}
+		}
+		report.Pages = append(report.Pages, page)
+	}
+
+	return report, nil
+}
+
+// RenderPage 用 FPDF_RenderPageBitmap 把页面渲染为白底图片，包含注释
+func RenderPage(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, index int, dpi float64) (image.Image, error) {
+	sizeRes, err := instance.FPDF_GetPageSizeByIndex(&requests.FPDF_GetPageSizeByIndex{
+		Document: document,
+		Index:    index,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法获取页面尺寸: %v", err)
+	}
+	width := int(math.Round(sizeRes.Width * dpi / 72))
+	height := int(math.Round(sizeRes.Height * dpi / 72))
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("第 %d 页尺寸无效: %.1fx%.1f", index+1, sizeRes.Width, sizeRes.Height)
+	}
+
+	pdfPage, err := instance.FPDF_LoadPage(&requests.FPDF_LoadPage{
+		Document: document,
+		Index:    index,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法加载页面: %v", err)
+	}
+	defer instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
+		Page: pdfPage.Page,
+	})
+	return renderLoadedPage(instance, requests.Page{ByReference: &pdfPage.Page}, width, height)
+}
+
+// renderLoadedPage 把已加载的页面渲染为 width x height 的图片，白色背景，包含注释
+func renderLoadedPage(instance pdfium.Pdfium, page requests.Page, width, height int) (image.Image, error) {
+	bitmapRes, err := instance.FPDFBitmap_Create(&requests.FPDFBitmap_Create{
+		Width:  width,
+		Height: height,
+		Alpha:  0,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法创建位图: %v", err)
+	}
+	defer instance.FPDFBitmap_Destroy(&requests.FPDFBitmap_Destroy{
+		Bitmap: bitmapRes.Bitmap,
+	})
+
+	if _, err = instance.FPDFBitmap_FillRect(&requests.FPDFBitmap_FillRect{
+		Bitmap: bitmapRes.Bitmap,
+		Width:  width,
+		Height: height,
+		Color:  0xFFFFFFFF,
+	}); err != nil {
+		return nil, fmt.Errorf("无法填充位图: %v", err)
+	}
+
+	if _, err = instance.FPDF_RenderPageBitmap(&requests.FPDF_RenderPageBitmap{
+		Bitmap: bitmapRes.Bitmap,
+		Page:   page,
+		SizeX:  width,
+		SizeY:  height,
+		Flags:  enums.FPDF_RENDER_FLAG_ANNOT,
+	}); err != nil {
+		return nil, fmt.Errorf("无法渲染页面: %v", err)
+	}
+
+	bufferRes, err := instance.FPDFBitmap_GetBuffer(&requests.FPDFBitmap_GetBuffer{
+		Bitmap: bitmapRes.Bitmap,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法获取位图数据: %v", err)
+	}
+	strideRes, err := instance.FPDFBitmap_GetStride(&requests.FPDFBitmap_GetStride{
+		Bitmap: bitmapRes.Bitmap,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法获取位图步长: %v", err)
+	}
+
+	// 不带 alpha 创建的位图为 BGRx
+	_, img, err := util.RenderImage(bufferRes.Buffer, width, height, strideRes.Stride, int(enums.FPDF_BITMAP_FORMAT_BGRX))
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// writeDiffImage 写出差异图，返回文件路径
+func writeDiffImage(dir, name string, page int, before, after image.Image) (string, error) {
+	diff, err := util.DiffImage(before, after)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s-page-%d-diff.png", strings.TrimSuffix(name, filepath.Ext(name)), page))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err = png.Encode(f, diff); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// CompressAndVerify 压缩到内存后与原文档逐页比对，再写入 out
+// verify.Reject 为 true 且有页面不合格时不写出结果，返回报告和 *FidelityError
+func CompressAndVerify(ctx context.Context, instance pdfium.Pdfium, in PDFInput, out PDFOutput, opts CompressOptions, verify VerifyOptions) (*VerifyReport, error) {
+	var compressed bytes.Buffer
+	if err := CompressImages(ctx, instance, in, PDFOutput{Writer: &compressed}, opts); err != nil {
+		return nil, err
+	}
+
+	// 流式输入在压缩时已读到末尾，比对时需要重新读取
+	if in.Reader != nil {
+		if _, err := in.Reader.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("无法重置输入流: %v", err)
+		}
+	}
+
+	report, err := VerifyRender(ctx, instance, in, PDFInput{Data: compressed.Bytes(), Name: in.name()}, verify)
+	if err != nil {
+		return nil, fmt.Errorf("无法校验压缩结果: %w", err)
+	}
+	if verify.Reject && !report.Passed() {
+		return report, &FidelityError{Report: report}
+	}
+
+	if err = WriteOutput(out, compressed.Bytes()); err != nil {
+		return report, fmt.Errorf("无法保存 PDF: %v", err)
+	}
+	return report, nil
+}
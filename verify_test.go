@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyRender(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	pdf := newTestPDF(t, processor, 2)
+
+	// 模拟位图格式处理错误导致的偏色
+	tinted := image.NewRGBA(image.Rect(0, 0, 1200, 1600))
+	for y := 0; y < 1600; y++ {
+		for x := 0; x < 1200; x++ {
+			tinted.Set(x, y, color.RGBA{R: uint8(x ^ y), G: uint8(y), B: uint8(x), A: 255})
+		}
+	}
+	tintedPDF := newTestPDFWithImage(t, processor, 2, tinted)
+
+	processor.Run(context.Background(), nil, nil)
+	instance, err := processor.pool.GetInstance(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	report, err := VerifyRender(ctx, instance, PDFInput{Data: pdf}, PDFInput{Data: pdf}, VerifyOptions{})
+	assert.Nil(t, err)
+	assert.True(t, report.Passed())
+	assert.Len(t, report.Pages, 2)
+	assert.Equal(t, float64(maxPSNR), report.Pages[0].PSNR)
+	assert.InDelta(t, 1, report.Pages[0].SSIM, 1e-9)
+
+	report, err = VerifyRender(ctx, instance, PDFInput{Data: pdf, Name: "a.pdf"}, PDFInput{Data: tintedPDF}, VerifyOptions{DiffDir: dir})
+	assert.Nil(t, err)
+	assert.False(t, report.Passed())
+	assert.Equal(t, []int{1, 2}, report.Failed)
+	assert.Equal(t, filepath.Join(dir, "a-page-1-diff.png"), report.Pages[0].DiffPath)
+	_, err = os.Stat(report.Pages[0].DiffPath)
+	assert.Nil(t, err)
+
+	// 正常压缩的结果通过校验
+	out := filepath.Join(dir, "out.pdf")
+	opts, _ := NewCompressOptions(80, DPIRecommend, 0)
+	report, err = CompressAndVerify(ctx, instance, PDFInput{Data: pdf}, PDFOutput{Path: out}, opts, VerifyOptions{Reject: true, MinPSNR: 25, MinSSIM: 0.8})
+	assert.Nil(t, err)
+	assert.True(t, report.Passed())
+	_, err = os.Stat(out)
+	assert.Nil(t, err)
+
+	// 阈值无法达到时拒绝输出
+	rejected := filepath.Join(dir, "rejected.pdf")
+	report, err = CompressAndVerify(ctx, instance, PDFInput{Data: pdf}, PDFOutput{Path: rejected}, opts, VerifyOptions{Reject: true, MinPSNR: 99})
+	var fidelityErr *FidelityError
+	assert.True(t, errors.As(err, &fidelityErr))
+	assert.Equal(t, report, fidelityErr.Report)
+	_, err = os.Stat(rejected)
+	assert.True(t, os.IsNotExist(err))
+}
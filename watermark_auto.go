@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/structs"
+)
+
+// autoPlaceDPI 自动选择位置时渲染页面的分辨率，只需要看出哪里有内容
+const autoPlaceDPI = 24
+
+// inkThreshold 亮度与背景相差超过该值的像素算作有内容
+const inkThreshold = 32
+
+// autoPlacePadding 评估位置时在水印四周多看的距离（点），避免水印紧贴着文字
+const autoPlacePadding = 6
+
+// autoPlaceTolerance 覆盖比例相差不到该值时认为一样空，保留排在前面的位置
+const autoPlaceTolerance = 0.01
+
+// defaultCandidates 自动选择时默认可选的位置，Anchor 排在最前面
+var defaultCandidates = []Anchor{AnchorBottomRight, AnchorBottomLeft, AnchorTopRight, AnchorTopLeft}
+
+// Placement 水印实际放置的位置。坐标为显示坐标（原点在页面显示时的左下角），单位为点，
+// 矩形为水印旋转后的外接矩形
+type Placement struct {
+	Page     int     `json:"page"` // 从 1 开始
+	Anchor   Anchor  `json:"anchor"`
+	Left     float64 `json:"left"`
+	Bottom   float64 `json:"bottom"`
+	Width    float64 `json:"width"`
+	Height   float64 `json:"height"`
+	Auto     bool    `json:"auto,omitempty"`     // 是否自动选择
+	Coverage float64 `json:"coverage,omitempty"` // 自动选择时该位置被原有内容覆盖的比例，0 表示空白
+
+	// 设置了 Contrast 时水印下方背景的相对亮度（0 为黑色，1 为白色）、水印与背景的对比度，
+	// 以及因对比度不足采取的处理，对比度足够时 Adjust 为空
+	Background float64      `json:"background,omitempty"`
+	Contrast   float64      `json:"contrast,omitempty"`
+	Adjust     ContrastMode `json:"adjust,omitempty"`
+}
+
+func (p Placement) String() string {
+	s := fmt.Sprintf("第 %d 页: %s (%.0f, %.0f) %.0fx%.0f", p.Page, p.Anchor, p.Left, p.Bottom, p.Width, p.Height)
+	if p.Auto {
+		s += fmt.Sprintf("，内容覆盖 %.1f%%", p.Coverage*100)
+	}
+	if p.Contrast > 0 {
+		s += fmt.Sprintf("，背景亮度 %.2f，对比度 %.1f", p.Background, p.Contrast)
+	}
+	if p.Adjust != "" {
+		s += fmt.Sprintf("，使用 %s", p.Adjust)
+	}
+	return s
+}
+
+// candidates 返回自动选择时依次评估的位置，覆盖比例相同时取靠前的
+func (o WatermarkOptions) candidates() []Anchor {
+	if len(o.Candidates) > 0 {
+		return o.Candidates
+	}
+	anchors := []Anchor{o.Anchor}
+	for _, a := range defaultCandidates {
+		if a != o.Anchor {
+			anchors = append(anchors, a)
+		}
+	}
+	return anchors
+}
+
+// bounds 返回旋转后外接矩形的左下角和宽高，显示坐标
+func (b watermarkBox) bounds() (left, bottom, width, height float64) {
+	sin, cos := math.Abs(math.Sin(b.Rotation)), math.Abs(math.Cos(b.Rotation))
+	width = b.Width*cos + b.Height*sin
+	height = b.Width*sin + b.Height*cos
+	return b.CenterX - width/2, b.CenterY - height/2, width, height
+}
+
+// watermarkPage 正在添加水印的页面，记录各水印层放置的位置
+type watermarkPage struct {
+	pageBox
+	handle     requests.Page
+	content    *pageContent // 自动选择位置、调整对比度时才加载
+	placements []Placement
+}
+
+// layout 与 WatermarkOptions.layout 相同，不平铺时记录位置，Auto 时选择最空的位置
+func (p *watermarkPage) layout(instance pdfium.Pdfium, o WatermarkOptions, width, height float64) ([]watermarkBox, error) {
+	if o.Tile {
+		return o.layout(p.pageBox, width, height)
+	}
+	box, err := p.place(instance, o, width, height)
+	if err != nil {
+		return nil, err
+	}
+	return []watermarkBox{box}, nil
+}
+
+// place 与 WatermarkOptions.place 相同，Auto 时在候选位置中选择被原有内容覆盖最少的
+func (p *watermarkPage) place(instance pdfium.Pdfium, o WatermarkOptions, width, height float64) (watermarkBox, error) {
+	box := o.place(p.pageBox, width, height)
+	placement := Placement{Anchor: o.Anchor, Auto: o.Auto}
+
+	if o.Auto {
+		content, err := p.loadContent(instance)
+		if err != nil {
+			return box, err
+		}
+		best := -1.0
+		for _, anchor := range o.candidates() {
+			candidate := o
+			candidate.Anchor = anchor
+			b := candidate.place(p.pageBox, width, height)
+			coverage := content.coverage(b.bounds())
+			if best < 0 || coverage < best-autoPlaceTolerance {
+				box, best, placement.Anchor = b, coverage, anchor
+			}
+		}
+		placement.Coverage = best
+	}
+
+	placement.Left, placement.Bottom, placement.Width, placement.Height = box.bounds()
+	p.placements = append(p.placements, placement)
+	return box, nil
+}
+
+// loadContent 第一次使用时渲染页面
+func (p *watermarkPage) loadContent(instance pdfium.Pdfium) (*pageContent, error) {
+	if p.content == nil {
+		content, err := loadPageContent(instance, p.pageBox, p.handle)
+		if err != nil {
+			return nil, err
+		}
+		p.content = content
+	}
+	return p.content, nil
+}
+
+// pageContent 页面上哪些地方有内容：低分辨率渲染中与背景不同的像素，加上文字所在的矩形
+type pageContent struct {
+	width, height int
+	scale         float64 // 每点对应的像素数
+	gray          []uint8 // 渲染结果的亮度
+	occupied      []bool
+}
+
+// loadPageContent 渲染已加载的页面并读取文字位置。
+// 页面尚未重新生成内容，刚删除的旧水印不会被渲染，也不会被当作文字
+func loadPageContent(instance pdfium.Pdfium, box pageBox, page requests.Page) (*pageContent, error) {
+	c := &pageContent{scale: autoPlaceDPI / 72.0}
+	c.width = int(math.Ceil(box.Width * c.scale))
+	c.height = int(math.Ceil(box.Height * c.scale))
+	if c.width <= 0 || c.height <= 0 {
+		return nil, fmt.Errorf("页面尺寸无效: %.1fx%.1f", box.Width, box.Height)
+	}
+	img, err := renderLoadedPage(instance, page, c.width, c.height)
+	if err != nil {
+		return nil, err
+	}
+
+	// 背景取最常见的亮度，扫描件、彩色底的页面也能分出内容
+	c.gray = make([]uint8, c.width*c.height)
+	gray := c.gray
+	var histogram [256]int
+	for y := 0; y < c.height; y++ {
+		for x := 0; x < c.width; x++ {
+			v := color.GrayModel.Convert(img.At(img.Bounds().Min.X+x, img.Bounds().Min.Y+y)).(color.Gray).Y
+			gray[y*c.width+x] = v
+			histogram[v]++
+		}
+	}
+	background := 0
+	for v := range histogram {
+		if histogram[v] > histogram[background] {
+			background = v
+		}
+	}
+	c.occupied = make([]bool, len(gray))
+	for i, v := range gray {
+		c.occupied[i] = math.Abs(float64(v)-float64(background)) > inkThreshold
+	}
+
+	if err = c.addText(instance, box, page); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// addText 把文字的矩形标记为有内容，浅色文字、字间的空白也不会被当作空白处
+func (c *pageContent) addText(instance pdfium.Pdfium, box pageBox, page requests.Page) error {
+	textPageRes, err := instance.FPDFText_LoadPage(&requests.FPDFText_LoadPage{
+		Page: page,
+	})
+	if err != nil {
+		return fmt.Errorf("无法加载页面文字: %v", err)
+	}
+	defer instance.FPDFText_ClosePage(&requests.FPDFText_ClosePage{
+		TextPage: textPageRes.TextPage,
+	})
+
+	countRes, err := instance.FPDFText_CountRects(&requests.FPDFText_CountRects{
+		TextPage:   textPageRes.TextPage,
+		StartIndex: 0,
+		Count:      -1,
+	})
+	if err != nil {
+		return fmt.Errorf("无法获取文字位置: %v", err)
+	}
+
+	// 文字矩形为用户坐标，换算到显示坐标
+	toDisplay := invert(box.transform())
+	for i := 0; i < countRes.Count; i++ {
+		rect, err := instance.FPDFText_GetRect(&requests.FPDFText_GetRect{
+			TextPage: textPageRes.TextPage,
+			Index:    i,
+		})
+		if err != nil {
+			return fmt.Errorf("无法获取文字位置: %v", err)
+		}
+		x0, y0 := apply(toDisplay, rect.Left, rect.Bottom)
+		x1, y1 := apply(toDisplay, rect.Right, rect.Top)
+		c.fill(math.Min(x0, x1), math.Min(y0, y1), math.Abs(x1-x0), math.Abs(y1-y0))
+	}
+	return nil
+}
+
+// pixels 返回显示坐标中的矩形覆盖的像素范围，已裁剪到页面内
+func (c *pageContent) pixels(left, bottom, width, height float64) image.Rectangle {
+	r := image.Rect(
+		int(math.Floor(left*c.scale)),
+		c.height-int(math.Ceil((bottom+height)*c.scale)),
+		int(math.Ceil((left+width)*c.scale)),
+		c.height-int(math.Floor(bottom*c.scale)),
+	)
+	return r.Intersect(image.Rect(0, 0, c.width, c.height))
+}
+
+func (c *pageContent) fill(left, bottom, width, height float64) {
+	r := c.pixels(left, bottom, width, height)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c.occupied[y*c.width+x] = true
+		}
+	}
+}
+
+// coverage 返回矩形（连同四周 autoPlacePadding）内有内容的像素比例
+func (c *pageContent) coverage(left, bottom, width, height float64) float64 {
+	r := c.pixels(left-autoPlacePadding, bottom-autoPlacePadding, width+2*autoPlacePadding, height+2*autoPlacePadding)
+	if r.Empty() {
+		return 0
+	}
+	n := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if c.occupied[y*c.width+x] {
+				n++
+			}
+		}
+	}
+	return float64(n) / float64(r.Dx()*r.Dy())
+}
+
+// invert 返回仿射矩阵的逆矩阵
+func invert(m structs.FPDF_FS_MATRIX) structs.FPDF_FS_MATRIX {
+	a, b, c, d, e, f := float64(m.A), float64(m.B), float64(m.C), float64(m.D), float64(m.E), float64(m.F)
+	det := a*d - b*c
+	return structs.FPDF_FS_MATRIX{
+		A: float32(d / det),
+		B: float32(-b / det),
+		C: float32(-c / det),
+		D: float32(a / det),
+		E: float32((c*f - d*e) / det),
+		F: float32((b*e - a*f) / det),
+	}
+}
+
+// apply 用矩阵变换点 (x, y)
+func apply(m structs.FPDF_FS_MATRIX, x, y float64) (float64, float64) {
+	return float64(m.A)*x + float64(m.C)*y + float64(m.E), float64(m.B)*x + float64(m.D)*y + float64(m.F)
+}
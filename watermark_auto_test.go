@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatermarkAuto(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	pdf := newTestPDF(t, processor, 2)
+	processor.Run(context.Background(), nil, nil)
+	instance, err := processor.pool.GetInstance(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	// 第 2 页旋转 90 度，显示为 842x595
+	doc, err := LoadDocument(instance, PDFInput{Data: pdf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageRes, err := instance.FPDF_LoadPage(&requests.FPDF_LoadPage{Document: doc, Index: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = instance.FPDFPage_SetRotation(&requests.FPDFPage_SetRotation{Page: requests.Page{ByReference: &pageRes.Page}, Rotate: enums.FPDF_PAGE_ROTATION_90_CW})
+	assert.Nil(t, err)
+	instance.FPDF_ClosePage(&requests.FPDF_ClosePage{Page: pageRes.Page})
+	var rotated bytes.Buffer
+	assert.Nil(t, SaveDocument(instance, doc, PDFOutput{Writer: &rotated}, 0))
+	instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{Document: doc})
+
+	// 右下角是黑色文字，右上角是白色文字（渲染出来看不见，只能从文字位置得知），
+	// 左侧中间是原有的图片，只有左上角是空的
+	black := DefaultStampSpec("SIGNATURE SIGNATURE")
+	black.Anchor = AnchorBottomRight
+	white := DefaultStampSpec("INVISIBLE INVISIBLE")
+	white.Anchor, white.Color = AnchorTopRight, Color{255, 255, 255, 255}
+	var stamped bytes.Buffer
+	_, err = AddStamps(context.Background(), instance, PDFInput{Data: rotated.Bytes()}, PDFOutput{Writer: &stamped}, StampOptions{Stamps: []StampSpec{black, white}})
+	assert.Nil(t, err)
+
+	logo := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	for i := range logo.Pix {
+		logo.Pix[i] = []uint8{255, 0, 0, 255}[i%4]
+	}
+	var logoData bytes.Buffer
+	assert.Nil(t, png.Encode(&logoData, logo))
+	logoPath := filepath.Join(t.TempDir(), "logo.png")
+	assert.Nil(t, os.WriteFile(logoPath, logoData.Bytes(), 0644))
+
+	opts := DefaultWatermarkOptions()
+	opts.Auto = true
+	opts.Candidates = []Anchor{AnchorBottomRight, AnchorTopRight, AnchorLeft, AnchorTopLeft}
+	var out bytes.Buffer
+	result, err := Watermark(context.Background(), instance, logoPath, PDFInput{Data: stamped.Bytes()}, PDFOutput{Writer: &out}, opts)
+	assert.Nil(t, err)
+	if assert.Len(t, result.Placements, 2) {
+		for i, p := range result.Placements {
+			assert.Equal(t, i+1, p.Page)
+			assert.Equal(t, AnchorTopLeft, p.Anchor, "第 %d 页", i+1)
+			assert.True(t, p.Auto)
+			assert.Less(t, p.Coverage, 0.01)
+			assert.InDelta(t, 59.5, p.Width, 0.5)
+		}
+		// 边距按显示时的宽高计算
+		assert.InDelta(t, 595*0.035, result.Placements[0].Left, 0.5)
+		assert.InDelta(t, 842*0.035, result.Placements[1].Left, 0.5)
+		assert.InDelta(t, 842-842*0.014-29.75, result.Placements[0].Bottom, 0.5)
+		assert.InDelta(t, 595-595*0.014-29.75, result.Placements[1].Bottom, 0.5)
+	}
+	bounds, _ := redBounds(renderFirstPage(t, instance, out.Bytes()), 0)
+	assert.InDelta(t, 21, bounds.Min.X, 2)
+	assert.InDelta(t, 842*0.014, bounds.Min.Y, 2)
+
+	// 再次添加时旧水印已删除，不会被当作内容；左上、左下一样空，取靠前的左上
+	opts.Candidates = []Anchor{AnchorTopLeft, AnchorBottomLeft}
+	result, err = Watermark(context.Background(), instance, logoPath, PDFInput{Data: out.Bytes()}, PDFOutput{Writer: &bytes.Buffer{}}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, AnchorTopLeft, result.Placements[0].Anchor)
+
+	// 不自动选择时也返回位置
+	result, err = Watermark(context.Background(), instance, logoPath, PDFInput{Data: pdf}, PDFOutput{Writer: &bytes.Buffer{}}, DefaultWatermarkOptions())
+	assert.Nil(t, err)
+	if assert.Len(t, result.Placements, 2) {
+		assert.Equal(t, AnchorBottomRight, result.Placements[0].Anchor)
+		assert.False(t, result.Placements[0].Auto)
+	}
+
+	opts.Tile = true
+	_, err = Watermark(context.Background(), instance, logoPath, PDFInput{Data: pdf}, PDFOutput{Writer: &bytes.Buffer{}}, opts)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"compress-pdfium/util"
+	"fmt"
+	"image/color"
+	"sort"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+)
+
+// ContrastMode 水印与下方页面背景的对比度不足时的处理
+type ContrastMode string
+
+const (
+	ContrastNone    ContrastMode = "none"
+	ContrastVariant ContrastMode = "variant" // 换用明暗相反的变体，如白色 logo 放在白色页面上时换成深色的
+	ContrastPlate   ContrastMode = "plate"   // 在水印下方垫一块底板
+	ContrastOutline ContrastMode = "outline" // 给水印加一圈描边
+)
+
+// defaultMinContrast 默认的最低对比度，与 WCAG 对图形的要求相同
+const defaultMinContrast = 3
+
+// validateContrast 检查对比度相关的参数，Contrast 为空时不处理
+func (o *WatermarkOptions) validateContrast() error {
+	switch o.Contrast {
+	case "":
+		o.Contrast = ContrastNone
+	case ContrastNone, ContrastVariant, ContrastPlate, ContrastOutline:
+	default:
+		return fmt.Errorf("未知的对比度处理 %q，可选 none、variant、plate、outline", o.Contrast)
+	}
+	if o.MinContrast == 0 {
+		o.MinContrast = defaultMinContrast
+	}
+	if o.MinContrast < 1 || o.MinContrast > 21 {
+		return fmt.Errorf("min_contrast 必须在 1-21 之间: %g", o.MinContrast)
+	}
+	if o.OutlineWidth == 0 {
+		o.OutlineWidth = 1.5
+	}
+	if o.OutlineWidth < 0 || o.PlatePadding.Value < 0 {
+		return fmt.Errorf("描边宽度和底板边距不能小于 0: %g, %s", o.OutlineWidth, o.PlatePadding)
+	}
+	return nil
+}
+
+// contrastColor 底板、描边的颜色，未指定时取与水印对比度更高的白色或黑色
+func (o WatermarkOptions) contrastColor(luminance float64) Color {
+	if o.ContrastColor.A != 0 {
+		return o.ContrastColor
+	}
+	if util.ContrastRatio(luminance, 1) >= util.ContrastRatio(luminance, 0) {
+		return Color{255, 255, 255, 255}
+	}
+	return Color{0, 0, 0, 255}
+}
+
+func (c Color) nrgba() color.NRGBA {
+	return color.NRGBA{R: c.R, G: c.G, B: c.B, A: c.A}
+}
+
+// invertLightness 明暗相反的颜色，none 不变
+func (c Color) invertLightness() Color {
+	if c.A == 0 {
+		return c
+	}
+	n := util.InvertColorLightness(c.nrgba())
+	return Color{R: n.R, G: n.G, B: n.B, A: n.A}
+}
+
+// contrastChoice 某个位置上采取的对比度处理
+type contrastChoice struct {
+	variant bool
+	plate   bool
+	outline bool
+}
+
+// contrast 采样水印下方的页面背景，决定是否以及如何处理。
+// luminance、variant 为水印及其变体的相对亮度，原水印对比度足够时不做处理
+func (p *watermarkPage) contrast(instance pdfium.Pdfium, o WatermarkOptions, box watermarkBox, luminance, variant float64) (contrastChoice, error) {
+	var choice contrastChoice
+	if o.Contrast == ContrastNone || o.Contrast == "" {
+		return choice, nil
+	}
+	content, err := p.loadContent(instance)
+	if err != nil {
+		return choice, err
+	}
+	background := content.background(box.bounds())
+	ratio := util.ContrastRatio(luminance, background)
+	if ratio < o.MinContrast {
+		switch o.Contrast {
+		case ContrastVariant:
+			choice.variant = util.ContrastRatio(variant, background) > ratio
+		case ContrastPlate:
+			choice.plate = true
+		case ContrastOutline:
+			choice.outline = true
+		}
+	}
+
+	// 不平铺时 place 刚记录了这个位置
+	if !o.Tile && len(p.placements) > 0 {
+		placement := &p.placements[len(p.placements)-1]
+		placement.Background, placement.Contrast = background, ratio
+		if choice.variant || choice.plate || choice.outline {
+			placement.Adjust = o.Contrast
+		}
+	}
+	return choice, nil
+}
+
+// background 返回矩形内页面背景的相对亮度，取像素亮度的中位数，不受细小的文字、线条影响
+func (c *pageContent) background(left, bottom, width, height float64) float64 {
+	r := c.pixels(left, bottom, width, height)
+	if r.Empty() {
+		return 1
+	}
+	values := make([]uint8, 0, r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		values = append(values, c.gray[y*c.width+r.Min.X:y*c.width+r.Max.X]...)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return util.GrayLuminance(values[len(values)/2])
+}
+
+// newPlate 创建垫在水印下方的底板，比水印四周各大 padding，随水印旋转
+func newPlate(instance pdfium.Pdfium, box watermarkBox, padding float64, c Color, opacity float64) (references.FPDF_PAGEOBJECT, error) {
+	rectRes, err := instance.FPDFPageObj_CreateNewRect(&requests.FPDFPageObj_CreateNewRect{
+		X: float32(-padding),
+		Y: float32(-padding),
+		W: float32(box.Width + 2*padding),
+		H: float32(box.Height + 2*padding),
+	})
+	if err != nil {
+		return "", fmt.Errorf("无法创建底板: %v", err)
+	}
+	obj := rectRes.PageObject
+
+	err = func() error {
+		if _, err := instance.FPDFPath_SetDrawMode(&requests.FPDFPath_SetDrawMode{
+			PageObject: obj,
+			FillMode:   enums.FPDF_FILLMODE_WINDING,
+		}); err != nil {
+			return err
+		}
+		if _, err := instance.FPDFPageObj_SetFillColor(&requests.FPDFPageObj_SetFillColor{
+			PageObject: obj,
+			FillColor:  c.pdfColor(opacity),
+		}); err != nil {
+			return err
+		}
+		// 矩形的坐标以水印左下角为原点、单位为点，与内容大小为水印宽高时的矩阵一致
+		_, err := instance.FPDFPageObj_Transform(&requests.FPDFPageObj_Transform{
+			PageObject: obj,
+			Transform:  box.matrix(box.Width, box.Height),
+		})
+		return err
+	}()
+	if err != nil {
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: obj,
+		})
+		return "", fmt.Errorf("无法创建底板: %v", err)
+	}
+	return obj, nil
+}
+
+// grow 返回中心和旋转不变、四周各扩大 d 的位置
+func (b watermarkBox) grow(d float64) watermarkBox {
+	b.Width += 2 * d
+	b.Height += 2 * d
+	return b
+}
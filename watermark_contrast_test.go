@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatermarkContrast(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	// 页面 (50,50)-(350,450) 处是黑色图片，其余为白色
+	pdf := newTestPDFWithImage(t, processor, 1, image.NewGray(image.Rect(0, 0, 30, 40)))
+	processor.Run(context.Background(), nil, nil)
+	instance, err := processor.pool.GetInstance(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	// 黑色 logo
+	logo := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	for i := 3; i < len(logo.Pix); i += 4 {
+		logo.Pix[i] = 255
+	}
+	var logoData bytes.Buffer
+	assert.Nil(t, png.Encode(&logoData, logo))
+	logoPath := filepath.Join(t.TempDir(), "logo.png")
+	assert.Nil(t, os.WriteFile(logoPath, logoData.Bytes(), 0644))
+
+	// 居中放在黑色图片上，logo 为 (268,406)-(327,436)，在 72 DPI 渲染结果中 y 轴相反
+	gray := func(img image.Image, x, y int) uint8 {
+		return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+	}
+	watermark := func(mode ContrastMode) (image.Image, Placement) {
+		opts := DefaultWatermarkOptions()
+		opts.Anchor, opts.Contrast, opts.OutlineWidth = AnchorCenter, mode, 3
+		var out bytes.Buffer
+		result, err := Watermark(context.Background(), instance, logoPath, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode != ContrastNone {
+			assert.InDelta(t, 0, result.Placements[0].Background, 0.02)
+			assert.InDelta(t, 1, result.Placements[0].Contrast, 0.5)
+		}
+		return renderFirstPage(t, instance, out.Bytes()), result.Placements[0]
+	}
+
+	// 不处理时 logo 看不见
+	img, p := watermark(ContrastNone)
+	assert.Empty(t, p.Adjust)
+	assert.Less(t, gray(img, 297, 421), uint8(40))
+
+	// 换用亮度取反的白色 logo
+	img, p = watermark(ContrastVariant)
+	assert.Equal(t, ContrastVariant, p.Adjust)
+	assert.Greater(t, gray(img, 297, 421), uint8(215))
+
+	// 白色底板比 logo 四周各大 4pt
+	img, p = watermark(ContrastPlate)
+	assert.Equal(t, ContrastPlate, p.Adjust)
+	assert.Less(t, gray(img, 297, 421), uint8(40))
+	assert.Greater(t, gray(img, 297, 842-438), uint8(215))
+	assert.Greater(t, gray(img, 265, 421), uint8(215))
+	assert.Less(t, gray(img, 297, 842-444), uint8(40))
+
+	// 3pt 的白色描边
+	img, p = watermark(ContrastOutline)
+	assert.Equal(t, ContrastOutline, p.Adjust)
+	assert.Less(t, gray(img, 297, 421), uint8(40))
+	assert.Greater(t, gray(img, 297, 842-439), uint8(215))
+	assert.Less(t, gray(img, 297, 842-442), uint8(40))
+
+	// 白色背景上黑色 logo 的对比度足够，不处理
+	opts := DefaultWatermarkOptions()
+	opts.Contrast = ContrastPlate
+	result, err := Watermark(context.Background(), instance, logoPath, PDFInput{Data: pdf}, PDFOutput{Writer: &bytes.Buffer{}}, opts)
+	assert.Nil(t, err)
+	assert.Empty(t, result.Placements[0].Adjust)
+	assert.InDelta(t, 1, result.Placements[0].Background, 0.02)
+	assert.InDelta(t, 21, result.Placements[0].Contrast, 0.5)
+
+	// 白色文字放在白色背景上，描边时每行多一个文字对象
+	text := DefaultTextOptions()
+	text.Text, text.Color = "DRAFT", Color{255, 255, 255, 255}
+	opts.Contrast = ContrastOutline
+	var out bytes.Buffer
+	result, err = WatermarkText(context.Background(), instance, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, text, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, ContrastOutline, result.Placements[0].Adjust)
+	assert.Equal(t, []int{3}, objectCounts(t, instance, out.Bytes()))
+
+	opts.Contrast = ContrastVariant
+	out.Reset()
+	result, err = WatermarkText(context.Background(), instance, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, text, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, ContrastVariant, result.Placements[0].Adjust)
+	assert.Equal(t, []int{2}, objectCounts(t, instance, out.Bytes()))
+
+	opts.Contrast = "halo"
+	_, err = Watermark(context.Background(), instance, logoPath, PDFInput{Data: pdf}, PDFOutput{Writer: &bytes.Buffer{}}, opts)
+	assert.NotNil(t, err)
+}
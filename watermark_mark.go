@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/responses"
+)
+
+// 插入的水印对象都带一个名为 Artifact 的标记内容（表示不属于正文的装饰内容），
+// 参数 WatermarkID 记录水印标识，用来在之后找到、替换或删除这些对象
+const (
+	watermarkMarkName = "Artifact"
+	watermarkIDKey    = "WatermarkID"
+)
+
+// 各类水印的默认标识。再次添加同一标识的水印时先删除旧的，重复处理同一个文件不会叠加水印
+const (
+	WatermarkIDLogo  = "logo"
+	WatermarkIDText  = "text"
+	WatermarkIDStamp = "stamp"
+)
+
+// WatermarkInfo 文档中某个标识的水印
+type WatermarkInfo struct {
+	ID      string `json:"id"`
+	Pages   []int  `json:"pages"`   // 出现的页码，从 1 开始
+	Objects int    `json:"objects"` // 对象总数
+}
+
+// markObjects 给尚未插入页面的对象加上水印标记
+func markObjects(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, objects []references.FPDF_PAGEOBJECT, id string) error {
+	for _, obj := range objects {
+		markRes, err := instance.FPDFPageObj_AddMark(&requests.FPDFPageObj_AddMark{
+			PageObject: obj,
+			Name:       watermarkMarkName,
+		})
+		if err != nil {
+			return fmt.Errorf("无法添加水印标记: %v", err)
+		}
+		if _, err = instance.FPDFPageObjMark_SetStringParam(&requests.FPDFPageObjMark_SetStringParam{
+			Document:       document,
+			PageObject:     obj,
+			PageObjectMark: markRes.Mark,
+			Key:            watermarkIDKey,
+			Value:          id,
+		}); err != nil {
+			return fmt.Errorf("无法添加水印标记: %v", err)
+		}
+	}
+	return nil
+}
+
+// watermarkID 返回对象的水印标识，不是本程序添加的水印时返回空字符串
+func watermarkID(instance pdfium.Pdfium, obj references.FPDF_PAGEOBJECT) (string, error) {
+	countRes, err := instance.FPDFPageObj_CountMarks(&requests.FPDFPageObj_CountMarks{
+		PageObject: obj,
+	})
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < countRes.Count; i++ {
+		markRes, err := instance.FPDFPageObj_GetMark(&requests.FPDFPageObj_GetMark{
+			PageObject: obj,
+			Index:      uint64(i),
+		})
+		if err != nil {
+			return "", err
+		}
+		nameRes, err := instance.FPDFPageObjMark_GetName(&requests.FPDFPageObjMark_GetName{
+			PageObjectMark: markRes.Mark,
+		})
+		if err != nil || nameRes.Name != watermarkMarkName {
+			continue
+		}
+		// 其他程序也会用 Artifact 标记页眉页脚，只认带 WatermarkID 参数的
+		valueRes, err := instance.FPDFPageObjMark_GetParamStringValue(&requests.FPDFPageObjMark_GetParamStringValue{
+			PageObjectMark: markRes.Mark,
+			Key:            watermarkIDKey,
+		})
+		if err == nil && valueRes.Value != "" {
+			return valueRes.Value, nil
+		}
+	}
+	return "", nil
+}
+
+// pageWatermarks 返回页面上的水印对象及其标识
+func pageWatermarks(instance pdfium.Pdfium, page requests.Page) ([]references.FPDF_PAGEOBJECT, []string, error) {
+	countRes, err := instance.FPDFPage_CountObjects(&requests.FPDFPage_CountObjects{
+		Page: page,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var objects []references.FPDF_PAGEOBJECT
+	var ids []string
+	for i := 0; i < countRes.Count; i++ {
+		objRes, err := instance.FPDFPage_GetObject(&requests.FPDFPage_GetObject{
+			Page:  page,
+			Index: i,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		id, err := watermarkID(instance, objRes.PageObject)
+		if err != nil {
+			return nil, nil, err
+		}
+		if id != "" {
+			objects = append(objects, objRes.PageObject)
+			ids = append(ids, id)
+		}
+	}
+	return objects, ids, nil
+}
+
+// removeWatermarks 删除页面上 match 返回 true 的水印对象，返回删除的数量。
+// 调用方需要重新生成页面内容
+func removeWatermarks(instance pdfium.Pdfium, page requests.Page, match func(id string) bool) (int, error) {
+	objects, ids, err := pageWatermarks(instance, page)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for i, obj := range objects {
+		if !match(ids[i]) {
+			continue
+		}
+		if _, err := instance.FPDFPage_RemoveObject(&requests.FPDFPage_RemoveObject{
+			Page:       page,
+			PageObject: obj,
+		}); err != nil {
+			return removed, err
+		}
+		// 移出页面后的对象归调用方所有
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: obj,
+		})
+		removed++
+	}
+	return removed, nil
+}
+
+// FindWatermarks 列出文档中本程序添加的水印，按标识排序，不修改文档
+func FindWatermarks(ctx context.Context, instance pdfium.Pdfium, in PDFInput) ([]WatermarkInfo, error) {
+	byID := make(map[string]*WatermarkInfo)
+	err := eachPage(ctx, instance, in, func(document references.FPDF_DOCUMENT, page requests.Page, index int) (bool, error) {
+		_, ids, err := pageWatermarks(instance, page)
+		if err != nil {
+			return false, err
+		}
+		for _, id := range ids {
+			info, ok := byID[id]
+			if !ok {
+				info = &WatermarkInfo{ID: id}
+				byID[id] = info
+			}
+			if len(info.Pages) == 0 || info.Pages[len(info.Pages)-1] != index+1 {
+				info.Pages = append(info.Pages, index+1)
+			}
+			info.Objects++
+		}
+		return false, nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]WatermarkInfo, 0, len(byID))
+	for _, info := range byID {
+		found = append(found, *info)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ID < found[j].ID })
+	return found, nil
+}
+
+// RemoveWatermarks 删除指定标识的水印后保存，ids 为空时删除本程序添加的所有水印，
+// 返回删除的对象数量。原有内容不受影响
+func RemoveWatermarks(ctx context.Context, instance pdfium.Pdfium, in PDFInput, out PDFOutput, ids []string) (int, error) {
+	match := func(id string) bool {
+		if len(ids) == 0 {
+			return true
+		}
+		for _, want := range ids {
+			if id == want {
+				return true
+			}
+		}
+		return false
+	}
+
+	removed := 0
+	err := eachPage(ctx, instance, in, func(document references.FPDF_DOCUMENT, page requests.Page, index int) (bool, error) {
+		n, err := removeWatermarks(instance, page, match)
+		removed += n
+		if err != nil {
+			return false, fmt.Errorf("第 %d 页: %v", index+1, err)
+		}
+		return n > 0, nil
+	}, &out)
+	if err != nil {
+		return removed, err
+	}
+	return removed, nil
+}
+
+// eachPage 加载文档并依次打开每一页调用 fn，fn 返回 true 时重新生成该页内容。
+// out 不为空时处理完后保存文档
+func eachPage(ctx context.Context, instance pdfium.Pdfium, in PDFInput, fn func(document references.FPDF_DOCUMENT, page requests.Page, index int) (bool, error), out *PDFOutput) error {
+	document, err := LoadDocument(instance, in)
+	if err != nil {
+		return fmt.Errorf("无法加载 PDF 文档=%s: %w", in.name(), err)
+	}
+	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
+		Document: document,
+	})
+
+	pageCount, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
+		Document: document,
+	})
+	if err != nil {
+		return err
+	}
+	progress := ProgressEvent{Phase: PhaseWatermark, Pages: pageCount.PageCount}
+
+	var pdfPage *responses.FPDF_LoadPage
+	defer func() {
+		if pdfPage != nil {
+			instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
+				Page: pdfPage.Page,
+			})
+		}
+	}()
+
+	for pageIndex := 0; pageIndex < pageCount.PageCount; pageIndex++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		progress.Page = pageIndex + 1
+		reportProgress(ctx, progress)
+
+		pdfPage, err = instance.FPDF_LoadPage(&requests.FPDF_LoadPage{
+			Document: document,
+			Index:    pageIndex,
+		})
+		if err != nil {
+			return err
+		}
+		page := requests.Page{
+			ByReference: &pdfPage.Page,
+		}
+
+		changed, err := fn(document, page, pageIndex)
+		if err != nil {
+			return err
+		}
+		if changed {
+			if _, err = instance.FPDFPage_GenerateContent(&requests.FPDFPage_GenerateContent{
+				Page: page,
+			}); err != nil {
+				return err
+			}
+		}
+
+		_, err = instance.FPDF_ClosePage(&requests.FPDF_ClosePage{
+			Page: pdfPage.Page,
+		})
+		pdfPage = nil
+		if err != nil {
+			return err
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	progress.Phase = PhaseSave
+	reportProgress(ctx, progress)
+	return SaveDocument(instance, document, *out, 0)
+}
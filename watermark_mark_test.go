@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/stretchr/testify/assert"
+)
+
+// objectCounts 返回每一页的对象数量
+func objectCounts(t *testing.T, instance pdfium.Pdfium, data []byte) []int {
+	info, err := GetPDFInfo(context.Background(), instance, PDFInput{Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var counts []int
+	for _, p := range info.Pages {
+		counts = append(counts, p.Objects)
+	}
+	return counts
+}
+
+func TestWatermarkMarks(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	pdf := newTestPDF(t, processor, 2)
+	processor.Run(context.Background(), nil, nil)
+	instance, err := processor.pool.GetInstance(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	var logoData bytes.Buffer
+	assert.Nil(t, png.Encode(&logoData, image.NewGray(image.Rect(0, 0, 16, 16))))
+	logoPath := filepath.Join(t.TempDir(), "logo.png")
+	assert.Nil(t, os.WriteFile(logoPath, logoData.Bytes(), 0644))
+
+	ctx := context.Background()
+	original := objectCounts(t, instance, pdf)
+	found, err := FindWatermarks(ctx, instance, PDFInput{Data: pdf})
+	assert.Nil(t, err)
+	assert.Empty(t, found)
+
+	watermark := func(data []byte, opts WatermarkOptions) []byte {
+		var out bytes.Buffer
+		if _, err := Watermark(ctx, instance, logoPath, PDFInput{Data: data}, PDFOutput{Writer: &out}, opts); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+
+	// 再次添加同一标识的水印时替换旧的，放到内容下方时也一样
+	once := watermark(pdf, DefaultWatermarkOptions())
+	back := DefaultWatermarkOptions()
+	back.ZOrder = ZOrderBack
+	twice := watermark(once, back)
+	assert.Equal(t, objectCounts(t, instance, once), objectCounts(t, instance, twice))
+	result, err := Watermark(ctx, instance, logoPath, PDFInput{Data: once}, PDFOutput{Writer: &bytes.Buffer{}}, back)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Replaced)
+	found, err = FindWatermarks(ctx, instance, PDFInput{Data: twice})
+	assert.Nil(t, err)
+	assert.Equal(t, []WatermarkInfo{{ID: WatermarkIDLogo, Pages: []int{1, 2}, Objects: 2}}, found)
+
+	// 不同标识的水印叠加
+	tiled := DefaultWatermarkOptions()
+	tiled.ID, tiled.Tile = "draft", true
+	stacked := watermark(twice, tiled)
+	var out bytes.Buffer
+	_, err = AddStamps(ctx, instance, PDFInput{Data: stacked}, PDFOutput{Writer: &out}, StampOptions{Stamps: []StampSpec{DefaultStampSpec("{page}")}})
+	assert.Nil(t, err)
+	stacked = out.Bytes()
+	found, err = FindWatermarks(ctx, instance, PDFInput{Data: stacked})
+	assert.Nil(t, err)
+	if assert.Len(t, found, 3) {
+		assert.Equal(t, "draft", found[0].ID)
+		assert.Greater(t, found[0].Objects, 2)
+		assert.Equal(t, WatermarkIDLogo, found[1].ID)
+		assert.Equal(t, WatermarkInfo{ID: WatermarkIDStamp, Pages: []int{1, 2}, Objects: 2}, found[2])
+	}
+
+	// 按标识删除
+	var cleaned bytes.Buffer
+	removed, err := RemoveWatermarks(ctx, instance, PDFInput{Data: stacked}, PDFOutput{Writer: &cleaned}, []string{"draft", WatermarkIDStamp})
+	assert.Nil(t, err)
+	assert.Equal(t, found[0].Objects+2, removed)
+	assert.Equal(t, objectCounts(t, instance, twice), objectCounts(t, instance, cleaned.Bytes()))
+	for _, text := range pageTexts(t, instance, cleaned.Bytes()) {
+		assert.Empty(t, strings.TrimSpace(text))
+	}
+
+	// 删除全部后恢复原有的对象
+	var bare bytes.Buffer
+	removed, err = RemoveWatermarks(ctx, instance, PDFInput{Data: cleaned.Bytes()}, PDFOutput{Writer: &bare}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, removed)
+	assert.Equal(t, original, objectCounts(t, instance, bare.Bytes()))
+	found, err = FindWatermarks(ctx, instance, PDFInput{Data: bare.Bytes()})
+	assert.Nil(t, err)
+	assert.Empty(t, found)
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"compress-pdfium/util"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
+	"github.com/klippa-app/go-pdfium/requests"
+	"github.com/klippa-app/go-pdfium/responses"
+	"github.com/klippa-app/go-pdfium/structs"
+)
+
+// standardFonts PDF 标准 14 字体，阅读器内置，不需要嵌入
+var standardFonts = []string{
+	"Courier", "Courier-Bold", "Courier-BoldOblique", "Courier-Oblique",
+	"Helvetica", "Helvetica-Bold", "Helvetica-BoldOblique", "Helvetica-Oblique",
+	"Times-Roman", "Times-Bold", "Times-BoldItalic", "Times-Italic",
+	"Symbol", "ZapfDingbats",
+}
+
+// IsStandardFont 是否为标准 14 字体名称
+func IsStandardFont(name string) bool {
+	for _, f := range standardFonts {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Color RGBA 颜色，A 为 0 表示不绘制
+type Color struct {
+	R, G, B, A uint8
+}
+
+var namedColors = map[string]Color{
+	"none":  {},
+	"black": {0, 0, 0, 255},
+	"white": {255, 255, 255, 255},
+	"gray":  {128, 128, 128, 255},
+	"grey":  {128, 128, 128, 255},
+	"red":   {255, 0, 0, 255},
+	"green": {0, 128, 0, 255},
+	"blue":  {0, 0, 255, 255},
+}
+
+// ParseColor 解析颜色，支持 #rgb、#rrggbb、#rrggbbaa 和 black、red、none 等名称
+func ParseColor(s string) (Color, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if c, ok := namedColors[s]; ok {
+		return c, nil
+	}
+
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 8 || err != nil {
+		return Color{}, fmt.Errorf("无效的颜色 %q，应为 #rrggbb、#rrggbbaa 或 black、red、none 等名称", s)
+	}
+	return Color{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+func (c Color) String() string {
+	if c.A == 0 {
+		return "none"
+	}
+	if c.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+// Set 实现 flag.Value
+func (c *Color) Set(s string) error {
+	v, err := ParseColor(s)
+	if err != nil {
+		return err
+	}
+	*c = v
+	return nil
+}
+
+func (c Color) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Color) UnmarshalText(text []byte) error {
+	return c.Set(string(text))
+}
+
+// pdfColor 转为 pdfium 的颜色，不透明度乘到 alpha 上
+func (c Color) pdfColor(opacity float64) structs.FPDF_COLOR {
+	return structs.FPDF_COLOR{R: uint(c.R), G: uint(c.G), B: uint(c.B), A: uint(float64(c.A)*opacity + 0.5)}
+}
+
+// TextOptions 文字水印的内容和样式，位置、旋转和不透明度见 WatermarkOptions
+type TextOptions struct {
+	Text        string  `json:"text"`         // 按 \n 分行，各行居中对齐
+	Font        string  `json:"font"`         // 标准 14 字体名称，或 TTF 字体文件路径，中文等需要 TTF 字体
+	FontSize    float64 `json:"font_size"`    // 字号（点），为 0 时按 WatermarkOptions.Size 缩放
+	Color       Color   `json:"color"`        // 填充颜色，none 时只描边
+	StrokeColor Color   `json:"stroke_color"` // 描边颜色，默认不描边
+	StrokeWidth float64 `json:"stroke_width"` // 描边宽度（点）
+	LineSpacing float64 `json:"line_spacing"` // 行距，字号的倍数
+}
+
+// DefaultTextOptions 灰色 Helvetica，行距 1.2 倍
+func DefaultTextOptions() TextOptions {
+	return TextOptions{
+		Font:        "Helvetica",
+		Color:       Color{128, 128, 128, 255},
+		StrokeWidth: 1,
+		LineSpacing: 1.2,
+	}
+}
+
+// Validate 检查文字和样式
+func (o *TextOptions) Validate() error {
+	if strings.TrimSpace(o.Text) == "" {
+		return errors.New("水印文字为空")
+	}
+	if o.Font == "" {
+		o.Font = "Helvetica"
+	}
+	if err := checkFontText(o.Font, o.Text); err != nil {
+		return err
+	}
+	if o.FontSize < 0 {
+		return fmt.Errorf("font_size 不能小于 0: %g", o.FontSize)
+	}
+	if o.LineSpacing <= 0 {
+		o.LineSpacing = 1.2
+	}
+	if o.Color.A == 0 && o.StrokeColor.A == 0 {
+		return errors.New("填充和描边颜色不能都为 none")
+	}
+	if o.StrokeColor.A != 0 && o.StrokeWidth <= 0 {
+		return fmt.Errorf("stroke_width 必须大于 0: %g", o.StrokeWidth)
+	}
+	return nil
+}
+
+// checkFontText 检查字体能否显示文字，标准字体使用 WinAnsi 编码，只有西文字符
+func checkFontText(font, text string) error {
+	if !IsStandardFont(font) {
+		return nil
+	}
+	for _, r := range text {
+		if r > 0xff {
+			return fmt.Errorf("标准字体 %s 不支持字符 %q，请指定 TTF 字体", font, r)
+		}
+	}
+	return nil
+}
+
+// renderMode 根据是否填充、描边选择文字绘制方式
+func (o TextOptions) renderMode() enums.FPDF_TEXT_RENDERMODE {
+	switch {
+	case o.StrokeColor.A == 0:
+		return enums.FPDF_TEXTRENDERMODE_FILL
+	case o.Color.A == 0:
+		return enums.FPDF_TEXTRENDERMODE_STROKE
+	default:
+		return enums.FPDF_TEXTRENDERMODE_FILL_STROKE
+	}
+}
+
+// loadFont 在文档中加载字体，标准字体按名称加载，其余按 TTF 文件完整嵌入
+func loadFont(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, font string) (references.FPDF_FONT, error) {
+	if IsStandardFont(font) {
+		res, err := instance.FPDFText_LoadStandardFont(&requests.FPDFText_LoadStandardFont{
+			Document: document,
+			Font:     font,
+		})
+		if err != nil {
+			return "", fmt.Errorf("无法加载字体 %s: %v", font, err)
+		}
+		return res.Font, nil
+	}
+
+	data, err := os.ReadFile(font)
+	if err != nil {
+		return "", fmt.Errorf("无法读取字体文件: %v", err)
+	}
+	// CID 字体才能写入任意 Unicode 字符
+	res, err := instance.FPDFText_LoadFont(&requests.FPDFText_LoadFont{
+		Document: document,
+		Data:     data,
+		FontType: enums.FPDF_FONT_TRUETYPE,
+		CID:      true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("无法加载字体 %s: %v", font, err)
+	}
+	return res.Font, nil
+}
+
+// textLine 一行文字，X、Y 为字号 1 时基线起点在文字块中的位置
+type textLine struct {
+	Text string
+	X, Y float64
+}
+
+// textBlock 字号为 1 时排好的多行文字
+type textBlock struct {
+	Lines         []textLine
+	Width, Height float64
+}
+
+// layoutText 测量每行文字，按行距排列并水平居中，文字块的大小取字形的实际范围，
+// 边距从可见的文字边缘算起
+func layoutText(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, font references.FPDF_FONT, text string, lineSpacing float64) (textBlock, error) {
+	var block textBlock
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	bounds := make([]*responses.FPDFPageObj_GetBounds, len(lines))
+	top, bottom := math.Inf(-1), math.Inf(1)
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		obj, err := newTextObject(instance, document, font, line)
+		if err != nil {
+			return block, err
+		}
+		bounds[i], err = instance.FPDFPageObj_GetBounds(&requests.FPDFPageObj_GetBounds{
+			PageObject: obj,
+		})
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: obj,
+		})
+		if err != nil {
+			return block, fmt.Errorf("无法测量文字大小: %v", err)
+		}
+
+		// 第 i 行的基线在 -i*lineSpacing 处
+		baseline := -float64(i) * lineSpacing
+		block.Width = math.Max(block.Width, float64(bounds[i].Right-bounds[i].Left))
+		top = math.Max(top, baseline+float64(bounds[i].Top))
+		bottom = math.Min(bottom, baseline+float64(bounds[i].Bottom))
+	}
+	if block.Width == 0 {
+		return block, errors.New("水印文字为空")
+	}
+
+	block.Height = top - bottom
+	for i, line := range lines {
+		if bounds[i] == nil {
+			continue
+		}
+		block.Lines = append(block.Lines, textLine{
+			Text: line,
+			X:    (block.Width-float64(bounds[i].Right-bounds[i].Left))/2 - float64(bounds[i].Left),
+			Y:    -float64(i)*lineSpacing - bottom,
+		})
+	}
+	return block, nil
+}
+
+// newTextObject 创建字号为 1 的文字对象
+func newTextObject(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, font references.FPDF_FONT, text string) (references.FPDF_PAGEOBJECT, error) {
+	res, err := instance.FPDFPageObj_CreateTextObj(&requests.FPDFPageObj_CreateTextObj{
+		Document: document,
+		Font:     font,
+		FontSize: 1,
+	})
+	if err != nil {
+		return "", fmt.Errorf("无法创建文字对象: %v", err)
+	}
+	if _, err = instance.FPDFText_SetText(&requests.FPDFText_SetText{
+		PageObject: res.PageObject,
+		Text:       text,
+	}); err != nil {
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: res.PageObject,
+		})
+		return "", fmt.Errorf("无法设置文字: %v", err)
+	}
+	return res.PageObject, nil
+}
+
+// translate 返回先平移 (x, y) 再做 m 变换的矩阵
+func translate(m structs.FPDF_FS_MATRIX, x, y float64) structs.FPDF_FS_MATRIX {
+	m.E += m.A*float32(x) + m.C*float32(y)
+	m.F += m.B*float32(x) + m.D*float32(y)
+	return m
+}
+
+// textLayer 文字水印，每行一个文字对象
+type textLayer struct {
+	font  references.FPDF_FONT
+	block textBlock
+	text  TextOptions
+	opts  WatermarkOptions
+
+	// content 返回第 index 页的文字，为 nil 时各页都是 text.Text，只排版一次
+	content func(index int) string
+}
+
+func newTextLayer(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, text TextOptions, opts WatermarkOptions, content func(index int) string) (*textLayer, error) {
+	font, err := loadFont(instance, document, text.Font)
+	if err != nil {
+		return nil, err
+	}
+	l := &textLayer{font: font, text: text, opts: opts, content: content}
+	if content == nil {
+		if l.block, err = layoutText(instance, document, font, text.Text, text.LineSpacing); err != nil {
+			l.close(instance)
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *textLayer) objects(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, page *watermarkPage, index int) ([]references.FPDF_PAGEOBJECT, error) {
+	block := l.block
+	if l.content != nil {
+		text := l.content(index)
+		if strings.TrimSpace(text) == "" {
+			return nil, nil
+		}
+		if err := checkFontText(l.text.Font, text); err != nil {
+			return nil, err
+		}
+		var err error
+		if block, err = layoutText(instance, document, l.font, text, l.text.LineSpacing); err != nil {
+			return nil, err
+		}
+	}
+
+	width := block.Width * l.text.FontSize
+	if l.text.FontSize == 0 {
+		width = l.opts.Size * math.Min(page.Width, page.Height)
+	}
+	// 平铺时各处的文字对象共用同一个字体资源，每处只增加几十字节的内容流
+	boxes, err := page.layout(instance, l.opts, width, width*block.Height/block.Width)
+	if err != nil {
+		return nil, err
+	}
+
+	// 没有填充时按描边颜色计算亮度
+	base := l.text.Color
+	if base.A == 0 {
+		base = l.text.StrokeColor
+	}
+	luminance := util.RelativeLuminance(base.nrgba())
+	variant := l.text
+	variant.Color, variant.StrokeColor = l.text.Color.invertLightness(), l.text.StrokeColor.invertLightness()
+
+	var objects []references.FPDF_PAGEOBJECT
+	for _, box := range boxes {
+		choice, err := page.contrast(instance, l.opts, box, luminance, util.RelativeLuminance(base.invertLightness().nrgba()))
+		if err != nil {
+			destroyObjects(instance, objects)
+			return nil, err
+		}
+
+		// 底板、描边画在文字下方，描边是填充和描边都用 ContrastColor 的加粗文字
+		styles := []TextOptions{l.text}
+		switch {
+		case choice.variant:
+			styles[0] = variant
+		case choice.outline:
+			halo := l.text
+			halo.Color = l.opts.contrastColor(luminance)
+			halo.StrokeColor = halo.Color
+			if l.text.StrokeColor.A == 0 {
+				halo.StrokeWidth = 0
+			}
+			halo.StrokeWidth += 2 * l.opts.OutlineWidth
+			styles = []TextOptions{halo, l.text}
+		case choice.plate:
+			plate, err := newPlate(instance, box, l.opts.PlatePadding.Points(box.Width), l.opts.contrastColor(luminance), l.opts.Opacity)
+			if err != nil {
+				destroyObjects(instance, objects)
+				return nil, err
+			}
+			objects = append(objects, plate)
+		}
+
+		matrix := box.matrix(block.Width, block.Height)
+		for _, style := range styles {
+			for _, line := range block.Lines {
+				obj, err := l.newLine(instance, document, line, style, translate(matrix, line.X, line.Y))
+				if err != nil {
+					destroyObjects(instance, objects)
+					return nil, err
+				}
+				objects = append(objects, obj)
+			}
+		}
+	}
+	return objects, nil
+}
+
+// newLine 按 style 创建一行文字并设置颜色、描边和位置
+func (l *textLayer) newLine(instance pdfium.Pdfium, document references.FPDF_DOCUMENT, line textLine, style TextOptions, matrix structs.FPDF_FS_MATRIX) (references.FPDF_PAGEOBJECT, error) {
+	obj, err := newTextObject(instance, document, l.font, line.Text)
+	if err != nil {
+		return "", err
+	}
+
+	// 文字对象的填充、描边透明度会写入 ExtGState，不需要像图片那样处理像素
+	err = func() error {
+		if _, err := instance.FPDFPageObj_SetFillColor(&requests.FPDFPageObj_SetFillColor{
+			PageObject: obj,
+			FillColor:  style.Color.pdfColor(l.opts.Opacity),
+		}); err != nil {
+			return err
+		}
+		if style.StrokeColor.A != 0 {
+			if _, err := instance.FPDFPageObj_SetStrokeColor(&requests.FPDFPageObj_SetStrokeColor{
+				PageObject:  obj,
+				StrokeColor: style.StrokeColor.pdfColor(l.opts.Opacity),
+			}); err != nil {
+				return err
+			}
+			// 描边宽度在页面坐标中，不随字号缩放
+			if _, err := instance.FPDFPageObj_SetStrokeWidth(&requests.FPDFPageObj_SetStrokeWidth{
+				PageObject:  obj,
+				StrokeWidth: float32(style.StrokeWidth),
+			}); err != nil {
+				return err
+			}
+		}
+		if _, err := instance.FPDFTextObj_SetTextRenderMode(&requests.FPDFTextObj_SetTextRenderMode{
+			PageObject:     obj,
+			TextRenderMode: style.renderMode(),
+		}); err != nil {
+			return err
+		}
+		_, err := instance.FPDFPageObj_SetMatrix(&requests.FPDFPageObj_SetMatrix{
+			PageObject: obj,
+			Transform:  matrix,
+		})
+		return err
+	}()
+	if err != nil {
+		instance.FPDFPageObj_Destroy(&requests.FPDFPageObj_Destroy{
+			PageObject: obj,
+		})
+		return "", err
+	}
+	return obj, nil
+}
+
+func (l *textLayer) close(instance pdfium.Pdfium) {
+	instance.FPDFFont_Close(&requests.FPDFFont_Close{
+		Font: l.font,
+	})
+}
+
+// WatermarkText 在每一页添加文字水印，位置、大小、旋转和不透明度与图片水印相同
+func WatermarkText(ctx context.Context, instance pdfium.Pdfium, in PDFInput, out PDFOutput, text TextOptions, opts WatermarkOptions) (*WatermarkResult, error) {
+	if err := text.Validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.ID == "" {
+		opts.ID = WatermarkIDText
+	}
+	return applyWatermark(ctx, instance, in, out, opts.ZOrder, opts.ID, func(document references.FPDF_DOCUMENT) (watermarkLayer, error) {
+		return newTextLayer(instance, document, text, opts, nil)
+	})
+}
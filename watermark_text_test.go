@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseColor(t *testing.T) {
+	c, err := ParseColor("#ff8000")
+	assert.Nil(t, err)
+	assert.Equal(t, Color{255, 128, 0, 255}, c)
+
+	c, err = ParseColor("#f00")
+	assert.Nil(t, err)
+	assert.Equal(t, Color{255, 0, 0, 255}, c)
+
+	c, err = ParseColor("#00000080")
+	assert.Nil(t, err)
+	assert.Equal(t, Color{0, 0, 0, 128}, c)
+	assert.Equal(t, "#00000080", c.String())
+
+	c, err = ParseColor("None")
+	assert.Nil(t, err)
+	assert.Equal(t, Color{}, c)
+
+	_, err = ParseColor("#12345")
+	assert.NotNil(t, err)
+
+	var opts TextOptions
+	assert.Nil(t, json.Unmarshal([]byte(`{"color": "red", "stroke_color": "#0000ff"}`), &opts))
+	assert.Equal(t, Color{255, 0, 0, 255}, opts.Color)
+	assert.Equal(t, Color{0, 0, 255, 255}, opts.StrokeColor)
+}
+
+// redBounds 返回 PDF 坐标 y 大于 minY 的区域中红色像素的外接矩形（像素坐标）和最深的红色的 G 值
+func redBounds(img image.Image, minY int) (image.Rectangle, uint8) {
+	var bounds image.Rectangle
+	darkest := uint8(255)
+	height := img.Bounds().Dy()
+	for y := 0; y < height-minY; y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if r>>8 > 200 && g>>8 < 200 && g == b {
+				bounds = bounds.Union(image.Rect(x, y, x+1, y+1))
+				if uint8(g>>8) < darkest {
+					darkest = uint8(g >> 8)
+				}
+			}
+		}
+	}
+	return bounds, darkest
+}
+
+func TestWatermarkText(t *testing.T) {
+	processor, err := NewBatchProcessor(BatchConfig{Backend: BackendWebAssembly, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer processor.Close()
+
+	pdf := newTestPDF(t, processor, 2)
+	processor.Run(context.Background(), nil, nil)
+	instance, err := processor.pool.GetInstance(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	render := func(text TextOptions, opts WatermarkOptions) image.Image {
+		var out bytes.Buffer
+		if _, err := WatermarkText(context.Background(), instance, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, text, opts); err != nil {
+			t.Fatal(err)
+		}
+		return renderFirstPage(t, instance, out.Bytes())
+	}
+
+	// 测试图片在页面下方 450pt 以内，水印放在上方的空白处
+	text := DefaultTextOptions()
+	text.Text = "DRAFT\nDRAFT"
+	text.Color = Color{255, 0, 0, 255}
+	opts := DefaultWatermarkOptions()
+	opts.Anchor, opts.MarginY, opts.Size = AnchorTop, Length{Value: 10}, 0.5
+
+	bounds, _ := redBounds(render(text, opts), 460)
+	assert.InDelta(t, 297.5, bounds.Dx(), 3)
+	assert.InDelta(t, 595/2, (bounds.Min.X+bounds.Max.X)/2, 2)
+	assert.InDelta(t, 10, bounds.Min.Y, 2)
+	// 两行文字
+	assert.Greater(t, bounds.Dy(), bounds.Dx()/2)
+
+	// 指定字号时忽略 Size，半透明的红色在白底上变浅
+	single := text
+	single.Text, single.FontSize = "DRAFT", 40
+	opts.Opacity = 0.5
+	bounds, darkest := redBounds(render(single, opts), 460)
+	assert.Less(t, bounds.Dx(), 297/2)
+	assert.Less(t, bounds.Dy(), 40)
+	assert.InDelta(t, 128, darkest, 10)
+
+	// 只描边
+	outline := single
+	outline.Color, outline.StrokeColor = Color{}, Color{255, 0, 0, 255}
+	opts.Opacity = 1
+	bounds, _ = redBounds(render(outline, opts), 460)
+	assert.False(t, bounds.Empty())
+
+	// 平铺时覆盖整页，各处共用同一个字体，文件只增加内容流
+	tiled := single
+	tiled.Text, tiled.FontSize = "X", 0
+	opts = DefaultWatermarkOptions()
+	opts.Tile, opts.Size = true, 0.05
+	var tiledPDF bytes.Buffer
+	_, err = WatermarkText(context.Background(), instance, PDFInput{Data: pdf}, PDFOutput{Writer: &tiledPDF}, tiled, opts)
+	assert.Nil(t, err)
+	bounds, _ = redBounds(renderFirstPage(t, instance, tiledPDF.Bytes()), 460)
+	assert.Less(t, bounds.Min.X, 60)
+	assert.Greater(t, bounds.Max.X, 535)
+	assert.Less(t, bounds.Min.Y, 60)
+	assert.Less(t, tiledPDF.Len()-len(pdf), 20<<10)
+
+	// 标准字体只支持西文字符
+	var out bytes.Buffer
+	chinese := DefaultTextOptions()
+	chinese.Text = "机密"
+	_, err = WatermarkText(context.Background(), instance, PDFInput{Data: pdf}, PDFOutput{Writer: &out}, chinese, opts)
+	assert.NotNil(t, err)
+	assert.Zero(t, out.Len())
+}